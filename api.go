@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
 
 	httprouter "github.com/julienschmidt/httprouter"
 
@@ -11,9 +12,12 @@ import (
 	completion "github.com/polyfire/api/completion"
 	db "github.com/polyfire/api/db"
 	imageGeneration "github.com/polyfire/api/image_generation"
+	jobs "github.com/polyfire/api/jobs"
 	kv "github.com/polyfire/api/kv"
 	memory "github.com/polyfire/api/memory"
 	middlewares "github.com/polyfire/api/middlewares"
+	prompt "github.com/polyfire/api/prompt"
+	registry "github.com/polyfire/api/registry"
 	stt "github.com/polyfire/api/stt"
 	tts "github.com/polyfire/api/tts"
 	utils "github.com/polyfire/api/utils"
@@ -57,6 +61,17 @@ func main() {
 	GCS := utils.InitGCS()
 	DB := db.InitDB()
 
+	// The models of the registry can be changed without a deploy, either in the models
+	// table or in the file pointed by MODEL_REGISTRY_FILE.
+	registrySource := registry.Source(DB.GetRegistryModels)
+	if path := os.Getenv("MODEL_REGISTRY_FILE"); path != "" {
+		registrySource = registry.FileSource(path)
+	}
+	registry.Watch(context.Background(), registrySource, registry.ReloadInterval)
+
+	// The generation jobs interrupted by the last restart are run again
+	jobs.Resume(context.WithValue(context.Background(), utils.ContextKeyDB, DB))
+
 	router := httprouter.New()
 
 	// Auth Routes
@@ -93,6 +108,10 @@ func main() {
 		"/generate",
 		middlewares.Record(utils.Generate, middlewares.Auth(completion.Generate)),
 	)
+	router.POST(
+		"/generate/batch",
+		middlewares.Record(utils.GenerateBatch, middlewares.Auth(completion.GenerateBatch)),
+	)
 	router.GET(
 		"/chat/:id/history",
 		middlewares.Record(utils.ChatHistory, middlewares.Auth(completion.GetChatHistory)),
@@ -110,6 +129,43 @@ func main() {
 		"/chat/:id",
 		middlewares.Record(utils.ChatDelete, middlewares.Auth(completion.DeleteChat)),
 	)
+	router.GET(
+		"/chat/:id/export",
+		middlewares.Record(utils.ChatExport, middlewares.Auth(completion.ExportChat)),
+	)
+	router.POST(
+		"/chats/import",
+		middlewares.Record(utils.ChatImport, middlewares.Auth(completion.ImportChat)),
+	)
+	router.POST(
+		"/chat/:id/shares",
+		middlewares.Record(utils.ChatShareCreate, middlewares.Auth(completion.CreateChatShare)),
+	)
+	router.GET(
+		"/chat/:id/shares",
+		middlewares.Record(utils.ChatShareList, middlewares.Auth(completion.ListChatShares)),
+	)
+	router.DELETE(
+		"/chat/:id/shares/:share_id",
+		middlewares.Record(utils.ChatShareRevoke, middlewares.Auth(completion.RevokeChatShare)),
+	)
+	router.GET(
+		"/shared/chat/:token",
+		middlewares.Record(utils.ChatSharedGet, completion.GetSharedChat),
+	)
+	router.POST(
+		"/shared/chat/:token/fork",
+		middlewares.Record(utils.ChatSharedFork, middlewares.Auth(completion.ForkSharedChat)),
+	)
+	router.PUT(
+		"/chat/:id/branch",
+		middlewares.Record(utils.ChatBranch, middlewares.Auth(completion.SwitchChatBranch)),
+	)
+	router.POST(
+		"/jobs/generate",
+		middlewares.Record(utils.JobCreate, middlewares.Auth(jobs.CreateGeneration)),
+	)
+	router.GET("/jobs/:id", middlewares.Record(utils.JobGet, middlewares.Auth(jobs.Get)))
 	router.GET(
 		"/stream",
 		middlewares.Record(utils.Generate, middlewares.AuthStream(completion.Stream)),
@@ -148,5 +204,50 @@ func main() {
 	router.PUT("/kv", middlewares.Record(utils.KVSet, middlewares.Auth(kv.Set)))
 	router.DELETE("/kv", middlewares.Record(utils.KVDelete, middlewares.Auth(kv.Delete)))
 
+	// Prompt Routes
+	router.GET("/prompts", middlewares.Record(utils.PromptList, middlewares.Auth(prompt.List)))
+	router.POST("/prompts", middlewares.Record(utils.PromptCreate, middlewares.Auth(prompt.Create)))
+	router.GET(
+		"/prompts/public",
+		middlewares.Record(utils.PromptLibrary, middlewares.Auth(prompt.ListPublic)),
+	)
+	router.GET("/prompt/:id", middlewares.Record(utils.PromptGet, middlewares.Auth(prompt.Get)))
+	router.PUT(
+		"/prompt/:id",
+		middlewares.Record(utils.PromptUpdate, middlewares.Auth(prompt.Update)),
+	)
+	router.DELETE(
+		"/prompt/:id",
+		middlewares.Record(utils.PromptDelete, middlewares.Auth(prompt.Delete)),
+	)
+	router.GET(
+		"/prompt/:id/versions",
+		middlewares.Record(utils.PromptVersionList, middlewares.Auth(prompt.ListVersions)),
+	)
+	router.GET(
+		"/prompt/:id/experiments",
+		middlewares.Record(utils.PromptExperimentList, middlewares.Auth(prompt.ListExperiments)),
+	)
+	router.POST(
+		"/prompt/:id/experiments",
+		middlewares.Record(utils.PromptExperimentCreate, middlewares.Auth(prompt.CreateExperiment)),
+	)
+	router.DELETE(
+		"/prompt/:id/experiments/:experiment_id",
+		middlewares.Record(utils.PromptExperimentStop, middlewares.Auth(prompt.StopExperiment)),
+	)
+	router.POST(
+		"/prompt/:id/like",
+		middlewares.Record(utils.PromptLike, middlewares.Auth(prompt.Like)),
+	)
+	router.DELETE(
+		"/prompt/:id/like",
+		middlewares.Record(utils.PromptUnlike, middlewares.Auth(prompt.Unlike)),
+	)
+	router.POST(
+		"/prompt/:id/fork",
+		middlewares.Record(utils.PromptFork, middlewares.Auth(prompt.Fork)),
+	)
+
 	log.Fatal(http.ListenAndServe(":8080", GlobalMiddleware(router, DB, GCS)))
 }
@@ -0,0 +1,191 @@
+package completion
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	router "github.com/julienschmidt/httprouter"
+	"github.com/polyfire/api/llm"
+	options "github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/registry"
+	utils "github.com/polyfire/api/utils"
+)
+
+const (
+	MaxBatchSize            = 1000
+	DefaultBatchConcurrency = 4
+	MaxBatchConcurrency     = 16
+)
+
+type BatchRequestBody struct {
+	Items       []GenerateRequestBody `json:"items"`
+	Concurrency int                   `json:"concurrency,omitempty"`
+}
+
+// The body can also be the array of items alone
+func (b *BatchRequestBody) UnmarshalJSON(data []byte) error {
+	var items []GenerateRequestBody
+	if err := json.Unmarshal(data, &items); err == nil {
+		*b = BatchRequestBody{Items: items}
+		return nil
+	}
+
+	type batchRequestBody BatchRequestBody
+	var body batchRequestBody
+	if err := json.Unmarshal(data, &body); err != nil {
+		return err
+	}
+
+	*b = BatchRequestBody(body)
+	return nil
+}
+
+type BatchResponse struct {
+	// The results are in the order of the items, a failed item has its error set
+	Results    []json.RawMessage  `json:"results"`
+	TokenUsage options.TokenUsage `json:"token_usage"`
+}
+
+/*
+ * CheckBatchRateLimit checks the rate limit once for the whole batch. The prompts of the
+ * items billed to the project must also fit in the remaining monthly limit of the project
+ * user and in the remaining credits, their answers can't be known in advance so they are
+ * not counted.
+ */
+func CheckBatchRateLimit(ctx context.Context, items []GenerateRequestBody) error {
+	providers := make(map[string]llm.Provider)
+
+	billed := false
+	estimatedCredits := 0
+
+	for _, item := range items {
+		provider, ok := providers[item.Model]
+		if !ok {
+			var err error
+			provider, err = llm.NewProvider(ctx, item.Model)
+			if err != nil {
+				// The item fails on its own when it is generated
+				continue
+			}
+			providers[item.Model] = provider
+		}
+
+		if !provider.DoesFollowRateLimit() {
+			continue
+		}
+		billed = true
+
+		providerName, modelName := provider.ProviderModel()
+		if m, ok := registry.Find(providerName, modelName); ok {
+			budget := GetContextBudget(providerName, modelName, item)
+			estimatedCredits += m.Credits(budget.TaskTokens, 0)
+		}
+	}
+
+	if !billed {
+		return nil
+	}
+
+	if err := CheckRateLimit(ctx); err != nil {
+		return err
+	}
+
+	// The monthly rate limit of the project user is in credits too
+	rateLimit, ok := ctx.Value(utils.ContextKeyProjectUserRateLimit).(*int64)
+	if ok && rateLimit != nil {
+		usage, _ := ctx.Value(utils.ContextKeyProjectUserUsage).(int64)
+		if usage+int64(estimatedCredits) > *rateLimit {
+			return ErrRateLimitReached
+		}
+	}
+
+	credits, ok := ctx.Value(utils.ContextKeyCredits).(int64)
+	if ok && int64(estimatedCredits) > credits {
+		return ErrCreditsUsedUp
+	}
+
+	return nil
+}
+
+func GenerationBatch(
+	ctx context.Context,
+	userID string,
+	input BatchRequestBody,
+) BatchResponse {
+	concurrency := input.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	if concurrency > MaxBatchConcurrency {
+		concurrency = MaxBatchConcurrency
+	}
+
+	results := make([]options.Result, len(input.Items))
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+
+	for i, item := range input.Items {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(i int, item GenerateRequestBody) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			resChan, err := GenerationStart(ctx, userID, item)
+			if err != nil {
+				results[i] = options.Result{Err: ErrorCode(err)}
+				return
+			}
+
+			results[i] = CollectResult(resChan, item)
+		}(i, item)
+	}
+
+	wg.Wait()
+
+	response := BatchResponse{Results: make([]json.RawMessage, len(results))}
+	for i, result := range results {
+		response.Results[i], _ = result.JSON()
+		response.TokenUsage.Input += result.TokenUsage.Input
+		response.TokenUsage.Output += result.TokenUsage.Output
+	}
+
+	return response
+}
+
+func GenerateBatch(w http.ResponseWriter, r *http.Request, _ router.Params) {
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	var input BatchRequestBody
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		utils.RespondError(w, record, "invalid_json")
+		return
+	}
+
+	if len(input.Items) == 0 || len(input.Items) > MaxBatchSize {
+		utils.RespondError(w, record, "invalid_batch_size")
+		return
+	}
+
+	err = CheckBatchRateLimit(r.Context(), input.Items)
+	if err != nil {
+		ReturnErrors(w, record, err)
+		return
+	}
+
+	response := GenerationBatch(r.Context(), userID, input)
+
+	w.Header()["Content-Type"] = []string{"application/json"}
+
+	responseJSON, _ := json.Marshal(response)
+	record(string(responseJSON))
+
+	_, _ = w.Write(responseJSON)
+}
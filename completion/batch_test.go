@@ -0,0 +1,69 @@
+package completion
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/utils"
+)
+
+func TestGenerationBatch(t *testing.T) {
+	utils.SetLogLevel("WARN")
+	ctx := utils.MockOpenAIServer(context.Background())
+
+	ctx = context.WithValue(
+		ctx,
+		utils.ContextKeyDB,
+		database.MockDatabase{MockLogRequests: mockLogRequests},
+	)
+	ctx = context.WithValue(ctx, utils.ContextKeyRateLimitStatus, database.RateLimitStatusOk)
+	ctx = context.WithValue(ctx, utils.ContextKeyCreditsStatus, database.CreditsStatusOk)
+	ctx = context.WithValue(ctx, utils.ContextKeyEventID, "00000000-0000-0000-0000-000000000000")
+
+	var input BatchRequestBody
+	err := json.Unmarshal([]byte(`[{"task": "Test"}, {"task": "Test", "max_tokens": 0}]`), &input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CheckBatchRateLimit(ctx, input.Items); err != nil {
+		t.Fatalf("The batch should be allowed, got %v", err)
+	}
+
+	response := GenerationBatch(ctx, "00000000-0000-0000-0000-000000000000", input)
+
+	var results []struct {
+		Result string          `json:"result"`
+		Error  *utils.APIError `json:"error"`
+	}
+	raw, _ := json.Marshal(response.Results)
+	if err := json.Unmarshal(raw, &results); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 || results[0].Result != "Test response" || results[0].Error != nil {
+		t.Fatalf("The first item should have succeeded, got %v", results)
+	}
+
+	if results[1].Error == nil || results[1].Error.Code != "invalid_max_tokens" {
+		t.Fatalf("The second item should have failed on its own, got %v", results[1])
+	}
+
+	if response.TokenUsage.Input == 0 || response.TokenUsage.Output == 0 {
+		t.Fatalf("The token usage should be aggregated, got %v", response.TokenUsage)
+	}
+
+	ctx = context.WithValue(ctx, utils.ContextKeyCredits, int64(0))
+	if err := CheckBatchRateLimit(ctx, input.Items); err != ErrCreditsUsedUp {
+		t.Fatalf("The batch should exceed the credits, got %v", err)
+	}
+
+	rateLimit := int64(100)
+	ctx = context.WithValue(ctx, utils.ContextKeyProjectUserRateLimit, &rateLimit)
+	ctx = context.WithValue(ctx, utils.ContextKeyProjectUserUsage, rateLimit)
+	if err := CheckBatchRateLimit(ctx, input.Items); err != ErrRateLimitReached {
+		t.Fatalf("The batch should exceed the monthly limit of the user, got %v", err)
+	}
+}
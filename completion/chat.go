@@ -31,13 +31,53 @@ func CreateChat(w http.ResponseWriter, r *http.Request, _ router.Params) {
 		return
 	}
 
+	// The prompt can be pinned to a version with "slug@version"
+	var systemPromptVersion *int
+	if requestBody.SystemPromptID != nil {
+		idOrSlug, version, err := database.ParsePromptRef(*requestBody.SystemPromptID)
+		if err != nil {
+			utils.RespondError(w, record, "invalid_prompt_version")
+			return
+		}
+		requestBody.SystemPromptID = &idOrSlug
+		systemPromptVersion = version
+	}
+
 	systemPromptID, err := db.RetrieveSystemPromptID(requestBody.SystemPromptID)
 	if err != nil {
 		utils.RespondError(w, record, "error_retrieving_system_prompt_id")
 		return
 	}
 
-	chat, err := db.CreateChat(userID, requestBody.SystemPrompt, systemPromptID, requestBody.Name)
+	// The chat can only use the user's own prompts and the public ones
+	if systemPromptID != nil {
+		p, err := db.GetPromptByIDOrSlug(*systemPromptID)
+		if err != nil || p == nil || (!p.Public && p.UserID != userID) {
+			utils.RespondError(w, record, "not_found")
+			return
+		}
+	}
+
+	if systemPromptVersion != nil {
+		if systemPromptID == nil {
+			utils.RespondError(w, record, "invalid_prompt_version")
+			return
+		}
+
+		_, err = db.GetPromptVersion(*systemPromptID, *systemPromptVersion)
+		if err != nil {
+			utils.RespondError(w, record, "prompt_version_not_found")
+			return
+		}
+	}
+
+	chat, err := db.CreateChat(
+		userID,
+		requestBody.SystemPrompt,
+		systemPromptID,
+		systemPromptVersion,
+		requestBody.Name,
+	)
 	if err != nil {
 		log.Printf("Error creating chat for user %s : %v", userID, err)
 		utils.RespondError(w, record, "error_create_chat", err.Error())
@@ -144,10 +184,101 @@ func GetChatHistory(w http.ResponseWriter, r *http.Request, ps router.Params) {
 	_ = json.NewEncoder(w).Encode(messages)
 }
 
+func SwitchChatBranch(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	var requestBody struct {
+		MessageID string `json:"message_id"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestBody); err != nil {
+		utils.RespondError(w, record, "decode_error")
+		return
+	}
+
+	chat, err := db.SwitchChatBranch(userID, ps.ByName("id"), requestBody.MessageID)
+	if err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	if chat == nil {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	response, _ := json.Marshal(&chat)
+	record(string(response))
+
+	_, _ = w.Write(response)
+}
+
+/*
+ * BranchChat moves the end of the active branch of the chat to the parent of the message
+ * edited or regenerated, so the generation adds a sibling to it. The previous branch stays
+ * available to SwitchChatBranch. The returned function moves the end of the active branch
+ * back, for when the generation doesn't add its answer. It's nil when nothing was moved.
+ */
+func BranchChat(
+	ctx context.Context,
+	userID string,
+	chatID string,
+	input GenerateRequestBody,
+) (func(), error) {
+	db := ctx.Value(utils.ContextKeyDB).(database.Database)
+
+	messageID := input.EditMessageID
+	isEdit := true
+	if input.RegenerateMessageID != nil {
+		messageID = input.RegenerateMessageID
+		isEdit = false
+	}
+
+	if messageID == nil {
+		return nil, nil
+	}
+
+	hasTask := input.Task != "" || len(input.Images) > 0
+	if (input.EditMessageID != nil && input.RegenerateMessageID != nil) || isEdit != hasTask {
+		return nil, ErrInvalidChatBranch
+	}
+
+	chat, err := db.GetChatByID(chatID)
+	if err != nil || chat == nil || chat.UserID != userID {
+		return nil, ErrNotFound
+	}
+
+	message, err := db.GetChatMessage(chatID, *messageID)
+	if err != nil || message == nil {
+		return nil, ErrNotFound
+	}
+
+	if message.IsUserMessage != isEdit {
+		return nil, ErrInvalidChatBranch
+	}
+
+	if err := db.SetChatActiveMessage(chatID, message.ParentID); err != nil {
+		return nil, ErrInternalServerError
+	}
+
+	previousMessageID := chat.ActiveMessageID
+	restore := func() {
+		if err := db.SetChatActiveMessage(chatID, previousMessageID); err != nil {
+			log.Printf("[ERROR] Error restoring the active branch of chat %s : %v", chatID, err)
+		}
+	}
+
+	return restore, nil
+}
+
 func AddToChatHistory(
 	ctx context.Context,
 	userID string,
 	task string,
+	images []string,
 	chatID string,
 	callback options.ProviderCallback,
 	opts *options.ProviderOptions,
@@ -170,16 +301,17 @@ func AddToChatHistory(
 			oldCallback(providerName, modelName, inputCount, outputCount, completion, credit)
 		}
 
-		log.Println("Add Chat Message")
-		err = db.AddChatMessage(chat.ID, true, task)
-		if err != nil {
-			log.Printf("Error adding chat message for user %s : %v", userID, err)
+		// Follow-up requests only sending back tool results don't have a task
+		if task != "" || len(images) > 0 {
+			log.Println("Add Chat Message")
+			err = db.AddChatMessage(chat.ID, true, task, images)
+			if err != nil {
+				log.Printf("Error adding chat message for user %s : %v", userID, err)
+			}
 		}
 		log.Println("Add Chat Message Callback")
-		_ = db.AddChatMessage(chat.ID, false, completion)
+		_ = db.AddChatMessage(chat.ID, false, completion, nil)
 	}
 
-	opts.StopWords = &[]string{"User:", "You:"}
-
 	return nil
 }
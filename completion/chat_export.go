@@ -0,0 +1,444 @@
+package completion
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	router "github.com/julienschmidt/httprouter"
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/utils"
+)
+
+// The version of the format of the exports, increased when it changes in a breaking way
+const ChatExportFormatVersion = 1
+
+const MaxImportedChatMessages = 1000
+
+// The body of an import is limited before being read, its messages can have inlined images
+const MaxChatImportSize = 32 << 20
+
+type ChatExportMessage struct {
+	ID        string       `json:"id,omitempty"`
+	ParentID  *string      `json:"parent_id,omitempty"`
+	Role      options.Role `json:"role"`
+	Content   string       `json:"content"`
+	Images    []string     `json:"images,omitempty"`
+	CreatedAt *time.Time   `json:"created_at,omitempty"`
+}
+
+/*
+ * ChatExport has the messages of every branch of the chat, from the oldest. When the chat
+ * uses a saved prompt, system_prompt is its text so the chat can be imported where the
+ * prompt isn't available.
+ */
+type ChatExport struct {
+	FormatVersion       int                 `json:"format_version"`
+	ID                  string              `json:"id,omitempty"`
+	Name                *string             `json:"name,omitempty"`
+	CreatedAt           *time.Time          `json:"created_at,omitempty"`
+	SystemPrompt        *string             `json:"system_prompt,omitempty"`
+	SystemPromptID      *string             `json:"system_prompt_id,omitempty"`
+	SystemPromptVersion *int                `json:"system_prompt_version,omitempty"`
+	ActiveMessageID     *string             `json:"active_message_id,omitempty"`
+	Messages            []ChatExportMessage `json:"messages"`
+}
+
+// The prompt is only exported if the user can still see it, it could have been made private
+func getChatSystemPrompt(db database.Database, userID string, chat *database.Chat) *string {
+	if chat.SystemPromptID == nil {
+		return chat.SystemPrompt
+	}
+
+	p, err := db.GetPromptByIDOrSlug(*chat.SystemPromptID)
+	if err != nil || p == nil || (!p.Public && p.UserID != userID) {
+		return chat.SystemPrompt
+	}
+
+	if chat.SystemPromptVersion != nil && *chat.SystemPromptVersion != p.Version {
+		pv, err := db.GetPromptVersion(p.ID, *chat.SystemPromptVersion)
+		if err != nil || pv == nil {
+			return chat.SystemPrompt
+		}
+		return &pv.Prompt
+	}
+
+	return &p.Prompt
+}
+
+func buildChatExport(
+	chat *database.Chat,
+	systemPrompt *string,
+	messages []database.ChatMessage,
+) ChatExport {
+	export := ChatExport{
+		FormatVersion:       ChatExportFormatVersion,
+		ID:                  chat.ID,
+		Name:                chat.Name,
+		SystemPrompt:        systemPrompt,
+		SystemPromptID:      chat.SystemPromptID,
+		SystemPromptVersion: chat.SystemPromptVersion,
+		ActiveMessageID:     chat.ActiveMessageID,
+		Messages:            make([]ChatExportMessage, 0, len(messages)),
+	}
+	if !chat.CreatedAt.IsZero() {
+		export.CreatedAt = &chat.CreatedAt
+	}
+
+	for _, message := range messages {
+		exported := ChatExportMessage{
+			ParentID: message.ParentID,
+			Role:     options.RoleAssistant,
+			Content:  message.Content,
+			Images:   message.Images,
+		}
+		if message.ID != nil {
+			exported.ID = *message.ID
+		}
+		if message.IsUserMessage {
+			exported.Role = options.RoleUser
+		}
+		if createdAt, err := time.Parse(time.RFC3339Nano, message.CreatedAt); err == nil {
+			exported.CreatedAt = &createdAt
+		}
+
+		export.Messages = append(export.Messages, exported)
+	}
+
+	return export
+}
+
+// activeBranch returns the messages of the active branch of the export, from the oldest
+func activeBranch(export ChatExport) []ChatExportMessage {
+	byID := make(map[string]ChatExportMessage)
+	for _, message := range export.Messages {
+		byID[message.ID] = message
+	}
+
+	var branch []ChatExportMessage
+	for id := export.ActiveMessageID; id != nil; {
+		message, ok := byID[*id]
+		if !ok {
+			break
+		}
+		delete(byID, *id) // A cycle can't loop forever
+		branch = append([]ChatExportMessage{message}, branch...)
+		id = message.ParentID
+	}
+
+	return branch
+}
+
+func quoteMarkdown(text string) string {
+	return "> " + strings.ReplaceAll(text, "\n", "\n> ")
+}
+
+// chatMarkdown renders the active branch of the chat, for reading rather than importing
+func chatMarkdown(export ChatExport) string {
+	var sb strings.Builder
+
+	name := "Chat"
+	if export.Name != nil && *export.Name != "" {
+		name = *export.Name
+	}
+	sb.WriteString("# " + name + "\n\n")
+
+	if export.CreatedAt != nil {
+		sb.WriteString("Created on " + export.CreatedAt.UTC().Format(time.RFC1123) + "\n\n")
+	}
+
+	if export.SystemPrompt != nil && *export.SystemPrompt != "" {
+		sb.WriteString("## System prompt\n\n" + quoteMarkdown(*export.SystemPrompt) + "\n\n")
+	}
+
+	for _, message := range activeBranch(export) {
+		if message.Role == options.RoleUser {
+			sb.WriteString("## User\n\n")
+		} else {
+			sb.WriteString("## Assistant\n\n")
+		}
+
+		sb.WriteString(message.Content + "\n\n")
+		for _, image := range message.Images {
+			sb.WriteString("![image](" + image + ")\n\n")
+		}
+	}
+
+	return sb.String()
+}
+
+func ExportChat(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "markdown" {
+		utils.RespondError(w, record, "invalid_export_format")
+		return
+	}
+
+	chat, err := db.GetChatByID(ps.ByName("id"))
+	if err != nil || chat == nil || chat.UserID != userID {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	messages, err := db.GetAllChatMessages(userID, chat.ID)
+	if err != nil {
+		utils.RespondError(w, record, "error_chat_history")
+		return
+	}
+
+	export := buildChatExport(chat, getChatSystemPrompt(db, userID, chat), messages)
+
+	var response []byte
+	if format == "markdown" {
+		response = []byte(chatMarkdown(export))
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"chat-"+chat.ID+".md\"")
+	} else {
+		response, _ = json.Marshal(export)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"chat-"+chat.ID+".json\"")
+	}
+	record(string(response))
+
+	_, _ = w.Write(response)
+}
+
+type chatImportMessage struct {
+	ChatExportMessage
+	// Either a string or, like in the OpenAI API, a list of text and image_url parts
+	Content json.RawMessage `json:"content"`
+}
+
+// The body of an import is either an export or an OpenAI-style list of messages
+type chatImport struct {
+	ChatExport
+	Messages []chatImportMessage `json:"messages"`
+}
+
+// The images can be in the content or, like in the exports, next to it
+func parseImportedContent(raw json.RawMessage, images []string) (string, []string, error) {
+	for i, image := range images {
+		var err error
+		if images[i], err = options.NormalizeImage(image); err != nil {
+			return "", nil, errors.New("A message has an invalid image.")
+		}
+	}
+
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", images, nil
+	}
+
+	var content string
+	if err := json.Unmarshal(raw, &content); err == nil {
+		return content, images, nil
+	}
+
+	var parts []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		ImageURL struct {
+			URL string `json:"url"`
+		} `json:"image_url"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", nil, errors.New("The content of a message must be a string or a list of parts.")
+	}
+
+	var texts []string
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			texts = append(texts, part.Text)
+		case "image_url":
+			image, err := options.NormalizeImage(part.ImageURL.URL)
+			if err != nil {
+				return "", nil, errors.New("A message has an invalid image.")
+			}
+			images = append(images, image)
+		}
+	}
+
+	return strings.Join(texts, "\n"), images, nil
+}
+
+// importedParent returns the index of the parent of the message among the imported ones
+func importedParent(
+	message chatImportMessage,
+	isTree bool,
+	indexes map[string]int,
+	count int,
+) (int, error) {
+	if !isTree {
+		return count - 1, nil
+	}
+
+	if message.ParentID == nil {
+		return -1, nil
+	}
+
+	parent, ok := indexes[*message.ParentID]
+	if !ok {
+		return 0, fmt.Errorf("The parent of message %q must come before it.", message.ID)
+	}
+
+	return parent, nil
+}
+
+/*
+ * parseChatImport turns the imported messages into the messages of the chat. The messages
+ * of an export keep their branches, the others follow each other. The system messages
+ * become the system prompt and the tool messages are left out, as the chats don't store
+ * them.
+ */
+func parseChatImport(input chatImport) (database.Chat, []database.ChatMessageImport, int, error) {
+	chat := database.Chat{
+		Name:                input.Name,
+		SystemPrompt:        input.SystemPrompt,
+		SystemPromptID:      input.SystemPromptID,
+		SystemPromptVersion: input.SystemPromptVersion,
+	}
+
+	if len(input.Messages) > MaxImportedChatMessages {
+		err := fmt.Errorf("A chat can't have more than %d messages.", MaxImportedChatMessages)
+		return chat, nil, 0, err
+	}
+
+	isTree := false
+	for _, message := range input.Messages {
+		isTree = isTree || message.ID != ""
+	}
+
+	var systemPrompts []string
+	messages := make([]database.ChatMessageImport, 0, len(input.Messages))
+	indexes := make(map[string]int)
+
+	for _, message := range input.Messages {
+		content, images, err := parseImportedContent(message.Content, message.Images)
+		if err != nil {
+			return chat, nil, 0, err
+		}
+
+		switch message.Role {
+		case options.RoleSystem:
+			systemPrompts = append(systemPrompts, content)
+			continue
+		case options.RoleTool:
+			continue
+		case options.RoleUser, options.RoleAssistant:
+		default:
+			return chat, nil, 0, fmt.Errorf("Unknown message role %q.", message.Role)
+		}
+
+		parent, err := importedParent(message, isTree, indexes, len(messages))
+		if err != nil {
+			return chat, nil, 0, err
+		}
+
+		if message.ID != "" {
+			indexes[message.ID] = len(messages)
+		}
+		messages = append(messages, database.ChatMessageImport{
+			Parent:        parent,
+			IsUserMessage: message.Role == options.RoleUser,
+			Content:       content,
+			Images:        images,
+			CreatedAt:     message.CreatedAt,
+		})
+	}
+
+	if chat.SystemPrompt == nil && len(systemPrompts) > 0 {
+		systemPrompt := strings.Join(systemPrompts, "\n")
+		chat.SystemPrompt = &systemPrompt
+	}
+
+	activeMessage := len(messages) - 1
+	if input.ActiveMessageID != nil {
+		if index, ok := indexes[*input.ActiveMessageID]; ok {
+			activeMessage = index
+		}
+	}
+
+	return chat, messages, activeMessage, nil
+}
+
+// The saved prompt of the chat is kept when the user can use it, else its text is
+func resolveImportedSystemPrompt(db database.Database, userID string, chat *database.Chat) {
+	if chat.SystemPromptID == nil {
+		chat.SystemPromptVersion = nil
+		return
+	}
+
+	p, err := db.GetPromptByIDOrSlug(*chat.SystemPromptID)
+	if err != nil || p == nil || (!p.Public && p.UserID != userID) {
+		chat.SystemPromptID, chat.SystemPromptVersion = nil, nil
+		return
+	}
+	chat.SystemPromptID = &p.ID
+
+	if chat.SystemPromptVersion != nil {
+		if pv, err := db.GetPromptVersion(p.ID, *chat.SystemPromptVersion); err != nil || pv == nil {
+			chat.SystemPromptVersion = nil
+		}
+	}
+
+	chat.SystemPrompt = nil
+}
+
+func ImportChat(w http.ResponseWriter, r *http.Request, _ router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxChatImportSize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		utils.RespondError(w, record, "chat_import_too_large")
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, record, "decode_error")
+		return
+	}
+
+	var input chatImport
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		err = json.Unmarshal(body, &input.Messages)
+	} else {
+		err = json.Unmarshal(body, &input)
+	}
+	if err != nil {
+		utils.RespondError(w, record, "decode_error")
+		return
+	}
+
+	chat, messages, activeMessage, err := parseChatImport(input)
+	if err != nil {
+		utils.RespondError(w, record, "invalid_chat_import", err.Error())
+		return
+	}
+	chat.UserID = userID
+
+	resolveImportedSystemPrompt(db, userID, &chat)
+
+	imported, err := db.ImportChat(chat, messages, activeMessage)
+	if err != nil {
+		utils.RespondError(w, record, "error_create_chat", err.Error())
+		return
+	}
+
+	response, _ := json.Marshal(imported)
+	record(string(response))
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(response)
+}
@@ -0,0 +1,130 @@
+package completion
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	router "github.com/julienschmidt/httprouter"
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/utils"
+)
+
+func TestChatExportRoundTrip(t *testing.T) {
+	id := func(s string) *string { return &s }
+	name := "Support"
+
+	// "answer" has been regenerated into "regenerated", the active branch
+	chat := &database.Chat{ID: "chat", Name: &name, ActiveMessageID: id("regenerated")}
+	messages := []database.ChatMessage{
+		{ID: id("question"), IsUserMessage: true, Content: "I can't log in"},
+		{ID: id("answer"), ParentID: id("question"), Content: "Old answer"},
+		{ID: id("regenerated"), ParentID: id("question"), Content: "New answer"},
+	}
+
+	export := buildChatExport(chat, id("Be helpful"), messages)
+
+	markdown := chatMarkdown(export)
+	if !strings.Contains(markdown, "# Support") || !strings.Contains(markdown, "New answer") {
+		t.Fatalf("The markdown should have the active branch, got %q", markdown)
+	}
+	if strings.Contains(markdown, "Old answer") {
+		t.Fatal("The markdown shouldn't have the other branches")
+	}
+
+	body, _ := json.Marshal(export)
+	var input chatImport
+	if err := json.Unmarshal(body, &input); err != nil {
+		t.Fatal(err)
+	}
+
+	imported, importedMessages, activeMessage, err := parseChatImport(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *imported.SystemPrompt != "Be helpful" || len(importedMessages) != 3 || activeMessage != 2 {
+		t.Fatalf("Unexpected import %v %v %d", imported, importedMessages, activeMessage)
+	}
+	if importedMessages[1].Parent != 0 || importedMessages[2].Parent != 0 {
+		t.Fatal("The branches should be kept")
+	}
+}
+
+func TestOpenAIMessagesImport(t *testing.T) {
+	var input chatImport
+	err := json.Unmarshal([]byte(`[
+		{"role": "system", "content": "Be helpful"},
+		{"role": "user", "content": [
+			{"type": "text", "text": "What's this?"},
+			{"type": "image_url", "image_url": {"url": "https://example.com/cat.png"}}
+		]},
+		{"role": "assistant", "content": "A cat"},
+		{"role": "tool", "tool_call_id": "call", "content": "ignored"},
+		{"role": "user", "content": "Thanks"}
+	]`), &input.Messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chat, messages, activeMessage, err := parseChatImport(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if chat.SystemPrompt == nil || *chat.SystemPrompt != "Be helpful" {
+		t.Fatal("The system message should become the system prompt")
+	}
+	if len(messages) != 3 || activeMessage != 2 || messages[2].Parent != 1 {
+		t.Fatalf("The messages should follow each other, got %v", messages)
+	}
+	if messages[0].Content != "What's this?" || len(messages[0].Images) != 1 {
+		t.Fatalf("The content parts should be parsed, got %v", messages[0])
+	}
+
+	input.Messages[0].Role = "narrator"
+	if _, _, _, err := parseChatImport(input); err == nil {
+		t.Fatal("An unknown role should be rejected")
+	}
+}
+
+func TestExportedSystemPromptVisibility(t *testing.T) {
+	id := func(s string) *string { return &s }
+	db := database.MockDatabase{
+		MockGetPromptByIDOrSlug: func(_ string) (*database.Prompt, error) {
+			return &database.Prompt{ID: "prompt", UserID: "owner", Prompt: "Private prompt"}, nil
+		},
+	}
+	chat := &database.Chat{
+		ID:             "chat",
+		SystemPrompt:   id("Chat prompt"),
+		SystemPromptID: id("prompt"),
+	}
+
+	if prompt := getChatSystemPrompt(db, "owner", chat); *prompt != "Private prompt" {
+		t.Fatalf("The owner should get the prompt, got %q", *prompt)
+	}
+	if prompt := getChatSystemPrompt(db, "other", chat); *prompt != "Chat prompt" {
+		t.Fatalf("Other users shouldn't get a private prompt, got %q", *prompt)
+	}
+}
+
+func TestImportChatTooLarge(t *testing.T) {
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, database.MockDatabase{})
+	ctx = context.WithValue(ctx, utils.ContextKeyUserID, "user")
+	ctx = context.WithValue(ctx, utils.ContextKeyRecordEvent, utils.RecordFunc(
+		func(_ string, _ ...utils.KeyValue) {},
+	))
+
+	body := `[{"role": "user", "content": "` + strings.Repeat("a", MaxChatImportSize) + `"}]`
+	r := httptest.NewRequest("POST", "/chats/import", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	ImportChat(w, r.WithContext(ctx), router.Params{})
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("The import should be refused before being read, got %d", w.Code)
+	}
+}
@@ -0,0 +1,211 @@
+package completion
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	router "github.com/julienschmidt/httprouter"
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/utils"
+	"gorm.io/gorm"
+)
+
+// The shared chats can be forked, so they are limited like the imports
+const MaxSharedChatMessages = MaxImportedChatMessages
+
+func CreateChatShare(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	var requestBody struct {
+		ExpiresAt *time.Time `json:"expires_at,omitempty"` // Never expires when unset
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestBody); err != nil && err != io.EOF {
+		utils.RespondError(w, record, "decode_error")
+		return
+	}
+
+	if requestBody.ExpiresAt != nil && !requestBody.ExpiresAt.After(time.Now()) {
+		utils.RespondError(w, record, "invalid_share_expiry")
+		return
+	}
+
+	share, err := db.CreateChatShare(userID, ps.ByName("id"), requestBody.ExpiresAt)
+	if err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	if share == nil {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	response, _ := json.Marshal(share)
+	record(string(response))
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(response)
+}
+
+func ListChatShares(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	shares, err := db.ListChatShares(userID, ps.ByName("id"))
+	if err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	if shares == nil {
+		shares = []database.ChatShare{}
+	}
+
+	response, _ := json.Marshal(shares)
+	record(string(response))
+
+	_, _ = w.Write(response)
+}
+
+func RevokeChatShare(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	err := db.RevokeChatShare(userID, ps.ByName("id"), ps.ByName("share_id"))
+	if err == gorm.ErrRecordNotFound {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	record("[Empty Response]")
+
+	_, _ = w.Write([]byte("{\"success\":true}"))
+}
+
+/*
+ * getSharedChat returns the chat of a valid share with the messages of its active branch,
+ * from the oldest. The chat is only read if it still belongs to the user who shared it, so
+ * a token never gives access to another chat. A chat longer than MaxSharedChatMessages is
+ * refused rather than shared without its last messages.
+ */
+func getSharedChat(
+	db database.Database,
+	token string,
+) (*database.Chat, []database.ChatMessage, error) {
+	share, err := db.GetChatShareByToken(token)
+	if err != nil || share == nil {
+		return nil, nil, ErrNotFound
+	}
+
+	chat, err := db.GetChatByID(share.ChatID)
+	if err != nil || chat == nil || chat.UserID != share.UserID {
+		return nil, nil, ErrNotFound
+	}
+
+	// One more message is read to know if the chat is over the limit
+	messages, err := db.GetChatMessages(share.UserID, chat.ID, false, MaxSharedChatMessages+1, 0)
+	if err != nil {
+		return nil, nil, ErrInternalServerError
+	}
+
+	if len(messages) > MaxSharedChatMessages {
+		return nil, nil, ErrSharedChatTooLong
+	}
+
+	return chat, messages, nil
+}
+
+// SharedChat is the read-only view of a chat, without its system prompt nor its branches
+type SharedChat struct {
+	Name      *string             `json:"name,omitempty"`
+	CreatedAt *time.Time          `json:"created_at,omitempty"`
+	Messages  []ChatExportMessage `json:"messages"`
+}
+
+func buildSharedChat(chat *database.Chat, messages []database.ChatMessage) SharedChat {
+	export := buildChatExport(chat, nil, messages)
+
+	for i := range export.Messages {
+		export.Messages[i].ID = ""
+		export.Messages[i].ParentID = nil
+	}
+
+	return SharedChat{Name: export.Name, CreatedAt: export.CreatedAt, Messages: export.Messages}
+}
+
+// GetSharedChat doesn't need to be authenticated, the token is enough
+func GetSharedChat(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	chat, messages, err := getSharedChat(db, ps.ByName("token"))
+	if err != nil {
+		utils.RespondError(w, record, ErrorCode(err))
+		return
+	}
+
+	response, _ := json.Marshal(buildSharedChat(chat, messages))
+	record(string(response))
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(response)
+}
+
+/*
+ * ForkSharedChat copies the shared messages into a new chat of the user. The saved prompt
+ * of the chat is only kept if the user can use it, and its own system prompt, which the
+ * shared view doesn't show, isn't copied.
+ */
+func ForkSharedChat(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	shared, messages, err := getSharedChat(db, ps.ByName("token"))
+	if err != nil {
+		utils.RespondError(w, record, ErrorCode(err))
+		return
+	}
+
+	chat := database.Chat{
+		UserID:              userID,
+		Name:                shared.Name,
+		SystemPromptID:      shared.SystemPromptID,
+		SystemPromptVersion: shared.SystemPromptVersion,
+	}
+	resolveImportedSystemPrompt(db, userID, &chat)
+
+	forked := make([]database.ChatMessageImport, 0, len(messages))
+	for i, message := range messages {
+		forked = append(forked, database.ChatMessageImport{
+			Parent:        i - 1,
+			IsUserMessage: message.IsUserMessage,
+			Content:       message.Content,
+			Images:        message.Images,
+		})
+	}
+
+	fork, err := db.ImportChat(chat, forked, len(forked)-1)
+	if err != nil {
+		utils.RespondError(w, record, "error_create_chat", err.Error())
+		return
+	}
+
+	response, _ := json.Marshal(fork)
+	record(string(response))
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(response)
+}
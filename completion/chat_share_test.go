@@ -0,0 +1,140 @@
+package completion
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	router "github.com/julienschmidt/httprouter"
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/utils"
+	"gorm.io/gorm"
+)
+
+func mockSharedChats(imported *database.Chat) database.MockDatabase {
+	shares := map[string]*database.ChatShare{
+		"valid": {ChatID: "chat", UserID: "owner"},
+		// A share whose user isn't the one of the chat mustn't give access to it
+		"other": {ChatID: "chat", UserID: "other"},
+	}
+	promptID := "private-prompt"
+
+	return database.MockDatabase{
+		MockGetChatShareByToken: func(token string) (*database.ChatShare, error) {
+			return shares[token], nil
+		},
+		MockGetChatByID: func(id string) (*database.Chat, error) {
+			return &database.Chat{ID: id, UserID: "owner", SystemPromptID: &promptID}, nil
+		},
+		MockGetChatMessages: func(_ string, _ string, _ bool, _ int, _ int) ([]database.ChatMessage, error) {
+			id := "message"
+			return []database.ChatMessage{
+				{ID: &id, IsUserMessage: true, Content: "Hello"},
+				{ParentID: &id, Content: "Hi, how can I help?"},
+			}, nil
+		},
+		MockGetPromptByIDOrSlug: func(id string) (*database.Prompt, error) {
+			return &database.Prompt{ID: id, UserID: "owner"}, nil
+		},
+		MockImportChat: func(chat database.Chat, messages []database.ChatMessageImport, _ int) (*database.Chat, error) {
+			*imported = chat
+			imported.ChatMessages = make([]database.ChatMessage, len(messages))
+			return imported, nil
+		},
+	}
+}
+
+func requestSharedChat(
+	ctx context.Context,
+	handler router.Handle,
+	token string,
+) *httptest.ResponseRecorder {
+	ctx = context.WithValue(ctx, utils.ContextKeyRecordEvent, utils.RecordFunc(
+		func(_ string, _ ...utils.KeyValue) {},
+	))
+
+	r := httptest.NewRequest("GET", "/shared/chat/"+token, strings.NewReader(""))
+	w := httptest.NewRecorder()
+	handler(w, r.WithContext(ctx), router.Params{{Key: "token", Value: token}})
+
+	return w
+}
+
+func TestGetSharedChat(t *testing.T) {
+	var imported database.Chat
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, mockSharedChats(&imported))
+
+	for _, token := range []string{"other", "missing"} {
+		if w := requestSharedChat(ctx, GetSharedChat, token); w.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected a not found, got %d", token, w.Code)
+		}
+	}
+
+	w := requestSharedChat(ctx, GetSharedChat, "valid")
+
+	var shared SharedChat
+	if err := json.Unmarshal(w.Body.Bytes(), &shared); err != nil || len(shared.Messages) != 2 {
+		t.Fatalf("The messages should be shared, got %s", w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "private-prompt") || shared.Messages[1].ParentID != nil {
+		t.Fatalf("The system prompt and the branches shouldn't be shared, got %s", w.Body.String())
+	}
+}
+
+func TestForkSharedChat(t *testing.T) {
+	var imported database.Chat
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, mockSharedChats(&imported))
+	ctx = context.WithValue(ctx, utils.ContextKeyUserID, "user")
+
+	if w := requestSharedChat(ctx, ForkSharedChat, "valid"); w.Code != http.StatusCreated {
+		t.Fatalf("Expected the chat to be forked, got %d", w.Code)
+	}
+
+	if imported.UserID != "user" || len(imported.ChatMessages) != 2 {
+		t.Fatalf("The messages should be copied to the user, got %v", imported)
+	}
+	if imported.SystemPromptID != nil {
+		t.Fatal("The private prompt of the owner shouldn't be kept")
+	}
+}
+
+func TestSharedChatTooLong(t *testing.T) {
+	var imported database.Chat
+	db := mockSharedChats(&imported)
+	db.MockGetChatMessages = func(_ string, _ string, _ bool, limit int, _ int) ([]database.ChatMessage, error) {
+		return make([]database.ChatMessage, limit), nil
+	}
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, db)
+
+	w := requestSharedChat(ctx, GetSharedChat, "valid")
+	if !strings.Contains(w.Body.String(), "shared_chat_too_long") {
+		t.Fatalf("A chat over the limit shouldn't be shared truncated, got %s", w.Body.String())
+	}
+}
+
+func TestRevokeMissingChatShare(t *testing.T) {
+	db := database.MockDatabase{
+		MockRevokeChatShare: func(_ string, _ string, _ string) error {
+			return gorm.ErrRecordNotFound
+		},
+	}
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, db)
+	ctx = context.WithValue(ctx, utils.ContextKeyUserID, "user")
+	ctx = context.WithValue(ctx, utils.ContextKeyRecordEvent, utils.RecordFunc(
+		func(_ string, _ ...utils.KeyValue) {},
+	))
+
+	r := httptest.NewRequest("DELETE", "/chats/chat/shares/share", strings.NewReader(""))
+	w := httptest.NewRecorder()
+	RevokeChatShare(w, r.WithContext(ctx), router.Params{
+		{Key: "id", Value: "chat"},
+		{Key: "share_id", Value: "share"},
+	})
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Revoking a missing share should respond not found, got %d", w.Code)
+	}
+}
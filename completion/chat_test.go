@@ -0,0 +1,70 @@
+package completion
+
+import (
+	"context"
+	"testing"
+
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/utils"
+)
+
+func TestBranchChat(t *testing.T) {
+	userMessageID, answerID, parentID, lastID := "user-message", "answer", "parent", "last"
+
+	var activeMessageID *string
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, database.MockDatabase{
+		MockGetChatByID: func(id string) (*database.Chat, error) {
+			return &database.Chat{ID: id, UserID: "user", ActiveMessageID: &lastID}, nil
+		},
+		MockGetChatMessage: func(_ string, id string) (*database.ChatMessage, error) {
+			if id == userMessageID {
+				return &database.ChatMessage{ID: &id, ParentID: nil, IsUserMessage: true}, nil
+			}
+			return &database.ChatMessage{ID: &id, ParentID: &parentID}, nil
+		},
+		MockSetChatActiveMessage: func(_ string, messageID *string) error {
+			activeMessageID = messageID
+			return nil
+		},
+	})
+
+	tests := []struct {
+		input    GenerateRequestBody
+		err      error
+		expected *string
+	}{
+		{GenerateRequestBody{Task: "Edited", EditMessageID: &userMessageID}, nil, nil},
+		{GenerateRequestBody{RegenerateMessageID: &answerID}, nil, &parentID},
+		{GenerateRequestBody{EditMessageID: &userMessageID}, ErrInvalidChatBranch, nil},
+		{GenerateRequestBody{Task: "Hi", RegenerateMessageID: &answerID}, ErrInvalidChatBranch, nil},
+		{GenerateRequestBody{Task: "Edited", EditMessageID: &answerID}, ErrInvalidChatBranch, nil},
+		{GenerateRequestBody{RegenerateMessageID: &userMessageID}, ErrInvalidChatBranch, nil},
+	}
+
+	for i, test := range tests {
+		activeMessageID = &answerID
+
+		restore, err := BranchChat(ctx, "user", "chat", test.input)
+		if err != test.err {
+			t.Fatalf("%d: expected %v, got %v", i, test.err, err)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if activeMessageID != test.expected {
+			t.Fatalf("%d: the branch should start from the parent of the message", i)
+		}
+
+		// A failed generation gives back the branch the chat was on
+		restore()
+		if activeMessageID != &lastID {
+			t.Fatalf("%d: the previous branch should be restored", i)
+		}
+	}
+
+	if _, err := BranchChat(ctx, "other", "chat", tests[0].input); err != ErrNotFound {
+		t.Fatalf("Only the user of the chat can branch it, got %v", err)
+	}
+}
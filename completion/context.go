@@ -5,7 +5,10 @@ import (
 	"sync"
 
 	completionContext "github.com/polyfire/api/completion/context"
+	database "github.com/polyfire/api/db"
 	"github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/registry"
+	"github.com/polyfire/api/tokens"
 	"github.com/polyfire/api/utils"
 )
 
@@ -27,15 +30,57 @@ func launchContextFillingGoRouting(
 	}()
 }
 
+// The context budget of the models whose context window isn't known
 const MaxContentLength = 4000
 
+// The tokens kept for the answer when the request doesn't set max_tokens
+const DefaultReservedOutputTokens = 1024
+
+/*
+ * GetContextBudget computes how many tokens the context can take in the model's context
+ * window. The task, the messages and the images of the request are counted, and the
+ * answer gets max_tokens or DefaultReservedOutputTokens.
+ */
+func GetContextBudget(
+	providerName string,
+	modelName string,
+	input GenerateRequestBody,
+) options.ContextBudget {
+	budget := options.ContextBudget{ReservedOutput: DefaultReservedOutputTokens}
+	if input.MaxTokens != nil {
+		budget.ReservedOutput = *input.MaxTokens
+	}
+
+	budget.TaskTokens = tokens.CountTokens(input.Task) +
+		len(input.Images)*options.ImageTokenEstimate
+	for _, message := range input.Messages {
+		budget.TaskTokens += tokens.CountTokens(message.Content) +
+			len(message.Images)*options.ImageTokenEstimate
+	}
+
+	model, ok := registry.Find(providerName, modelName)
+	if !ok || model.ContextWindow == 0 {
+		budget.Budget = MaxContentLength
+		return budget
+	}
+
+	budget.ContextWindow = model.ContextWindow
+	budget.Budget = model.ContextWindow - budget.TaskTokens - budget.ReservedOutput
+	if budget.Budget < 0 {
+		budget.Budget = 0
+	}
+
+	return budget
+}
+
 func GetContextString(
 	ctx context.Context,
 	userID string,
 	input GenerateRequestBody,
+	tokenLimit int,
 	callback options.ProviderCallback,
 	opts *options.ProviderOptions,
-) (string, []string, error) {
+) (string, []options.Message, []string, *database.ServedPrompt, error) {
 	var wg sync.WaitGroup
 	contextElements := make([]completionContext.ContentElement, 0)
 
@@ -53,20 +98,25 @@ func GetContextString(
 	)
 
 	var warnings []string
+	var servedPrompt *database.ServedPrompt
 	launchContextFillingGoRouting(
 		&wg,
 		&contextElements,
 		func() (completionContext.ContentElement, error) {
-			var systemPrompt completionContext.ContentElement
-			var err error
-			systemPrompt, warnings, err = completionContext.GetSystemPrompt(
+			systemPrompt, w, err := completionContext.GetSystemPrompt(
 				ctx,
 				userID,
 				input.SystemPromptID,
 				input.SystemPrompt,
 				input.ChatID,
+				input.Vars,
 			)
-			return systemPrompt, err
+			warnings = w
+			if err != nil {
+				return nil, err
+			}
+			servedPrompt = systemPrompt.Served
+			return systemPrompt, nil
 		},
 	)
 
@@ -80,27 +130,59 @@ func GetContextString(
 		)
 	}
 
+	var chatSummary *completionContext.ChatSummaryContext
 	if input.ChatID != nil && len(*input.ChatID) > 0 {
-		err := AddToChatHistory(ctx, userID, input.Task, *input.ChatID, callback, opts)
+		// The summaries of the history are logged like the generation, but AddToChatHistory
+		// makes the callback add the answer to the chat
+		var summaryCallback options.ProviderCallback
+		if callback != nil && *callback != nil {
+			logRequest := *callback
+			summaryCallback = &logRequest
+		}
+
+		err := AddToChatHistory(
+			ctx,
+			userID,
+			input.Task,
+			input.Images,
+			*input.ChatID,
+			callback,
+			opts,
+		)
 		if err != nil {
-			return "", warnings, err
+			return "", nil, warnings, nil, err
 		}
 
 		launchContextFillingGoRouting(
 			&wg,
 			&contextElements,
 			func() (completionContext.ContentElement, error) {
-				return completionContext.GetChatHistoryContext(ctx, userID, *input.ChatID)
+				history, summary, err := completionContext.GetChatHistoryContext(
+					ctx,
+					userID,
+					*input.ChatID,
+					tokenLimit,
+					chatSummarizer(ctx, input.Model, summaryCallback),
+				)
+				chatSummary = summary
+				return history, err
 			},
 		)
 	}
 
 	wg.Wait()
 
-	contextString, err := completionContext.GetContext(contextElements, MaxContentLength)
+	if chatSummary != nil {
+		contextElements = append(contextElements, chatSummary)
+	}
+
+	contextString, history, err := completionContext.GetContextMessages(
+		contextElements,
+		tokenLimit,
+	)
 	if err != nil {
-		return "", warnings, err
+		return "", nil, warnings, nil, err
 	}
 
-	return contextString, warnings, nil
+	return contextString, history, warnings, servedPrompt, nil
 }
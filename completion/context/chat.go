@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"log"
 	"strings"
 	"text/template"
 
 	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/tokens"
 	"github.com/polyfire/api/utils"
 )
 
@@ -20,38 +23,162 @@ var chatHistoryTemplate = template.Must(
 )
 
 type ChatHistoryContext struct {
-	Messages []string
+	Messages []options.Message
+}
+
+func formatChatMessage(message options.Message) string {
+	if message.Role == options.RoleUser {
+		return fmt.Sprintf("User:\n%s", message.Content)
+	}
+	return fmt.Sprintf("You:\n%s", message.Content)
 }
 
 var chatHistoryTemplateGrowth = InitContextStructureTemplate(*chatHistoryTemplate)
 
+const (
+	// The messages of the branch loaded after its latest summary
+	MaxChatHistoryMessages = 40
+	// The most recent messages left out of the summary
+	KeptChatHistoryMessages = 20
+)
+
+// ChatSummarizer answers the messages asking for the summary of a conversation, it fails
+// rather than returning an empty summary
+type ChatSummarizer func(messages []options.Message) (string, error)
+
+func toHistoryMessages(history []database.ChatMessage) []options.Message {
+	var messages []options.Message
+	for _, message := range history {
+		if strings.TrimSpace(message.Content) == "" && len(message.Images) == 0 {
+			continue
+		}
+
+		if message.IsUserMessage {
+			messages = append(
+				messages,
+				options.Message{
+					Role:    options.RoleUser,
+					Content: message.Content,
+					Images:  message.Images,
+				},
+			)
+		} else {
+			messages = append(
+				messages,
+				options.Message{Role: options.RoleAssistant, Content: message.Content},
+			)
+		}
+	}
+
+	return messages
+}
+
+// splitSummary cuts the history, from the most recent message, at its latest summary
+func splitSummary(history []database.ChatMessage) ([]database.ChatMessage, string) {
+	for i, message := range history {
+		if message.Summary != nil {
+			return history[:i], *message.Summary
+		}
+	}
+
+	return history, ""
+}
+
+func countHistoryTokens(history []database.ChatMessage) int {
+	total := 0
+	for _, message := range toHistoryMessages(history) {
+		total += tokens.CountTokens(formatChatMessage(message))
+	}
+
+	return total
+}
+
+/*
+ * The history is summarized once it takes more than half of the context budget or reaches
+ * MaxChatHistoryMessages. The most recent messages filling a quarter of the budget, at
+ * least the last one, stay out of the summary.
+ */
+func splitSummarizedHistory(
+	history []database.ChatMessage,
+	tokenLimit int,
+) ([]database.ChatMessage, []database.ChatMessage) {
+	if countHistoryTokens(history) <= tokenLimit/2 && len(history) < MaxChatHistoryMessages {
+		return history, nil
+	}
+
+	kept := 1
+	for kept < len(history) && kept < KeptChatHistoryMessages {
+		if countHistoryTokens(history[:kept+1]) > tokenLimit/4 {
+			break
+		}
+		kept++
+	}
+
+	return history[:kept], history[kept:]
+}
+
+func getChatSummaryPrompt(summary string, older []database.ChatMessage) []options.Message {
+	var transcript strings.Builder
+	if summary != "" {
+		transcript.WriteString("Summary of the beginning of the conversation:\n" + summary + "\n\n")
+	}
+
+	// The messages are stored from the most recent to the oldest
+	messages := toHistoryMessages(older)
+	for i := len(messages) - 1; i >= 0; i-- {
+		transcript.WriteString(formatChatMessage(messages[i]) + "\n")
+	}
+
+	return []options.Message{
+		{Role: options.RoleSystem, Content: chatSummaryInstructions},
+		{Role: options.RoleUser, Content: transcript.String()},
+	}
+}
+
+const chatSummaryInstructions = `Summarize the conversation between the user and you below. ` +
+	`Keep the facts, the names, the numbers, what the user asked for and what has been ` +
+	`decided or is still unresolved. Answer only with the summary.`
+
+/*
+ * GetChatHistoryContext returns the messages of the active branch of the chat following
+ * its latest summary, and the summary. When the history is too long for the context, its
+ * older messages are summarized with the previous summary and the new one is stored on
+ * the most recent of them, to be reused by the next generations of the branch.
+ */
 func GetChatHistoryContext(
 	ctx context.Context,
 	userID string,
 	chatID string,
-) (*ChatHistoryContext, error) {
+	tokenLimit int,
+	summarize ChatSummarizer,
+) (*ChatHistoryContext, *ChatSummaryContext, error) {
 	db := ctx.Value(utils.ContextKeyDB).(database.Database)
-	allHistory, err := db.GetChatMessages(userID, chatID, true, 20, 0)
+	allHistory, err := db.GetChatMessages(userID, chatID, true, MaxChatHistoryMessages, 0)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	var messages []string
-	for _, message := range allHistory {
-		if strings.TrimSpace(message.Content) != "" {
-			if message.IsUserMessage {
-				messages = append(messages, fmt.Sprintf("User:\n%s", message.Content))
-			} else {
-				messages = append(messages, fmt.Sprintf("You:\n%s", message.Content))
+	history, summary := splitSummary(allHistory)
+
+	kept, older := splitSummarizedHistory(history, tokenLimit)
+	if summarize != nil && len(older) > 0 && older[0].ID != nil {
+		newSummary, err := summarize(getChatSummaryPrompt(summary, older))
+		if err != nil {
+			log.Printf("[WARN] Error summarizing chat %s: %v", chatID, err)
+		} else {
+			if err := db.SetChatMessageSummary(*older[0].ID, newSummary); err != nil {
+				log.Printf("[WARN] Error saving the summary of chat %s: %v", chatID, err)
 			}
+			history, summary = kept, newSummary
 		}
 	}
 
-	chatHistoryContext := ChatHistoryContext{
-		Messages: messages,
+	var summaryContext *ChatSummaryContext
+	if summary != "" {
+		summaryContext = &ChatSummaryContext{Summary: summary}
 	}
 
-	return &chatHistoryContext, nil
+	return &ChatHistoryContext{Messages: toHistoryMessages(history)}, summaryContext, nil
 }
 
 func (chc *ChatHistoryContext) GetPriority() Priority {
@@ -66,7 +193,9 @@ func (chc *ChatHistoryContext) GetMinimumContextSize() int {
 	if len(chc.Messages) == 0 {
 		return 0
 	}
-	return chatHistoryTemplateGrowth.B + chatHistoryTemplateGrowth.A + len(chc.Messages[0])
+	return chatHistoryTemplateGrowth.B + chatHistoryTemplateGrowth.A + len(
+		formatChatMessage(chc.Messages[0]),
+	)
 }
 
 func (chc *ChatHistoryContext) GetRecommendedContextSize() int {
@@ -75,7 +204,7 @@ func (chc *ChatHistoryContext) GetRecommendedContextSize() int {
 	}
 	totalSize := chatHistoryTemplateGrowth.B
 	for i := 0; i < len(chc.Messages); i++ {
-		totalSize += chatHistoryTemplateGrowth.A + len(chc.Messages[i])
+		totalSize += chatHistoryTemplateGrowth.A + len(formatChatMessage(chc.Messages[i]))
 	}
 
 	return totalSize
@@ -86,14 +215,9 @@ type ChatHistoryTemplateData struct {
 }
 
 func (chc *ChatHistoryContext) GetContentFittingIn(tokenCount int) string {
-	tokenCurrentSize := chatHistoryTemplateGrowth.B
 	var result []string
-	for i := 0; i < len(chc.Messages); i++ {
-		tokenCurrentSize += chatHistoryTemplateGrowth.A + len(chc.Messages[i])
-		if tokenCurrentSize > tokenCount {
-			break
-		}
-		result = append([]string{chc.Messages[i]}, result...)
+	for _, message := range chc.GetMessagesFittingIn(tokenCount) {
+		result = append(result, formatChatMessage(message))
 	}
 
 	templData := ChatHistoryTemplateData{
@@ -108,3 +232,54 @@ func (chc *ChatHistoryContext) GetContentFittingIn(tokenCount int) string {
 
 	return resultBuf.String()
 }
+
+// The messages are stored from the most recent to the oldest, we keep as many recent
+// messages as possible and return them in chronological order.
+func (chc *ChatHistoryContext) GetMessagesFittingIn(tokenCount int) []options.Message {
+	tokenCurrentSize := chatHistoryTemplateGrowth.B
+	var result []options.Message
+	for i := 0; i < len(chc.Messages); i++ {
+		tokenCurrentSize += chatHistoryTemplateGrowth.A + len(formatChatMessage(chc.Messages[i]))
+		if tokenCurrentSize > tokenCount {
+			break
+		}
+		result = append([]options.Message{chc.Messages[i]}, result...)
+	}
+
+	return result
+}
+
+// ChatSummaryContext is the summary of the messages of the chat before its history
+type ChatSummaryContext struct {
+	Summary string
+}
+
+func (csc *ChatSummaryContext) content() string {
+	return fmt.Sprintf(
+		"Here's a summary of the earlier conversation:\n==========\n%s\n==========\n",
+		csc.Summary,
+	)
+}
+
+func (csc *ChatSummaryContext) GetPriority() Priority {
+	return IMPORTANT
+}
+
+func (csc *ChatSummaryContext) GetOrderIndex() int {
+	return 3
+}
+
+func (csc *ChatSummaryContext) GetMinimumContextSize() int {
+	return tokens.CountTokens(csc.content())
+}
+
+func (csc *ChatSummaryContext) GetRecommendedContextSize() int {
+	return tokens.CountTokens(csc.content())
+}
+
+func (csc *ChatSummaryContext) GetContentFittingIn(tokenCount int) string {
+	if tokens.CountTokens(csc.content()) > tokenCount {
+		return ""
+	}
+	return csc.content()
+}
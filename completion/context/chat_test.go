@@ -0,0 +1,97 @@
+package context
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/utils"
+)
+
+// mockChatHistory returns a branch of messages, from the most recent, with a summary stored
+// on the message at summaryIndex
+func mockChatHistory(count int, summaryIndex int, saved map[string]string) database.MockDatabase {
+	history := make([]database.ChatMessage, count)
+	for i := range history {
+		id := fmt.Sprintf("message-%d", i)
+		history[i] = database.ChatMessage{
+			ID:            &id,
+			IsUserMessage: i%2 == 1,
+			Content:       strings.Repeat("word ", 20),
+		}
+	}
+
+	summary := "The user can't log in"
+	if summaryIndex < count {
+		history[summaryIndex].Summary = &summary
+	}
+
+	return database.MockDatabase{
+		MockGetChatMessages: func(_ string, _ string, _ bool, limit int, _ int) ([]database.ChatMessage, error) {
+			if limit < len(history) {
+				return history[:limit], nil
+			}
+			return history, nil
+		},
+		MockSetChatMessageSummary: func(id string, summary string) error {
+			saved[id] = summary
+			return nil
+		},
+	}
+}
+
+func TestChatHistoryFollowsSummary(t *testing.T) {
+	saved := make(map[string]string)
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, mockChatHistory(12, 10, saved))
+
+	summarize := func(_ []options.Message) (string, error) {
+		t.Fatal("A short history shouldn't be summarized")
+		return "", nil
+	}
+
+	history, summary, err := GetChatHistoryContext(ctx, "user", "chat", 100000, summarize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history.Messages) != 10 {
+		t.Fatalf("The history should start after the summary, got %d messages", len(history.Messages))
+	}
+	if summary == nil || !strings.Contains(summary.GetContentFittingIn(1000), "can't log in") {
+		t.Fatalf("The summary should be in the context, got %v", summary)
+	}
+}
+
+func TestChatHistorySummarization(t *testing.T) {
+	saved := make(map[string]string)
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, mockChatHistory(30, 25, saved))
+
+	var prompt []options.Message
+	summarize := func(messages []options.Message) (string, error) {
+		prompt = messages
+		return "The user still can't log in", nil
+	}
+
+	// The 25 messages after the summary take more than half of the budget
+	history, summary, err := GetChatHistoryContext(ctx, "user", "chat", 1000, summarize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept := len(history.Messages)
+	if kept == 0 || kept >= 25 {
+		t.Fatalf("Only the recent messages should be kept, got %d", kept)
+	}
+	if saved[fmt.Sprintf("message-%d", kept)] != "The user still can't log in" {
+		t.Fatalf("The summary should be saved on the most recent summarized message, got %v", saved)
+	}
+	if summary == nil || summary.Summary != "The user still can't log in" {
+		t.Fatalf("The new summary should be in the context, got %v", summary)
+	}
+	if !strings.Contains(prompt[len(prompt)-1].Content, "The user can't log in") {
+		t.Fatal("The previous summary should be summarized with the older messages")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"sort"
 
+	"github.com/polyfire/api/llm/providers/options"
 	"github.com/polyfire/api/tokens"
 )
 
@@ -26,6 +27,12 @@ type ContentElement interface {
 	GetContentFittingIn(tokenCount int) string
 }
 
+// Elements that are part of the conversation itself (ex. Chat history) are passed to the
+// providers as separate messages instead of being inlined in the context prompt.
+type MessagesElement interface {
+	GetMessagesFittingIn(tokenCount int) []options.Message
+}
+
 var ErrCriticalDoesNotFit = errors.New("Critical content does not fit in the context")
 
 type contextElement struct {
@@ -34,8 +41,10 @@ type contextElement struct {
 	MinimumSize     int
 	Recommended     string
 	RecommendedSize int
-	UseRecommended  bool
-	OrderIndex      int
+	// The token count the recommended content has been fitted in
+	RecommendedBudget int
+	UseRecommended    bool
+	OrderIndex        int
 }
 
 type contextElementList []contextElement
@@ -54,17 +63,32 @@ func (cel *contextElementList) Swap(i, j int) {
 
 func contextElementFromContentElement(content ContentElement) contextElement {
 	return contextElement{
-		ContentElement:  content,
-		Minimum:         content.GetContentFittingIn(content.GetMinimumContextSize()),
-		MinimumSize:     content.GetMinimumContextSize(),
-		Recommended:     content.GetContentFittingIn(content.GetRecommendedContextSize()),
-		RecommendedSize: content.GetRecommendedContextSize(),
-		UseRecommended:  false,
-		OrderIndex:      content.GetOrderIndex(),
+		ContentElement:    content,
+		Minimum:           content.GetContentFittingIn(content.GetMinimumContextSize()),
+		MinimumSize:       content.GetMinimumContextSize(),
+		Recommended:       content.GetContentFittingIn(content.GetRecommendedContextSize()),
+		RecommendedSize:   content.GetRecommendedContextSize(),
+		RecommendedBudget: content.GetRecommendedContextSize(),
+		UseRecommended:    false,
+		OrderIndex:        content.GetOrderIndex(),
 	}
 }
 
-func GetContext(content []ContentElement, tokenLimit int) (string, error) {
+func (ce contextElement) budget() int {
+	if ce.UseRecommended {
+		return ce.RecommendedBudget
+	}
+	return ce.MinimumSize
+}
+
+func (ce contextElement) content() string {
+	if ce.UseRecommended {
+		return ce.Recommended
+	}
+	return ce.Minimum
+}
+
+func selectContext(content []ContentElement, tokenLimit int) (contextElementList, error) {
 	tokenCount := 0
 
 	criticalContent := []contextElement{}
@@ -74,7 +98,7 @@ func GetContext(content []ContentElement, tokenLimit int) (string, error) {
 			added := item.GetContentFittingIn(tokenLimit)
 			addedTokens := tokens.CountTokens(added)
 			if addedTokens+tokenCount > tokenLimit {
-				return "", ErrCriticalDoesNotFit
+				return nil, ErrCriticalDoesNotFit
 			}
 
 			criticalContent = append(criticalContent, contextElementFromContentElement(item))
@@ -141,9 +165,8 @@ func GetContext(content []ContentElement, tokenLimit int) (string, error) {
 		if item.UseRecommended {
 			size = item.RecommendedSize
 		}
-		recommended := item.ContentElement.GetContentFittingIn(
-			tokenLimit - (tokenCount - size),
-		)
+		budget := tokenLimit - (tokenCount - size)
+		recommended := item.ContentElement.GetContentFittingIn(budget)
 		recommendedSize := tokens.CountTokens(recommended)
 
 		if (tokenCount + recommendedSize - size) > tokenLimit {
@@ -151,6 +174,7 @@ func GetContext(content []ContentElement, tokenLimit int) (string, error) {
 		}
 		importantAndHelpfulContent[i].Recommended = recommended
 		importantAndHelpfulContent[i].RecommendedSize = recommendedSize
+		importantAndHelpfulContent[i].RecommendedBudget = budget
 		importantAndHelpfulContent[i].UseRecommended = true
 		tokenCount = tokenCount - size + importantAndHelpfulContent[i].RecommendedSize
 	}
@@ -160,14 +184,43 @@ func GetContext(content []ContentElement, tokenLimit int) (string, error) {
 
 	sort.Sort(&context)
 
+	return context, nil
+}
+
+func GetContext(content []ContentElement, tokenLimit int) (string, error) {
+	context, err := selectContext(content, tokenLimit)
+	if err != nil {
+		return "", err
+	}
+
 	result := ""
 	for _, item := range context {
-		if item.UseRecommended {
-			result += item.Recommended
-		} else {
-			result += item.Minimum
-		}
+		result += item.content()
 	}
 
 	return result, nil
 }
+
+// GetContextMessages works like GetContext but returns the elements implementing
+// MessagesElement as a list of messages instead of adding them to the context prompt.
+func GetContextMessages(
+	content []ContentElement,
+	tokenLimit int,
+) (string, []options.Message, error) {
+	context, err := selectContext(content, tokenLimit)
+	if err != nil {
+		return "", nil, err
+	}
+
+	result := ""
+	messages := []options.Message{}
+	for _, item := range context {
+		if messagesElement, ok := item.ContentElement.(MessagesElement); ok {
+			messages = append(messages, messagesElement.GetMessagesFittingIn(item.budget())...)
+			continue
+		}
+		result += item.content()
+	}
+
+	return result, messages, nil
+}
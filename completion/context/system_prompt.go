@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	database "github.com/polyfire/api/db"
 	"github.com/polyfire/api/tokens"
@@ -17,117 +18,261 @@ type ParsedSystemPromptElement struct {
 }
 
 type SystemPrompt struct {
-	elements []ParsedSystemPromptElement
+	nodes    []templateNode
+	warnings []string
 }
 
-func ParseSystemPrompt(systemPrompt string) SystemPrompt {
+// A backslash escapes the next character, "\{{" is not the start of a variable
+func tokenizeSystemPrompt(systemPrompt string) []ParsedSystemPromptElement {
 	result := make([]ParsedSystemPromptElement, 0)
 
-	literal := ""
+	var literal strings.Builder
 	isVar := false
 
-	var lastChar rune
-
-	for _, c := range systemPrompt {
-		if c == '\\' && lastChar != '\\' {
-			lastChar = c
-			continue
-		} else if c == '{' && lastChar == '{' {
-			if isVar {
-				literal = strings.TrimSpace(literal)
-			}
-			result = append(result, ParsedSystemPromptElement{Literal: literal, IsVar: isVar})
-			literal = ""
-			isVar = !isVar
-			lastChar = 0
-			continue
-		} else if lastChar == '{' || lastChar == '}' {
-			lastChar = c
-			literal += string(lastChar) + string(c)
-		} else if !(c == '}' || c == '{') || lastChar == '\\' {
-			lastChar = 0
-			literal += string(c)
-		} else {
-			lastChar = c
+	flush := func() {
+		element := ParsedSystemPromptElement{Literal: literal.String(), IsVar: isVar}
+		if isVar {
+			element.Literal = strings.TrimSpace(element.Literal)
 		}
+		result = append(result, element)
+		literal.Reset()
 	}
 
-	if lastChar == '{' || lastChar == '}' {
-		literal += string(lastChar)
+	runes := []rune(systemPrompt)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		var next rune
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		switch {
+		case c == '\\' && next != 0:
+			literal.WriteRune(next)
+			i++
+		case !isVar && c == '{' && next == '{', isVar && c == '}' && next == '}':
+			flush()
+			isVar = !isVar
+			i++
+		default:
+			literal.WriteRune(c)
+		}
 	}
 
 	if isVar {
-		literal = "{{" + literal
+		unclosed := "{{" + literal.String()
+		literal.Reset()
+		literal.WriteString(unclosed)
 		isVar = false
 	}
+	flush()
+
+	return result
+}
 
-	result = append(result, ParsedSystemPromptElement{Literal: literal, IsVar: isVar})
+func ParseSystemPrompt(systemPrompt string) SystemPrompt {
+	elements := tokenizeSystemPrompt(systemPrompt)
+	nodes, warnings := buildTemplate(elements)
 
-	return SystemPrompt{elements: result}
+	return SystemPrompt{nodes: nodes, warnings: warnings}
 }
 
+// ListVars returns the variables the template reads, without the ones of its loops
 func (sp SystemPrompt) ListVars() []string {
-	result := make([]string, 0)
+	return listTemplateVars(sp.nodes, make(map[string]bool), make([]string, 0))
+}
+
+func (sp SystemPrompt) Render(vars map[string]interface{}) (string, []string) {
+	renderer := templateRenderer{vars: vars, warnings: append([]string{}, sp.warnings...)}
+
+	var sb strings.Builder
+	renderer.render(sp.nodes, &sb)
 
-	for _, e := range sp.elements {
-		if e.IsVar {
-			result = append(result, e.Literal)
+	return sb.String(), renderer.warnings
+}
+
+func getKVVars(db database.Database, userID string, keys []string) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	kvMap, err := db.GetKVMap(userID, keys)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	for key, value := range kvMap {
+		if value != "" {
+			result[key] = value
 		}
 	}
 
 	return result
 }
 
-func (sp SystemPrompt) Render(vars map[string]string) string {
-	result := ""
+func getUserVars(db database.Database, userID string) map[string]interface{} {
+	result := map[string]interface{}{"id": userID}
 
-	for _, e := range sp.elements {
-		if e.IsVar {
-			result += vars[e.Literal]
-		} else {
-			result += e.Literal
-		}
+	projectUser, err := db.GetProjectUserByID(userID)
+	if err == nil && projectUser != nil {
+		result["auth_id"] = projectUser.AuthID
+	}
+
+	return result
+}
+
+func getProjectVars(ctx context.Context, db database.Database) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	projectID, _ := ctx.Value(utils.ContextKeyProjectID).(string)
+	if projectID == "" {
+		return result
+	}
+	result["id"] = projectID
+
+	project, err := db.GetProjectByID(projectID)
+	if err == nil && project != nil {
+		result["name"] = project.Name
+	}
+
+	return result
+}
+
+func getDateVars(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"date":     now.Format("2006-01-02"),
+		"time":     now.Format("15:04"),
+		"datetime": now.Format(time.RFC3339),
+		"weekday":  now.Weekday().String(),
+	}
+}
+
+func getChatVars(chat *database.Chat) map[string]interface{} {
+	result := map[string]interface{}{"id": chat.ID}
+
+	if chat.Name != nil {
+		result["name"] = *chat.Name
 	}
 
 	return result
 }
 
-func GetVars(ctx context.Context, userID string, varList []string) (map[string]string, []string) {
+/*
+ * GetVars resolves the variables of a system prompt from their namespace:
+ *   kv.*      the kv store of the user
+ *   vars.*    the vars of the request, "vars.a.b" reads inside the objects
+ *   user.*    id, auth_id
+ *   project.* id, name
+ *   now.*     date, time, datetime, weekday, in UTC
+ *   chat.*    id, name, when the generation is in a chat
+ * The variables that can't be resolved are missing from the result.
+ */
+func GetVars(
+	ctx context.Context,
+	userID string,
+	varList []string,
+	requestVars map[string]interface{},
+	chat *database.Chat,
+) map[string]interface{} {
 	db := ctx.Value(utils.ContextKeyDB).(database.Database)
-	warnings := make([]string, 0)
-	result := make(map[string]string)
+	result := make(map[string]interface{})
 
-	kvVars := make([]string, 0)
+	namespaces := make(map[string][]string)
 	for _, v := range varList {
-		if strings.HasPrefix(v, "kv.") {
-			kvVars = append(kvVars, strings.TrimPrefix(v, "kv."))
-		}
+		namespace, key, _ := strings.Cut(v, ".")
+		namespaces[namespace] = append(namespaces[namespace], key)
 	}
 
-	kvMap, err := db.GetKVMap(userID, kvVars)
-	if err != nil {
-		fmt.Println(err)
+	add := func(namespace string, values map[string]interface{}) {
+		for key, value := range values {
+			result[namespace+"."+key] = value
+		}
 	}
 
-	for _, v := range varList {
-		if strings.HasPrefix(v, "kv.") {
-			key := strings.TrimPrefix(v, "kv.")
-			if kvMap[key] == "" {
-				warnings = append(warnings, fmt.Sprintf("Unknown var: \"%s\"", v))
-				result[v] = ""
+	for namespace, keys := range namespaces {
+		switch namespace {
+		case "kv":
+			add(namespace, getKVVars(db, userID, keys))
+		case "user":
+			add(namespace, getUserVars(db, userID))
+		case "project":
+			add(namespace, getProjectVars(ctx, db))
+		case "now":
+			add(namespace, getDateVars(time.Now().UTC()))
+		case "chat":
+			if chat != nil {
+				add(namespace, getChatVars(chat))
+			}
+		case "vars":
+			for _, key := range keys {
+				if value, ok := lookupPath(requestVars, key); ok {
+					result["vars."+key] = value
+				}
 			}
-			result[v] = kvMap[key]
-		} else {
-			warnings = append(warnings, fmt.Sprintf("Unknown var: \"%s\"", v))
-			result[v] = ""
 		}
 	}
 
-	return result, warnings
+	return result
 }
 
 type SystemPromptContext struct {
 	SystemPrompt string
+	Served       *database.ServedPrompt // Unset when the prompt isn't a saved one
+}
+
+var (
+	ErrPromptNotFound        = errors.New("Prompt not found")
+	ErrPromptVersionNotFound = errors.New("Prompt version not found")
+)
+
+/*
+ * getPromptVersion returns the version of a prompt to use. It is the one pinned by the
+ * reference ("slug@version") or by the chat, else the one the running experiment assigns
+ * to the user, else the latest one.
+ */
+func getPromptVersion(
+	ctx context.Context,
+	userID string,
+	ref string,
+	pinnedVersion *int,
+) (string, *database.ServedPrompt, error) {
+	db := ctx.Value(utils.ContextKeyDB).(database.Database)
+	projectID, _ := ctx.Value(utils.ContextKeyProjectID).(string)
+
+	idOrSlug, version, err := database.ParsePromptRef(ref)
+	if err != nil {
+		return "", nil, err
+	}
+	if version == nil {
+		version = pinnedVersion
+	}
+
+	// The private prompts of the other users are reported as missing
+	p, err := db.GetPromptByIDOrSlug(idOrSlug)
+	if err != nil || p == nil || (!p.Public && p.UserID != userID) {
+		return "", nil, ErrPromptNotFound
+	}
+
+	served := &database.ServedPrompt{ID: p.ID, Version: p.Version}
+
+	if version == nil {
+		experiment, err := db.GetActivePromptExperiment(p.ID, projectID)
+		if err == nil && experiment != nil && len(experiment.Variants) > 0 {
+			assigned := experiment.Assign(userID)
+			version = &assigned
+			served.ExperimentID = &experiment.ID
+		}
+	}
+
+	if version == nil || *version == p.Version {
+		return p.Prompt, served, nil
+	}
+
+	pv, err := db.GetPromptVersion(p.ID, *version)
+	if err != nil || pv == nil {
+		return "", nil, ErrPromptVersionNotFound
+	}
+	served.Version = pv.Version
+
+	return pv.Prompt, served, nil
 }
 
 func GetSystemPrompt(
@@ -136,6 +281,7 @@ func GetSystemPrompt(
 	systemPromptID *string,
 	systemPrompt *string,
 	chatID *string,
+	vars map[string]interface{},
 ) (*SystemPromptContext, []string, error) {
 	db := ctx.Value(utils.ContextKeyDB).(database.Database)
 	result := ""
@@ -144,24 +290,28 @@ func GetSystemPrompt(
 		result = *systemPrompt
 	}
 
+	var chat *database.Chat
+	var pinnedVersion *int
 	if chatID != nil && len(*chatID) > 0 {
 		c, err := db.GetChatByID(*chatID)
 		if err != nil {
 			return nil, nil, errors.New("Chat not found")
 		}
+		chat = c
 
 		if c.SystemPromptID != nil && len(*c.SystemPromptID) > 0 {
 			systemPromptID = c.SystemPromptID
+			pinnedVersion = c.SystemPromptVersion
 		}
 	}
 
+	var served *database.ServedPrompt
 	if systemPromptID != nil && len(*systemPromptID) > 0 {
-		p, err := db.GetPromptByIDOrSlug(*systemPromptID)
-		if err != nil || p == nil {
-			return nil, nil, errors.New("Prompt not found")
+		var err error
+		result, served, err = getPromptVersion(ctx, userID, *systemPromptID, pinnedVersion)
+		if err != nil {
+			return nil, []string{err.Error()}, err
 		}
-
-		result = p.Prompt
 	}
 
 	if len(result) == 0 {
@@ -172,18 +322,12 @@ func GetSystemPrompt(
 
 	varList := systemPromptCtx.ListVars()
 
-	var warnings []string
-
-	if len(varList) != 0 {
-		var vars map[string]string
-		vars, warnings = GetVars(ctx, userID, varList)
-		result = systemPromptCtx.Render(vars)
-
-		if len(warnings) == 0 {
-			warnings = nil
-		}
+	result, warnings := systemPromptCtx.Render(GetVars(ctx, userID, varList, vars, chat))
+	if len(warnings) == 0 {
+		warnings = nil
 	}
-	return &SystemPromptContext{SystemPrompt: result + "\n"}, warnings, nil
+
+	return &SystemPromptContext{SystemPrompt: result + "\n", Served: served}, warnings, nil
 }
 
 func (spc *SystemPromptContext) GetOrderIndex() int {
@@ -0,0 +1,102 @@
+package context
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/utils"
+)
+
+func mockPromptVersions(experiment *database.PromptExperiment) database.MockDatabase {
+	return database.MockDatabase{
+		MockGetPromptByIDOrSlug: func(id string) (*database.Prompt, error) {
+			return &database.Prompt{
+				ID:      "prompt",
+				Slug:    "support",
+				Prompt:  "Version 3",
+				Version: 3,
+				Public:  true,
+			}, nil
+		},
+		MockGetPromptVersion: func(_ string, version int) (*database.PromptVersion, error) {
+			if version > 3 {
+				return nil, errors.New("not found")
+			}
+			return &database.PromptVersion{Version: version, Prompt: "Old version"}, nil
+		},
+		MockGetActivePromptExperiment: func(_ string, _ string) (*database.PromptExperiment, error) {
+			return experiment, nil
+		},
+	}
+}
+
+func TestPinnedPromptVersion(t *testing.T) {
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, mockPromptVersions(nil))
+
+	for ref, expected := range map[string]string{"support": "Version 3", "support@1": "Old version"} {
+		prompt, served, err := getPromptVersion(ctx, "user", ref, nil)
+		if err != nil || prompt != expected || served.ID != "prompt" {
+			t.Fatalf("%s: unexpected prompt %q %v %v", ref, prompt, served, err)
+		}
+	}
+
+	if _, _, err := getPromptVersion(ctx, "user", "support@4", nil); err == nil {
+		t.Fatal("A missing version should fail")
+	}
+}
+
+func TestPromptExperiment(t *testing.T) {
+	experiment := &database.PromptExperiment{
+		ID: "experiment",
+		Variants: []database.PromptExperimentVariant{
+			{Version: 2, Weight: 50},
+			{Version: 3, Weight: 50},
+		},
+	}
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, mockPromptVersions(experiment))
+
+	served := make(map[int]int)
+	for i := 0; i < 200; i++ {
+		userID := "user-" + strings.Repeat("x", i)
+
+		_, s, err := getPromptVersion(ctx, userID, "support", nil)
+		if err != nil || s.ExperimentID == nil {
+			t.Fatalf("The experiment should choose the version, got %v %v", s, err)
+		}
+		if s.Version != experiment.Assign(userID) {
+			t.Fatal("A user should always be served the same version")
+		}
+		served[s.Version]++
+	}
+
+	if served[2] < 50 || served[3] < 50 {
+		t.Fatalf("The traffic should be split between the versions, got %v", served)
+	}
+
+	// A pinned version is never part of the experiment
+	_, s, _ := getPromptVersion(ctx, "user", "support@1", nil)
+	if s.Version != 1 || s.ExperimentID != nil {
+		t.Fatalf("The pinned version should be served, got %v", s)
+	}
+}
+
+func TestPrivatePromptVersions(t *testing.T) {
+	db := mockPromptVersions(nil)
+	db.MockGetPromptByIDOrSlug = func(id string) (*database.Prompt, error) {
+		return &database.Prompt{ID: "prompt", Slug: "support", Version: 3, UserID: "owner"}, nil
+	}
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, db)
+
+	for _, ref := range []string{"support", "support@1", "prompt@2"} {
+		if _, _, err := getPromptVersion(ctx, "owner", ref, nil); err != nil {
+			t.Fatalf("%s: the owner should be served the prompt, got %v", ref, err)
+		}
+
+		if _, _, err := getPromptVersion(ctx, "other", ref, nil); err != ErrPromptNotFound {
+			t.Fatalf("%s: another user shouldn't be served the prompt, got %v", ref, err)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package context
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The loops can be nested, their iterations are counted together for the whole prompt
+const MaxTemplateIterations = 1000
+
+type templateNodeKind int
+
+const (
+	textNode templateNodeKind = iota
+	varNode
+	ifNode
+	eachNode
+)
+
+/*
+ * The system prompts are templates:
+ *   {{ kv.name }}                           a variable
+ *   {{ vars.name | default "friend" }}      a variable with a default value
+ *   {{#if vars.premium}} ... {{else}} ... {{/if}}, also {{#if !vars.premium}}
+ *   {{#each vars.items}} {{@index}} {{this}} {{this.field}} {{else}} empty {{/each}}
+ * There is nothing else to evaluate, a template can only read its variables.
+ */
+type templateNode struct {
+	kind         templateNodeKind
+	value        string // The literal of a text node, the variable of the others
+	defaultValue *string
+	negate       bool
+	body         []templateNode
+	elseBody     []templateNode
+}
+
+type templateFrame struct {
+	node   templateNode
+	inElse bool
+}
+
+func (f *templateFrame) add(n templateNode) {
+	if f.inElse {
+		f.node.elseBody = append(f.node.elseBody, n)
+	} else {
+		f.node.body = append(f.node.body, n)
+	}
+}
+
+func parseVariableTag(tag string) templateNode {
+	name, fallback, hasDefault := strings.Cut(tag, "|")
+	fallback = strings.TrimSpace(fallback)
+
+	if !hasDefault || !strings.HasPrefix(fallback, "default") {
+		return templateNode{kind: varNode, value: tag}
+	}
+
+	value := strings.TrimSpace(strings.TrimPrefix(fallback, "default"))
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+
+	return templateNode{kind: varNode, value: strings.TrimSpace(name), defaultValue: &value}
+}
+
+func parseBlockTag(kind templateNodeKind, tag string) templateNode {
+	name := strings.TrimSpace(tag)
+	negate := kind == ifNode && strings.HasPrefix(name, "!")
+
+	return templateNode{
+		kind:   kind,
+		value:  strings.TrimSpace(strings.TrimPrefix(name, "!")),
+		negate: negate,
+	}
+}
+
+func blockName(kind templateNodeKind) string {
+	if kind == eachNode {
+		return "each"
+	}
+	return "if"
+}
+
+func buildTemplate(elements []ParsedSystemPromptElement) ([]templateNode, []string) {
+	stack := []*templateFrame{{}}
+	warnings := make([]string, 0)
+
+	for _, e := range elements {
+		current := stack[len(stack)-1]
+
+		switch {
+		case !e.IsVar:
+			current.add(templateNode{kind: textNode, value: e.Literal})
+		case strings.HasPrefix(e.Literal, "#if "):
+			tag := strings.TrimPrefix(e.Literal, "#if ")
+			stack = append(stack, &templateFrame{node: parseBlockTag(ifNode, tag)})
+		case strings.HasPrefix(e.Literal, "#each "):
+			tag := strings.TrimPrefix(e.Literal, "#each ")
+			stack = append(stack, &templateFrame{node: parseBlockTag(eachNode, tag)})
+		case e.Literal == "else" && len(stack) > 1:
+			current.inElse = true
+		case e.Literal == "/if" || e.Literal == "/each":
+			if len(stack) == 1 || "/"+blockName(current.node.kind) != e.Literal {
+				warnings = append(warnings, fmt.Sprintf("Unexpected {{%s}}", e.Literal))
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			stack[len(stack)-1].add(current.node)
+		default:
+			current.add(parseVariableTag(e.Literal))
+		}
+	}
+
+	for len(stack) > 1 {
+		current := stack[len(stack)-1]
+		warnings = append(
+			warnings,
+			fmt.Sprintf("Unclosed {{#%s %s}}", blockName(current.node.kind), current.node.value),
+		)
+		stack = stack[:len(stack)-1]
+		stack[len(stack)-1].add(current.node)
+	}
+
+	return stack[0].node.body, warnings
+}
+
+func isLoopVariable(name string) bool {
+	return name == "this" || name == "@index" || strings.HasPrefix(name, "this.")
+}
+
+func listTemplateVars(nodes []templateNode, seen map[string]bool, result []string) []string {
+	for _, n := range nodes {
+		if n.kind != textNode && !isLoopVariable(n.value) && !seen[n.value] {
+			seen[n.value] = true
+			result = append(result, n.value)
+		}
+		result = listTemplateVars(n.body, seen, result)
+		result = listTemplateVars(n.elseBody, seen, result)
+	}
+
+	return result
+}
+
+// lookupPath reads a dotted path like "a.b" in the objects of a JSON value
+func lookupPath(value interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return value, true
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		value, ok = object[key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+func isTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case string:
+		return v != ""
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+func formatTemplateValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+
+	result, _ := json.Marshal(value)
+	return string(result)
+}
+
+// The kv values are strings, a JSON array stored in one can still be iterated
+func loopItems(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case string:
+		var items []interface{}
+		if json.Unmarshal([]byte(v), &items) == nil {
+			return items
+		}
+	}
+	return nil
+}
+
+type loopScope struct {
+	item  interface{}
+	index int
+}
+
+type templateRenderer struct {
+	vars       map[string]interface{}
+	loops      []loopScope
+	iterations int
+	warnings   []string
+}
+
+func (r *templateRenderer) lookup(name string) (interface{}, bool) {
+	if len(r.loops) > 0 {
+		scope := r.loops[len(r.loops)-1]
+		if name == "@index" {
+			return float64(scope.index), true
+		}
+		if name == "this" || strings.HasPrefix(name, "this.") {
+			path := strings.TrimPrefix(strings.TrimPrefix(name, "this"), ".")
+			return lookupPath(scope.item, path)
+		}
+	}
+
+	value, ok := r.vars[name]
+	return value, ok
+}
+
+func (r *templateRenderer) renderVariable(n templateNode, sb *strings.Builder) {
+	value, ok := r.lookup(n.value)
+	if ok && value != nil && value != "" {
+		sb.WriteString(formatTemplateValue(value))
+	} else if n.defaultValue != nil {
+		sb.WriteString(*n.defaultValue)
+	} else if !ok {
+		r.warnings = append(r.warnings, fmt.Sprintf("Unknown var: \"%s\"", n.value))
+	}
+}
+
+func (r *templateRenderer) renderLoop(n templateNode, sb *strings.Builder) {
+	value, _ := r.lookup(n.value)
+	items := loopItems(value)
+
+	if len(items) == 0 {
+		r.render(n.elseBody, sb)
+		return
+	}
+
+	for i, item := range items {
+		r.iterations++
+		if r.iterations > MaxTemplateIterations {
+			// Only warned once, by the first iteration over the limit
+			if r.iterations == MaxTemplateIterations+1 {
+				r.warnings = append(
+					r.warnings,
+					fmt.Sprintf("The loops are limited to %d iterations", MaxTemplateIterations),
+				)
+			}
+			return
+		}
+
+		r.loops = append(r.loops, loopScope{item: item, index: i})
+		r.render(n.body, sb)
+		r.loops = r.loops[:len(r.loops)-1]
+	}
+}
+
+func (r *templateRenderer) render(nodes []templateNode, sb *strings.Builder) {
+	for _, n := range nodes {
+		switch n.kind {
+		case textNode:
+			sb.WriteString(n.value)
+		case varNode:
+			r.renderVariable(n, sb)
+		case ifNode:
+			value, _ := r.lookup(n.value)
+			if isTruthy(value) != n.negate {
+				r.render(n.body, sb)
+			} else {
+				r.render(n.elseBody, sb)
+			}
+		case eachNode:
+			r.renderLoop(n, sb)
+		}
+	}
+}
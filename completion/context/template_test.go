@@ -0,0 +1,106 @@
+package context
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/utils"
+)
+
+func renderTemplate(t *testing.T, template string, varsJSON string) (string, []string) {
+	var vars map[string]interface{}
+	if err := json.Unmarshal([]byte(varsJSON), &vars); err != nil {
+		t.Fatal(err)
+	}
+
+	return ParseSystemPrompt(template).Render(vars)
+}
+
+func TestTemplateVariables(t *testing.T) {
+	result, warnings := renderTemplate(
+		t,
+		`Hello {{ vars.name }}, {{vars.title | default "friend"}}! \{{kv.escaped}}`,
+		`{"vars.name": "Ada"}`,
+	)
+
+	if result != "Hello Ada, friend! {{kv.escaped}}" || len(warnings) != 0 {
+		t.Fatalf("Unexpected render: %q %v", result, warnings)
+	}
+
+	_, warnings = renderTemplate(t, "Hello {{kv.missing}}", `{}`)
+	if len(warnings) != 1 || warnings[0] != `Unknown var: "kv.missing"` {
+		t.Fatalf("A missing var should be warned, got %v", warnings)
+	}
+}
+
+func TestTemplateBlocks(t *testing.T) {
+	template := "{{#if vars.premium}}Premium{{else}}Free{{/if}}" +
+		"{{#if !vars.items}} none{{/if}}" +
+		"{{#each vars.items}} {{@index}}:{{this.name}}{{else}} empty{{/each}}"
+
+	result, _ := renderTemplate(
+		t,
+		template,
+		`{"vars.premium": true, "vars.items": [{"name": "a"}, {"name": "b"}]}`,
+	)
+	if result != "Premium 0:a 1:b" {
+		t.Fatalf("Unexpected render: %q", result)
+	}
+
+	result, _ = renderTemplate(t, template, `{"vars.premium": false}`)
+	if result != "Free none empty" {
+		t.Fatalf("Unexpected render: %q", result)
+	}
+}
+
+func TestTemplateInvalidBlocks(t *testing.T) {
+	result, warnings := renderTemplate(t, "{{/each}}{{#if vars.a}}a", `{"vars.a": "x"}`)
+
+	if result != "a" || len(warnings) != 2 {
+		t.Fatalf("Unexpected render: %q %v", result, warnings)
+	}
+}
+
+func TestTemplateLoopLimit(t *testing.T) {
+	items := make([]interface{}, 100)
+	vars := map[string]interface{}{"vars.items": items}
+
+	sp := ParseSystemPrompt("{{#each vars.items}}{{#each vars.items}}.{{/each}}{{/each}}")
+	result, warnings := sp.Render(vars)
+
+	// The 10 iterations of the outer loop are counted too
+	if len(result) != MaxTemplateIterations-10 || len(warnings) != 1 {
+		t.Fatalf("The loops should stop at the limit, got %d %v", len(result), warnings)
+	}
+}
+
+func TestGetVars(t *testing.T) {
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, database.MockDatabase{
+		MockGetKVMap: func(_ string, keys []string) (map[string]string, error) {
+			return map[string]string{"color": "blue"}, nil
+		},
+	})
+
+	chatName := "Support"
+	vars := GetVars(
+		ctx,
+		"user",
+		ParseSystemPrompt("{{kv.color}} {{vars.a.b}} {{now.date}} {{chat.name}} {{kv.x}}").ListVars(),
+		map[string]interface{}{"a": map[string]interface{}{"b": "c"}},
+		&database.Chat{ID: "chat", Name: &chatName},
+	)
+
+	if vars["kv.color"] != "blue" || vars["vars.a.b"] != "c" || vars["chat.name"] != "Support" {
+		t.Fatalf("Unexpected vars: %v", vars)
+	}
+
+	if _, ok := vars["now.date"]; !ok {
+		t.Fatalf("The date should be set, got %v", vars)
+	}
+
+	if _, ok := vars["kv.x"]; ok {
+		t.Fatalf("A missing kv should not be set, got %v", vars)
+	}
+}
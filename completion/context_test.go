@@ -23,6 +23,15 @@ func mockMatchEmbeddings(_ []string, _ string, _ []float32) ([]database.MatchRes
 	return []database.MatchResult{result}, nil
 }
 
+func mockGetMemory(memoryID string) (*database.Memory, error) {
+	return &database.Memory{
+		ID:                 memoryID,
+		UserID:             "00000000-0000-0000-0000-000000000000",
+		EmbeddingModel:     "text-embedding-ada-002",
+		EmbeddingDimension: 3, // The size of the mock server's embeddings
+	}, nil
+}
+
 func TestContextStringMemory(t *testing.T) {
 	utils.SetLogLevel("WARN")
 	ctx := context.Background()
@@ -42,6 +51,7 @@ func TestContextStringMemory(t *testing.T) {
 			MockGetExistingEmbeddingFromContent: mockGetExistingEmbeddingFromContent,
 			MockLogRequests:                     mockLogRequests,
 			MockMatchEmbeddings:                 mockMatchEmbeddings,
+			MockGetMemory:                       mockGetMemory,
 		},
 	)
 
@@ -50,7 +60,7 @@ func TestContextStringMemory(t *testing.T) {
 		MemoryID: "11100000-0000-0000-0000-000000000000",
 	}
 
-	result, _, err := GetContextString(ctx, userID, reqBody, nil, nil)
+	result, _, _, _, err := GetContextString(ctx, userID, reqBody, MaxContentLength, nil, nil)
 	if err != nil {
 		t.Fatalf(`GetContextString returned an error %v`, err)
 	}
@@ -59,3 +69,23 @@ func TestContextStringMemory(t *testing.T) {
 		t.Fatalf(`GetContextString doesn't contains "banana42". ContextString: "%s"`, result)
 	}
 }
+
+func TestGetContextBudget(t *testing.T) {
+	maxTokens := 1000
+	input := GenerateRequestBody{Task: "Hello", MaxTokens: &maxTokens}
+
+	budget := GetContextBudget("openai", "gpt-4", input)
+	if budget.ContextWindow != 8192 || budget.Budget != 8192-budget.TaskTokens-1000 {
+		t.Fatalf("Unexpected gpt-4 budget %v", budget)
+	}
+
+	budget = GetContextBudget("openai", "gpt-4o", GenerateRequestBody{Task: "Hello"})
+	if budget.Budget != 128000-budget.TaskTokens-DefaultReservedOutputTokens {
+		t.Fatalf("Unexpected gpt-4o budget %v", budget)
+	}
+
+	budget = GetContextBudget("openai-compatible", "unknown", input)
+	if budget.ContextWindow != 0 || budget.Budget != MaxContentLength {
+		t.Fatalf("Unknown models should keep the default budget, got %v", budget)
+	}
+}
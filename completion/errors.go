@@ -2,16 +2,76 @@ package completion
 
 import (
 	"errors"
+
+	webrequest "github.com/polyfire/api/web_request"
 )
 
 var (
-	ErrUnknownUserID           = errors.New("400 Unknown user Id")
-	ErrInternalServerError     = errors.New("500 InternalServerError")
-	ErrUnknownModelProvider    = errors.New("400 Unknown model provider")
-	ErrNotFound                = errors.New("404 Not Found")
-	ErrRateLimitReached        = errors.New("429 Monthly Rate Limit Reached")
-	ErrCreditsUsedUp           = errors.New("429 Credits Used Up")
-	ErrProjectRateLimitReached = errors.New("429 Monthly Project Rate Limit Reached")
-	ErrProjectNotPremiumModel  = errors.New("403 Project Can't Use Premium Models")
-	ErrUnknownError            = errors.New("500 Unknown Error")
+	ErrUnknownUserID            = errors.New("400 Unknown user Id")
+	ErrInternalServerError      = errors.New("500 InternalServerError")
+	ErrUnknownModelProvider     = errors.New("400 Unknown model provider")
+	ErrInvalidModelConfig       = errors.New("400 Invalid model configuration")
+	ErrInvalidMaxTokens         = errors.New("400 Invalid max tokens")
+	ErrInvalidImage             = errors.New("400 Invalid image")
+	ErrVisionNotSupported       = errors.New("400 Model doesn't support images")
+	ErrEmbeddingModelMismatch   = errors.New("400 Memories use different embedding models")
+	ErrInvalidJSONSchema        = errors.New("400 Invalid JSON schema")
+	ErrInvalidJSONSchemaRetries = errors.New("400 Invalid JSON schema retries")
+	ErrJSONSchemaOptions        = errors.New("400 JSON schema can't be used with these options")
+	ErrInvalidChatBranch        = errors.New("400 Invalid chat branch")
+	ErrSharedChatTooLong        = errors.New("400 Shared chat too long")
+	ErrNotFound                 = errors.New("404 Not Found")
+	ErrRateLimitReached         = errors.New("429 Monthly Rate Limit Reached")
+	ErrCreditsUsedUp            = errors.New("429 Credits Used Up")
+	ErrProjectRateLimitReached  = errors.New("429 Monthly Project Rate Limit Reached")
+	ErrProjectNotPremiumModel   = errors.New("403 Project Can't Use Premium Models")
+	ErrUnknownError             = errors.New("500 Unknown Error")
 )
+
+// ErrorCode returns the code of the error message to respond with
+func ErrorCode(err error) string {
+	switch err {
+	case webrequest.ErrWebsiteExceedsLimit:
+		return "error_website_exceeds_limit"
+	case webrequest.ErrWebsitesContentExceeds:
+		return "error_websites_content_exceeds"
+	case webrequest.ErrFetchWebpage:
+		return "error_fetch_webpage"
+	case webrequest.ErrParseContent:
+		return "error_parse_content"
+	case webrequest.ErrVisitBaseURL:
+		return "error_visit_base_url"
+	case ErrInvalidChatBranch:
+		return "invalid_chat_branch"
+	case ErrSharedChatTooLong:
+		return "shared_chat_too_long"
+	case ErrNotFound:
+		return "not_found"
+	case ErrUnknownModelProvider:
+		return "invalid_model_provider"
+	case ErrInvalidModelConfig:
+		return "invalid_model_configuration"
+	case ErrInvalidMaxTokens:
+		return "invalid_max_tokens"
+	case ErrInvalidImage:
+		return "invalid_image"
+	case ErrVisionNotSupported:
+		return "vision_not_supported"
+	case ErrEmbeddingModelMismatch:
+		return "embedding_model_mismatch"
+	case ErrInvalidJSONSchema:
+		return "invalid_json_schema"
+	case ErrInvalidJSONSchemaRetries:
+		return "invalid_json_schema_retries"
+	case ErrJSONSchemaOptions:
+		return "json_schema_incompatible_options"
+	case ErrRateLimitReached:
+		return "rate_limit_reached"
+	case ErrCreditsUsedUp:
+		return "credits_used_up"
+	case ErrProjectRateLimitReached:
+		return "project_rate_limit_reached"
+	default:
+		return "internal_error"
+	}
+}
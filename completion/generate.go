@@ -2,32 +2,79 @@ package completion
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"log"
 
 	database "github.com/polyfire/api/db"
 	"github.com/polyfire/api/llm"
 	"github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/memory"
+	"github.com/polyfire/api/registry"
 	"github.com/polyfire/api/utils"
 )
 
 type GenerateRequestBody struct {
-	Task           string      `json:"task"`
-	Model          string      `json:"model,omitempty"`
-	MemoryID       interface{} `json:"memory_id,omitempty"`
-	ChatID         *string     `json:"chat_id,omitempty"`
-	Stop           *[]string   `json:"stop,omitempty"`
-	Temperature    *float32    `json:"temperature,omitempty"`
-	Stream         bool        `json:"stream,omitempty"`
-	SystemPromptID *string     `json:"system_prompt_id,omitempty"`
-	SystemPrompt   *string     `json:"system_prompt,omitempty"`
-	WebRequest     bool        `json:"web,omitempty"`
-	Language       *string     `json:"language,omitempty"`
-	FuzzyCache     bool        `json:"fuzzy_cache,omitempty"`
-	Cache          *bool       `json:"cache,omitempty"`
-	Infos          bool        `json:"infos,omitempty"`
-	AutoComplete   bool        `json:"auto_complete,omitempty"`
-	JSONFormat     bool        `json:"json_format,omitempty"`
+	Task             string                 `json:"task"`
+	Model            string                 `json:"model,omitempty"`
+	MemoryID         interface{}            `json:"memory_id,omitempty"`
+	ChatID           *string                `json:"chat_id,omitempty"`
+	Stop             *[]string              `json:"stop,omitempty"`
+	Temperature      *float32               `json:"temperature,omitempty"`
+	Stream           bool                   `json:"stream,omitempty"`
+	SystemPromptID   *string                `json:"system_prompt_id,omitempty"`
+	SystemPrompt     *string                `json:"system_prompt,omitempty"`
+	Vars             map[string]interface{} `json:"vars,omitempty"` // Used by the system prompt
+	WebRequest       bool                   `json:"web,omitempty"`
+	Language         *string                `json:"language,omitempty"`
+	FuzzyCache       bool                   `json:"fuzzy_cache,omitempty"`
+	Cache            *bool                  `json:"cache,omitempty"`
+	Infos            bool                   `json:"infos,omitempty"`
+	AutoComplete     bool                   `json:"auto_complete,omitempty"`
+	JSONFormat       bool                   `json:"json_format,omitempty"`
+	MaxTokens        *int                   `json:"max_tokens,omitempty"` // Also caps the cost
+	TopP             *float32               `json:"top_p,omitempty"`
+	PresencePenalty  *float32               `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32               `json:"frequency_penalty,omitempty"`
+	Seed             *int                   `json:"seed,omitempty"`
+	LogitBias        map[string]int         `json:"logit_bias,omitempty"`
+	N                int                    `json:"n,omitempty"`
+	Logprobs         bool                   `json:"logprobs,omitempty"`
+	TopLogprobs      *int                   `json:"top_logprobs,omitempty"`
+	Images           []string               `json:"images,omitempty"` // URLs or base64
+	Tools            []options.Tool         `json:"tools,omitempty"`
+	ToolChoice       interface{}            `json:"tool_choice,omitempty"`
+	// The answer is validated against the schema and the model asked again while it doesn't
+	// match, up to json_schema_retries times
+	JSONSchema        json.RawMessage `json:"json_schema,omitempty"`
+	JSONSchemaRetries *int            `json:"json_schema_retries,omitempty"`
+	// Messages added to the conversation after the task. It is used to send back the
+	// assistant tool calls followed by the results of the tools.
+	Messages []options.Message `json:"messages,omitempty"`
+	// Branch the chat: the task replaces a user message of the chat, or an answer of the
+	// chat is generated again without a task
+	EditMessageID       *string `json:"edit_message_id,omitempty"`
+	RegenerateMessageID *string `json:"regenerate_message_id,omitempty"`
+}
+
+// supportsVision is also true for the models that aren't in the registry, we let their
+// provider refuse the images.
+func supportsVision(providerName string, modelName string) bool {
+	m, ok := registry.Find(providerName, modelName)
+	return !ok || m.Capabilities.Vision
+}
+
+// HasCandidates reports whether the response must detail each candidate rather than
+// only give the completion.
+func (input GenerateRequestBody) HasCandidates() bool {
+	return input.N > 1 || input.Logprobs || input.TopLogprobs != nil
+}
+
+// HasSamplingOptions reports whether the completion depends on options the cache doesn't
+// store, like max_tokens that can truncate it.
+func (input GenerateRequestBody) HasSamplingOptions() bool {
+	return input.MaxTokens != nil || input.TopP != nil || input.Seed != nil ||
+		input.PresencePenalty != nil || input.FrequencyPenalty != nil || len(input.LogitBias) > 0
 }
 
 func getLanguageCompletion(language *string) string {
@@ -45,6 +92,62 @@ func GenerationStart(
 	db := ctx.Value(utils.ContextKeyDB).(database.Database)
 	resources := []database.MatchResult{}
 
+	if input.MaxTokens != nil && *input.MaxTokens <= 0 {
+		return nil, ErrInvalidMaxTokens
+	}
+
+	var jsonSchema JSONSchema
+	jsonSchemaRetries := DefaultJSONSchemaRetries
+	if len(input.JSONSchema) > 0 {
+		var err error
+		jsonSchema, err = parseJSONSchema(input.JSONSchema)
+		if err != nil {
+			return nil, err
+		}
+
+		// The answer is validated as a whole, it can't be a tool call or several candidates
+		if len(input.Tools) > 0 || input.HasCandidates() || input.AutoComplete {
+			return nil, ErrJSONSchemaOptions
+		}
+
+		if input.JSONSchemaRetries != nil {
+			jsonSchemaRetries = *input.JSONSchemaRetries
+		}
+		if jsonSchemaRetries < 0 || jsonSchemaRetries > MaxJSONSchemaRetries {
+			return nil, ErrInvalidJSONSchemaRetries
+		}
+	}
+
+	images := make([]string, len(input.Images))
+	for i, image := range input.Images {
+		var err error
+		images[i], err = options.NormalizeImage(image)
+		if err != nil {
+			return nil, ErrInvalidImage
+		}
+	}
+	input.Images = images
+
+	for i := range input.Messages {
+		for j, image := range input.Messages[i].Images {
+			var err error
+			input.Messages[i].Images[j], err = options.NormalizeImage(image)
+			if err != nil {
+				return nil, ErrInvalidImage
+			}
+		}
+	}
+
+	// The memory context is optional, so check the memories can be searched together
+	// now instead of silently generating without it.
+	memoryIDs := utils.StringOptionalArray(input.MemoryID)
+	if len(memoryIDs) > 1 {
+		_, err := memory.GetEmbeddingModel(ctx, memoryIDs)
+		if errors.Is(err, memory.ErrEmbeddingModelMismatch) {
+			return nil, ErrEmbeddingModelMismatch
+		}
+	}
+
 	log.Println("[DEBUG] Init provider")
 
 	// Get provider
@@ -53,12 +156,21 @@ func GenerationStart(
 		return nil, ErrUnknownModelProvider
 	}
 
+	if errors.Is(err, llm.ErrInvalidModelConfiguration) {
+		return nil, ErrInvalidModelConfig
+	}
+
 	if err != nil {
 		return nil, ErrInternalServerError
 	}
 
 	providerName, modelName := provider.ProviderModel()
 
+	vision := supportsVision(providerName, modelName)
+	if len(input.Images) > 0 && !vision {
+		return nil, ErrVisionNotSupported
+	}
+
 	// Check Rate Limit
 	if provider.DoesFollowRateLimit() {
 		log.Println("[DEBUG] Check Rate Limit")
@@ -90,7 +202,21 @@ func GenerationStart(
 
 	// Get Options
 	opts := options.ProviderOptions{
-		JSONFormat: input.JSONFormat,
+		JSONFormat:   input.JSONFormat,
+		JSONSchema:   input.JSONSchema,
+		AutoComplete: input.AutoComplete,
+		Tools:        input.Tools,
+		ToolChoice:   input.ToolChoice,
+
+		MaxTokens:        input.MaxTokens,
+		TopP:             input.TopP,
+		PresencePenalty:  input.PresencePenalty,
+		FrequencyPenalty: input.FrequencyPenalty,
+		Seed:             input.Seed,
+		LogitBias:        input.LogitBias,
+		N:                input.N,
+		Logprobs:         input.Logprobs,
+		TopLogprobs:      input.TopLogprobs,
 	}
 	if input.Stop != nil {
 		opts.StopWords = input.Stop
@@ -99,12 +225,57 @@ func GenerationStart(
 		opts.Temperature = input.Temperature
 	}
 
+	// The branch of an edit or a regeneration is created before reading the chat history,
+	// it's undone if the generation fails or ends without adding an answer to the chat
+	var restoreBranch func()
+	if input.ChatID != nil && len(*input.ChatID) > 0 {
+		restoreBranch, err = BranchChat(ctx, userID, *input.ChatID, input)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	generating := false
+	defer func() {
+		if restoreBranch != nil && !generating {
+			restoreBranch()
+		}
+	}()
+
+	// The chat history wraps the callback to add the answer to the chat, the attempts of
+	// the JSON schema generation must only be billed
+	billingCallback := callback
+
 	// Get Context elements
-	contextString, warnings, err := GetContextString(ctx, userID, input, &callback, &opts)
+	contextBudget := GetContextBudget(providerName, modelName, input)
+	contextString, history, warnings, servedPrompt, err := GetContextString(
+		ctx,
+		userID,
+		input,
+		contextBudget.Budget,
+		&callback,
+		&opts,
+	)
 	if err != nil {
 		return nil, err
 	}
 
+	answered := false
+	if restoreBranch != nil {
+		addAnswer := callback
+		callback = func(
+			providerName string,
+			modelName string,
+			inputCount int,
+			outputCount int,
+			completion string,
+			credit *int,
+		) {
+			answered = true
+			addAnswer(providerName, modelName, inputCount, outputCount, completion, credit)
+		}
+	}
+
 	/*
 		If the autocomplete flag is on, we skip the question/answer prompt and put
 		the LLM "cursor" at the end of the task, effectively asking it to complete
@@ -113,21 +284,60 @@ func GenerationStart(
 		This might not be enough for some models retrained to answer chat questions
 		instead of just completing a text. The systemPrompt should also be ajusted.
 	*/
-	var prompt string
+	var messages []options.Message
 	if input.AutoComplete {
-		prompt = getLanguageCompletion(input.Language) + contextString + "\n" + input.Task
+		messages = []options.Message{{
+			Role:    options.RoleUser,
+			Content: getLanguageCompletion(input.Language) + contextString + "\n" + input.Task,
+			Images:  input.Images,
+		}}
 	} else {
-		prompt = getLanguageCompletion(input.Language) + contextString + "\nUser:\n" + input.Task + "\nYou:\n"
+		system := getLanguageCompletion(input.Language) + contextString
+		if jsonSchema != nil {
+			system += jsonSchemaInstructions(input.JSONSchema)
+		}
+		if system != "" {
+			messages = append(messages, options.Message{Role: options.RoleSystem, Content: system})
+		}
+		messages = append(messages, history...)
+		if input.Task != "" || len(input.Images) > 0 {
+			messages = append(
+				messages,
+				options.Message{
+					Role:    options.RoleUser,
+					Content: input.Task,
+					Images:  input.Images,
+				},
+			)
+		}
+		messages = append(messages, input.Messages...)
 	}
 
+	// The images of the chat history can't be sent to a text-only model
+	if !vision {
+		for i := range messages {
+			messages[i].Images = nil
+		}
+	}
+
+	// The flattened prompt is only used to log the request and as the cache key
+	prompt := options.FlattenMessages(messages, input.AutoComplete)
+
 	log.Println("[INFO] Prompt: " + prompt)
 
 	var result chan options.Result
 
 	var embeddings []float32
 
-	if input.Temperature != nil && *(input.Temperature) == 0.0 &&
-		(input.Cache == nil || *(input.Cache)) {
+	// The cache only stores the text of a single completion for a prompt, it can't be used
+	// to answer tool calls, several candidates, logprobs or with other sampling options
+	cacheable := len(input.Tools) == 0 && !input.HasCandidates() && !input.HasSamplingOptions() &&
+		options.CountImages(messages) == 0 && jsonSchema == nil
+	exactCache := input.Temperature != nil && *(input.Temperature) == 0.0 &&
+		(input.Cache == nil || *(input.Cache)) && cacheable
+	fuzzyCache := input.FuzzyCache && cacheable
+
+	if exactCache {
 		result, err = CheckExactCache(ctx, prompt, providerName, modelName)
 	}
 
@@ -142,7 +352,7 @@ func GenerationStart(
 	// The fuzzy cache check for "close enough" embeddings.
 	// It can reduce costs a lot in some cases but might lead to data leakage.
 	// It should never be used in places with user personnal informations.
-	if input.FuzzyCache {
+	if fuzzyCache {
 		result, embeddings, err = CheckFuzzyCache(ctx, prompt, providerName, modelName)
 	}
 
@@ -155,13 +365,28 @@ func GenerationStart(
 	}
 
 	log.Println("[DEBUG] Generate")
-	resChan := provider.Generate(prompt, &callback, &opts)
+	var resChan chan options.Result
+	if jsonSchema != nil {
+		resChan = generateWithJSONSchema(
+			ctx,
+			provider,
+			messages,
+			&billingCallback,
+			&callback,
+			&opts,
+			jsonSchema,
+			jsonSchemaRetries,
+		)
+	} else {
+		resChan = provider.Generate(ctx, messages, &callback, &opts)
+	}
 
 	if input.AutoComplete {
 		resChan = AddSpaceIfNeeded(prompt, resChan)
 	}
 
 	result = make(chan options.Result)
+	generating = true
 
 	// Add warnings and cache at the end of the generation
 	go func() {
@@ -169,11 +394,27 @@ func GenerationStart(
 		totalCompletion := ""
 		for res := range resChan {
 			result <- res
-			totalCompletion += res.Result
+			if res.Index == 0 {
+				totalCompletion += res.Result
+			}
 		}
-		result <- options.Result{Resources: resources, Warnings: warnings}
-		if (input.Temperature != nil && *(input.Temperature) == 0.0 && (input.Cache == nil || *(input.Cache))) ||
-			input.FuzzyCache {
+
+		// The callbacks are called before the end of the generation
+		if restoreBranch != nil && !answered {
+			restoreBranch()
+		}
+		answeringProvider, answeringModel := provider.ProviderModel()
+		result <- options.Result{
+			Resources:     resources,
+			Warnings:      warnings,
+			Provider:      answeringProvider,
+			Model:         answeringModel,
+			ContextBudget: &contextBudget,
+			Prompt:        servedPrompt,
+		}
+
+		// A cancelled generation is incomplete and mustn't be cached
+		if ctx.Err() == nil && (exactCache || fuzzyCache) {
 			_ = db.AddCompletionCache(
 				embeddings,
 				prompt,
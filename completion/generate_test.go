@@ -67,3 +67,51 @@ func TestSimpleFullGeneration(t *testing.T) {
 		t.Fatalf(`Generate("Test") should have returned "Test response" but returned "%s"`, str)
 	}
 }
+
+func TestSamplingOptionsSkipCache(t *testing.T) {
+	utils.SetLogLevel("WARN")
+	ctx := utils.MockOpenAIServer(context.Background())
+
+	cached := false
+	ctx = context.WithValue(
+		ctx,
+		utils.ContextKeyDB,
+		database.MockDatabase{
+			MockLogRequests:                   mockLogRequests,
+			MockGetExactCompletionCacheByHash: mockCacheHit,
+			MockAddCompletionCache: func(
+				_ []float32,
+				_ string,
+				_ string,
+				_ string,
+				_ string,
+				_ bool,
+			) error {
+				cached = true
+				return nil
+			},
+		},
+	)
+	ctx = context.WithValue(ctx, utils.ContextKeyRateLimitStatus, database.RateLimitStatusOk)
+	ctx = context.WithValue(ctx, utils.ContextKeyCreditsStatus, database.CreditsStatusOk)
+	ctx = context.WithValue(ctx, utils.ContextKeyEventID, "00000000-0000-0000-0000-000000000000")
+
+	// A truncated answer mustn't be cached nor be answered from the cache
+	temperature := float32(0)
+	maxTokens := 5
+	reqBody := GenerateRequestBody{Task: "Test", Temperature: &temperature, MaxTokens: &maxTokens}
+
+	result, err := GenerationStart(ctx, "00000000-0000-0000-0000-000000000000", reqBody)
+	if err != nil {
+		t.Fatalf(`GenerationStart returned an error %v`, err)
+	}
+
+	str := ""
+	for v := range *result {
+		str += v.Result
+	}
+
+	if str != "Test response" || cached {
+		t.Fatalf(`The cache shouldn't be used with max_tokens, got "%s" (cached: %v)`, str, cached)
+	}
+}
@@ -0,0 +1,431 @@
+package completion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/polyfire/api/llm"
+	"github.com/polyfire/api/llm/providers/options"
+)
+
+const (
+	DefaultJSONSchemaRetries = 2
+	MaxJSONSchemaRetries     = 5
+)
+
+type JSONSchema = map[string]interface{}
+
+func parseJSONSchema(raw json.RawMessage) (JSONSchema, error) {
+	var schema JSONSchema
+	err := json.Unmarshal(raw, &schema)
+	if err != nil || schema == nil {
+		return nil, ErrInvalidJSONSchema
+	}
+	return schema, nil
+}
+
+func jsonSchemaInstructions(raw json.RawMessage) string {
+	return "Answer only with a JSON value matching the following JSON schema, without any " +
+		"other text or markdown:\n" + string(raw) + "\n"
+}
+
+/*
+ * ValidateJSONSchema returns the reasons the value doesn't match the schema. Only the
+ * common keywords are checked (types, enum, const, properties, required,
+ * additionalProperties, items, the bounds, pattern and the combinations), the others
+ * like $ref are ignored.
+ */
+func ValidateJSONSchema(schema JSONSchema, value interface{}) []string {
+	return validateJSONSchema(schema, value, "$")
+}
+
+func validateJSONSchema(schema JSONSchema, value interface{}, path string) []string {
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		matching := false
+		for _, t := range types {
+			matching = matching || hasJSONType(value, t)
+		}
+		if !matching {
+			return []string{fmt.Sprintf(
+				"%s: expected %s, got %s",
+				path,
+				strings.Join(types, " or "),
+				jsonType(value),
+			)}
+		}
+	}
+
+	errs := validateJSONValue(schema, value, path)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		errs = append(errs, validateJSONObject(schema, v, path)...)
+	case []interface{}:
+		errs = append(errs, validateJSONArray(schema, v, path)...)
+	case string:
+		errs = append(errs, validateJSONString(schema, v, path)...)
+	case float64:
+		errs = append(errs, validateJSONNumber(schema, v, path)...)
+	}
+
+	return append(errs, validateJSONCombinations(schema, value, path)...)
+}
+
+func validateJSONValue(schema JSONSchema, value interface{}, path string) []string {
+	var errs []string
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range enum {
+			found = found || jsonEqual(v, value)
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: must be one of %s", path, mustMarshal(enum)))
+		}
+	}
+
+	if constant, ok := schema["const"]; ok && !jsonEqual(constant, value) {
+		errs = append(errs, fmt.Sprintf("%s: must be %s", path, mustMarshal(constant)))
+	}
+
+	return errs
+}
+
+func validateJSONArray(schema JSONSchema, value []interface{}, path string) []string {
+	var errs []string
+
+	if minItems, ok := schemaNumber(schema, "minItems"); ok && float64(len(value)) < minItems {
+		errs = append(errs, fmt.Sprintf("%s: must have at least %v items", path, minItems))
+	}
+	if maxItems, ok := schemaNumber(schema, "maxItems"); ok && float64(len(value)) > maxItems {
+		errs = append(errs, fmt.Sprintf("%s: must have at most %v items", path, maxItems))
+	}
+
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range value {
+			errs = append(errs, validateJSONSchema(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+
+	return errs
+}
+
+func validateJSONString(schema JSONSchema, value string, path string) []string {
+	var errs []string
+
+	length := float64(len([]rune(value)))
+	if minLength, ok := schemaNumber(schema, "minLength"); ok && length < minLength {
+		errs = append(errs, fmt.Sprintf("%s: must be at least %v characters long", path, minLength))
+	}
+	if maxLength, ok := schemaNumber(schema, "maxLength"); ok && length > maxLength {
+		errs = append(errs, fmt.Sprintf("%s: must be at most %v characters long", path, maxLength))
+	}
+
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err == nil && !re.MatchString(value) {
+			errs = append(errs, fmt.Sprintf("%s: must match the pattern %q", path, pattern))
+		}
+	}
+
+	return errs
+}
+
+func validateJSONNumber(schema JSONSchema, value float64, path string) []string {
+	var errs []string
+
+	if minimum, ok := schemaNumber(schema, "minimum"); ok && value < minimum {
+		errs = append(errs, fmt.Sprintf("%s: must be at least %v", path, minimum))
+	}
+	if maximum, ok := schemaNumber(schema, "maximum"); ok && value > maximum {
+		errs = append(errs, fmt.Sprintf("%s: must be at most %v", path, maximum))
+	}
+
+	return errs
+}
+
+func validateJSONCombinations(schema JSONSchema, value interface{}, path string) []string {
+	var errs []string
+
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, s := range allOf {
+			if sub, ok := s.(map[string]interface{}); ok {
+				errs = append(errs, validateJSONSchema(sub, value, path)...)
+			}
+		}
+	}
+
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && countMatching(anyOf, value, path) == 0 {
+		errs = append(errs, path+": must match at least one of the anyOf schemas")
+	}
+
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok && countMatching(oneOf, value, path) != 1 {
+		errs = append(errs, path+": must match exactly one of the oneOf schemas")
+	}
+
+	return errs
+}
+
+func validateJSONObject(schema JSONSchema, value map[string]interface{}, path string) []string {
+	var errs []string
+
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := value[name]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: the property %q is required", path, name))
+			}
+		}
+	}
+
+	// Sorted so the errors, and the prompt asking to fix them, don't change between runs
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		propertyPath := path + "." + key
+
+		if property, ok := properties[key].(map[string]interface{}); ok {
+			errs = append(errs, validateJSONSchema(property, value[key], propertyPath)...)
+			continue
+		}
+		if _, ok := properties[key]; ok {
+			continue
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				errs = append(errs, fmt.Sprintf("%s: the property %q is not allowed", path, key))
+			}
+		case map[string]interface{}:
+			errs = append(errs, validateJSONSchema(additional, value[key], propertyPath)...)
+		}
+	}
+
+	return errs
+}
+
+func countMatching(schemas []interface{}, value interface{}, path string) int {
+	count := 0
+	for _, s := range schemas {
+		if sub, ok := s.(map[string]interface{}); ok && len(validateJSONSchema(sub, value, path)) == 0 {
+			count++
+		}
+	}
+	return count
+}
+
+func schemaTypes(t interface{}) []string {
+	switch t := t.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var types []string
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func schemaNumber(schema JSONSchema, keyword string) (float64, bool) {
+	n, ok := schema[keyword].(float64)
+	return n, ok
+}
+
+func hasJSONType(value interface{}, t string) bool {
+	if t == "integer" {
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	}
+	return jsonType(value) == t
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func jsonEqual(a interface{}, b interface{}) bool {
+	return mustMarshal(a) == mustMarshal(b)
+}
+
+func mustMarshal(v interface{}) string {
+	bytes, _ := json.Marshal(v)
+	return string(bytes)
+}
+
+// Models without a native structured output often wrap the JSON in a markdown code block
+func trimJSONOutput(output string) string {
+	output = strings.TrimSpace(output)
+	if strings.HasPrefix(output, "```") {
+		output = strings.TrimPrefix(output, "```json")
+		output = strings.TrimPrefix(output, "```")
+		output = strings.TrimSuffix(output, "```")
+	}
+	return strings.TrimSpace(output)
+}
+
+func validateJSONOutput(schema JSONSchema, output string) (string, []string) {
+	output = trimJSONOutput(output)
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(output), &value); err != nil {
+		return output, []string{"The answer is not valid JSON: " + err.Error()}
+	}
+
+	return output, ValidateJSONSchema(schema, value)
+}
+
+// attemptUsage keeps the usage reported by the provider for an attempt, to only decide
+// once the answer is validated which callback it goes through.
+type attemptUsage struct {
+	called       bool
+	providerName string
+	modelName    string
+	inputCount   int
+	outputCount  int
+	credit       *int
+}
+
+func (a *attemptUsage) callback() options.ProviderCallback {
+	c := func(
+		providerName string,
+		modelName string,
+		inputCount int,
+		outputCount int,
+		_ string,
+		credit *int,
+	) {
+		*a = attemptUsage{
+			called:       true,
+			providerName: providerName,
+			modelName:    modelName,
+			inputCount:   inputCount,
+			outputCount:  outputCount,
+			credit:       credit,
+		}
+	}
+	return &c
+}
+
+func (a attemptUsage) report(callback options.ProviderCallback, completion string) {
+	if a.called && callback != nil && *callback != nil {
+		(*callback)(a.providerName, a.modelName, a.inputCount, a.outputCount, completion, a.credit)
+	}
+}
+
+/*
+ * generateWithJSONSchema buffers the generation to validate it against the schema. While
+ * it doesn't match, the model is asked again with the validation errors, up to retries
+ * times. The answer is then sent in a single chunk with the usage of all the attempts.
+ *
+ * Every attempt is billed through billing, only the valid answer goes through callback,
+ * which can also add it to the chat.
+ */
+func generateWithJSONSchema(
+	ctx context.Context,
+	provider llm.Provider,
+	messages []options.Message,
+	billing options.ProviderCallback,
+	callback options.ProviderCallback,
+	opts *options.ProviderOptions,
+	schema JSONSchema,
+	retries int,
+) chan options.Result {
+	chanRes := make(chan options.Result)
+
+	go func() {
+		defer close(chanRes)
+
+		tokenUsage := options.TokenUsage{}
+
+		for attempt := 0; ; attempt++ {
+			usage := attemptUsage{}
+			output := ""
+			errorCode := ""
+
+			// The channel is drained after an error too, so the provider can finish and
+			// report its usage
+			for res := range provider.Generate(ctx, messages, usage.callback(), opts) {
+				if errorCode != "" {
+					continue
+				}
+				if res.Err != "" {
+					errorCode = res.Err
+					continue
+				}
+				if len(res.Warnings) > 0 {
+					chanRes <- options.Result{Warnings: res.Warnings}
+				}
+				output += res.Result
+				tokenUsage.Input += res.TokenUsage.Input
+				tokenUsage.Output += res.TokenUsage.Output
+			}
+
+			if errorCode != "" {
+				usage.report(billing, output)
+				chanRes <- options.Result{Err: errorCode, TokenUsage: tokenUsage}
+				return
+			}
+
+			output, errs := validateJSONOutput(schema, output)
+			if len(errs) == 0 {
+				usage.report(callback, output)
+				chanRes <- options.Result{Result: output, TokenUsage: tokenUsage}
+				return
+			}
+
+			usage.report(billing, output)
+
+			if attempt >= retries || ctx.Err() != nil {
+				chanRes <- options.Result{
+					Result:     output,
+					TokenUsage: tokenUsage,
+					Err:        "json_schema_validation_failed",
+				}
+				return
+			}
+
+			messages = append(
+				messages,
+				options.Message{Role: options.RoleAssistant, Content: output},
+				options.Message{
+					Role: options.RoleUser,
+					Content: "Your answer doesn't match the JSON schema:\n - " +
+						strings.Join(errs, "\n - ") +
+						"\nAnswer again with only the corrected JSON.",
+				},
+			)
+		}
+	}()
+
+	return chanRes
+}
@@ -0,0 +1,175 @@
+package completion
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/polyfire/api/llm/providers/options"
+)
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"name": { "type": "string", "minLength": 1 },
+		"age": { "type": "integer", "minimum": 0 },
+		"tags": { "type": "array", "items": { "enum": ["a", "b"] } }
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false
+}`
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema, err := parseJSONSchema(json.RawMessage(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := "```json\n" + `{"name": "Bob", "age": 42, "tags": ["a"]}` + "\n```"
+	_, errs := validateJSONOutput(schema, output)
+	if len(errs) != 0 {
+		t.Fatalf("The answer should match the schema, got %v", errs)
+	}
+
+	_, errs = validateJSONOutput(schema, `{"age": 4.2, "tags": ["c"], "other": true}`)
+	expected := []string{
+		`$: the property "name" is required`,
+		"$.age: expected integer, got number",
+		`$: the property "other" is not allowed`,
+		`$.tags[0]: must be one of ["a","b"]`,
+	}
+	if strings.Join(errs, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected validation errors %v", errs)
+	}
+
+	_, errs = validateJSONOutput(schema, `{"name": "Bob"`)
+	if len(errs) != 1 || !strings.HasPrefix(errs[0], "The answer is not valid JSON") {
+		t.Fatalf("Expected a JSON syntax error, got %v", errs)
+	}
+}
+
+type scriptedProvider struct {
+	answers  []string
+	messages *[][]options.Message
+}
+
+func (p scriptedProvider) Name() string {
+	return "scripted"
+}
+
+func (p scriptedProvider) ProviderModel() (string, string) {
+	return "scripted", "scripted"
+}
+
+func (p scriptedProvider) DoesFollowRateLimit() bool {
+	return false
+}
+
+func (p scriptedProvider) Generate(
+	_ context.Context,
+	messages []options.Message,
+	c options.ProviderCallback,
+	_ *options.ProviderOptions,
+) chan options.Result {
+	answer := p.answers[len(*p.messages)]
+	*p.messages = append(*p.messages, messages)
+
+	chanRes := make(chan options.Result)
+	go func() {
+		defer close(chanRes)
+		if answer == "" {
+			// Like the providers that keep sending after an error
+			chanRes <- options.Result{Err: "generation_error"}
+		}
+		chanRes <- options.Result{Result: answer, TokenUsage: options.TokenUsage{Input: 1}}
+		chanRes <- options.Result{TokenUsage: options.TokenUsage{Output: 1}}
+		if c != nil {
+			(*c)("scripted", "scripted", 1, 1, answer, nil)
+		}
+	}()
+	return chanRes
+}
+
+func TestGenerateWithJSONSchemaRetries(t *testing.T) {
+	schema, _ := parseJSONSchema(json.RawMessage(testSchema))
+
+	var calls [][]options.Message
+	provider := scriptedProvider{
+		answers:  []string{`{"name": "Bob"}`, `{"name": "Bob", "age": 42}`},
+		messages: &calls,
+	}
+
+	messages := []options.Message{{Role: options.RoleUser, Content: "Who?"}}
+
+	ctx := context.Background()
+
+	var results []options.Result
+	for res := range generateWithJSONSchema(ctx, provider, messages, nil, nil, nil, schema, 2) {
+		results = append(results, res)
+	}
+
+	if len(calls) != 2 || !strings.Contains(calls[1][len(calls[1])-1].Content, `"age" is required`) {
+		t.Fatalf("The model should have been asked again with the errors, got %v", calls)
+	}
+
+	last := results[len(results)-1]
+	if last.Err != "" || last.Result != `{"name": "Bob", "age": 42}` {
+		t.Fatalf("Unexpected result %v", last)
+	}
+
+	if last.TokenUsage.Input != 2 || last.TokenUsage.Output != 2 {
+		t.Fatalf("The usage of both attempts should be counted, got %v", last.TokenUsage)
+	}
+
+	calls = nil
+	provider.answers = []string{"nope"}
+	for res := range generateWithJSONSchema(ctx, provider, messages, nil, nil, nil, schema, 0) {
+		last = res
+	}
+
+	if last.Err != "json_schema_validation_failed" || len(calls) != 1 {
+		t.Fatalf("Expected a validation failure without retry, got %v", last)
+	}
+}
+
+func TestGenerateWithJSONSchemaCallbacks(t *testing.T) {
+	schema, _ := parseJSONSchema(json.RawMessage(testSchema))
+
+	var calls [][]options.Message
+	provider := scriptedProvider{
+		answers:  []string{`{"name": "Bob"}`, `{"name": "Bob", "age": 42}`},
+		messages: &calls,
+	}
+
+	var billed, added []string
+	billing := func(_ string, _ string, _ int, _ int, completion string, _ *int) {
+		billed = append(billed, completion)
+	}
+	callback := func(_ string, _ string, _ int, _ int, completion string, _ *int) {
+		added = append(added, completion)
+	}
+
+	messages := []options.Message{{Role: options.RoleUser, Content: "Who?"}}
+	ctx := context.Background()
+
+	for range generateWithJSONSchema(ctx, provider, messages, &billing, &callback, nil, schema, 2) {
+	}
+
+	if len(billed) != 1 || len(added) != 1 || added[0] != `{"name": "Bob", "age": 42}` {
+		t.Fatalf("Only the valid answer should be added, got %v and %v", billed, added)
+	}
+
+	calls, billed, added = nil, nil, nil
+	provider.answers = []string{""}
+
+	var last options.Result
+	results := generateWithJSONSchema(ctx, provider, messages, &billing, &callback, nil, schema, 2)
+	for res := range results {
+		last = res
+	}
+
+	if last.Err != "generation_error" || len(billed) != 1 || len(added) != 0 {
+		t.Fatalf("The failed attempt should only be billed, got %v, %v and %v", last, billed, added)
+	}
+}
@@ -3,58 +3,19 @@ package completion
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	router "github.com/julienschmidt/httprouter"
 	options "github.com/polyfire/api/llm/providers/options"
 	utils "github.com/polyfire/api/utils"
-	webrequest "github.com/polyfire/api/web_request"
 )
 
 func ReturnErrors(w http.ResponseWriter, record utils.RecordFunc, err error) {
-	switch err {
-	case webrequest.ErrWebsiteExceedsLimit:
-		utils.RespondError(w, record, "error_website_exceeds_limit")
-	case webrequest.ErrWebsitesContentExceeds:
-		utils.RespondError(w, record, "error_websites_content_exceeds")
-	case webrequest.ErrFetchWebpage:
-		utils.RespondError(w, record, "error_fetch_webpage")
-	case webrequest.ErrParseContent:
-		utils.RespondError(w, record, "error_parse_content")
-	case webrequest.ErrVisitBaseURL:
-		utils.RespondError(w, record, "error_visit_base_url")
-	case ErrNotFound:
-		utils.RespondError(w, record, "not_found")
-	case ErrUnknownModelProvider:
-		utils.RespondError(w, record, "invalid_model_provider")
-	case ErrRateLimitReached:
-		utils.RespondError(w, record, "rate_limit_reached")
-	case ErrCreditsUsedUp:
-		utils.RespondError(w, record, "credits_used_up")
-	case ErrProjectRateLimitReached:
-		utils.RespondError(w, record, "project_rate_limit_reached")
-	default:
-		utils.RespondError(w, record, "internal_error")
-	}
+	utils.RespondError(w, record, ErrorCode(err))
 }
 
-func Generate(w http.ResponseWriter, r *http.Request, _ router.Params) {
-	userID := r.Context().Value(utils.ContextKeyUserID).(string)
-	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
-
-	var input GenerateRequestBody
-
-	err := json.NewDecoder(r.Body).Decode(&input)
-	if err != nil {
-		utils.RespondError(w, record, "invalid_json")
-		return
-	}
-
-	resChan, err := GenerationStart(r.Context(), userID, input)
-	if err != nil {
-		ReturnErrors(w, record, err)
-		return
-	}
-
+// CollectResult merges the chunks of a generation into a single result.
+func CollectResult(resChan *chan options.Result, input GenerateRequestBody) options.Result {
 	result := options.Result{
 		Result:     "",
 		TokenUsage: options.TokenUsage{Input: 0, Output: 0},
@@ -63,16 +24,41 @@ func Generate(w http.ResponseWriter, r *http.Request, _ router.Params) {
 	inputTokens := 0
 
 	for v := range *resChan {
-		result.Result += v.Result
+		if input.HasCandidates() {
+			result.Candidates = options.MergeCandidate(result.Candidates, v)
+		}
+		if v.Index == 0 {
+			result.Result += v.Result
+		}
 		if inputTokens == 0 && v.TokenUsage.Input > 0 {
 			inputTokens = v.TokenUsage.Input
 			result.TokenUsage.Input = v.TokenUsage.Input
 		}
 		result.TokenUsage.Output += v.TokenUsage.Output
 
-		if len(v.Resources) > 0 || v.Warnings != nil && len(v.Warnings) > 0 {
+		if len(v.Resources) > 0 {
 			result.Resources = v.Resources
-			result.Warnings = v.Warnings
+		}
+
+		if len(v.Warnings) > 0 {
+			result.Warnings = append(result.Warnings, v.Warnings...)
+		}
+
+		if v.Provider != "" {
+			result.Provider = v.Provider
+			result.Model = v.Model
+		}
+
+		if v.ContextBudget != nil {
+			result.ContextBudget = v.ContextBudget
+		}
+
+		if v.Prompt != nil {
+			result.Prompt = v.Prompt
+		}
+
+		if len(v.ToolCalls) > 0 && v.Index == 0 {
+			result.ToolCalls = options.MergeToolCalls(result.ToolCalls, v.ToolCalls)
 		}
 
 		if v.Err != "" {
@@ -80,17 +66,49 @@ func Generate(w http.ResponseWriter, r *http.Request, _ router.Params) {
 		}
 	}
 
-	w.Header()["Content-Type"] = []string{"application/json"}
+	return result
+}
+
+// The events record the version of the prompt that was served, not only the one asked for
+func promptRecordProps(input GenerateRequestBody, result options.Result) []utils.KeyValue {
+	if result.Prompt != nil {
+		return []utils.KeyValue{
+			{Key: "PromptID", Value: result.Prompt.ID},
+			{Key: "PromptVersion", Value: strconv.Itoa(result.Prompt.Version)},
+		}
+	}
 
-	response, _ := result.JSON()
-	recordProps := make([]utils.KeyValue, 0)
 	if input.SystemPromptID != nil {
-		recordProps = append(
-			recordProps,
-			utils.KeyValue{Key: "PromptID", Value: *input.SystemPromptID},
-		)
+		return []utils.KeyValue{{Key: "PromptID", Value: *input.SystemPromptID}}
 	}
-	record(string(response), recordProps...)
+
+	return nil
+}
+
+func Generate(w http.ResponseWriter, r *http.Request, _ router.Params) {
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	var input GenerateRequestBody
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		utils.RespondError(w, record, "invalid_json")
+		return
+	}
+
+	resChan, err := GenerationStart(r.Context(), userID, input)
+	if err != nil {
+		ReturnErrors(w, record, err)
+		return
+	}
+
+	result := CollectResult(resChan, input)
+
+	w.Header()["Content-Type"] = []string{"application/json"}
+
+	response, _ := result.JSON()
+	record(string(response), promptRecordProps(input, result)...)
 
 	_, _ = w.Write(response)
 }
@@ -1,6 +1,7 @@
 package completion
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,7 +11,6 @@ import (
 	router "github.com/julienschmidt/httprouter"
 	options "github.com/polyfire/api/llm/providers/options"
 	utils "github.com/polyfire/api/utils"
-	webrequest "github.com/polyfire/api/web_request"
 )
 
 var upgrader = websocket.Upgrader{
@@ -20,41 +20,37 @@ var upgrader = websocket.Upgrader{
 }
 
 func ReturnErrorsStream(conn *websocket.Conn, record utils.RecordFunc, err error) {
-	switch err {
-	case webrequest.ErrWebsiteExceedsLimit:
-		utils.RespondErrorStream(conn, record, "error_website_exceeds_limit")
-	case webrequest.ErrWebsitesContentExceeds:
-		utils.RespondErrorStream(conn, record, "error_websites_content_exceeds")
-	case webrequest.ErrFetchWebpage:
-		utils.RespondErrorStream(conn, record, "error_fetch_webpage")
-	case webrequest.ErrParseContent:
-		utils.RespondErrorStream(conn, record, "error_parse_content")
-	case webrequest.ErrVisitBaseURL:
-		utils.RespondErrorStream(conn, record, "error_visit_base_url")
-	case ErrNotFound:
-		utils.RespondErrorStream(conn, record, "not_found")
-	case ErrUnknownModelProvider:
-		utils.RespondErrorStream(conn, record, "invalid_model_provider")
-	case ErrRateLimitReached:
-		utils.RespondErrorStream(conn, record, "rate_limit_reached")
-	case ErrCreditsUsedUp:
-		utils.RespondErrorStream(conn, record, "credits_used_up")
-	case ErrProjectRateLimitReached:
-		utils.RespondErrorStream(conn, record, "project_rate_limit_reached")
-	default:
-		utils.RespondErrorStream(conn, record, "internal_error")
-	}
+	utils.RespondErrorStream(conn, record, ErrorCode(err))
 }
 
+// WriteToWebSocketConn sends the results to the client until the channel is closed. When
+// the generation is cancelled we stop writing but continue reading the channel so the
+// provider can end the generation and bill the tokens that have been produced. It's also
+// cancelled and read until the end when the results can't be sent.
 func WriteToWebSocketConn(
+	ctx context.Context,
+	cancel context.CancelFunc,
 	chanRes *chan options.Result,
 	result *options.Result,
 	conn *websocket.Conn,
-	chanStop chan bool,
-) (string, error) {
+	input GenerateRequestBody,
+) (_ string, err error) {
+	defer func() {
+		if err != nil {
+			cancel()
+			for range *chanRes {
+			}
+		}
+	}()
+
 	totalResult := ""
 	for v := range *chanRes {
-		result.Result += v.Result
+		if input.HasCandidates() {
+			result.Candidates = options.MergeCandidate(result.Candidates, v)
+		}
+		if v.Index == 0 {
+			result.Result += v.Result
+		}
 		if v.TokenUsage.Input != 0 {
 			result.TokenUsage.Input = v.TokenUsage.Input
 		}
@@ -63,10 +59,25 @@ func WriteToWebSocketConn(
 		if len(v.Resources) > 0 {
 			result.Resources = v.Resources
 		}
-		select {
-		case <-chanStop:
-			break
-		default:
+
+		if v.Provider != "" {
+			result.Provider = v.Provider
+			result.Model = v.Model
+		}
+
+		if v.ContextBudget != nil {
+			result.ContextBudget = v.ContextBudget
+		}
+
+		if v.Prompt != nil {
+			result.Prompt = v.Prompt
+		}
+
+		if ctx.Err() != nil {
+			if v.Index == 0 {
+				totalResult += v.Result
+			}
+			continue
 		}
 
 		if v.Err != "" {
@@ -77,9 +88,38 @@ func WriteToWebSocketConn(
 			result.Warnings = append(result.Warnings, v.Warnings...)
 		}
 
-		totalResult += v.Result
+		// When several candidates have been asked for, each chunk is prefixed by the index
+		// of the candidate it is part of
+		prefix := ""
+		if input.N > 1 {
+			prefix = fmt.Sprintf("[CANDIDATE:%d]:", v.Index)
+		}
+
+		// Tool call deltas are sent as they come, prefixed like the infos message
+		if len(v.ToolCalls) > 0 {
+			if v.Index == 0 {
+				result.ToolCalls = options.MergeToolCalls(result.ToolCalls, v.ToolCalls)
+			}
+			for _, toolCall := range v.ToolCalls {
+				toolCallJSON, err := json.Marshal(toolCall)
+				if err != nil {
+					return "", errors.New("invalid_json")
+				}
+				err = conn.WriteMessage(
+					websocket.TextMessage,
+					[]byte(prefix+"[TOOL_CALL]:"+string(toolCallJSON)),
+				)
+				if err != nil {
+					return "", errors.New("write_result_error")
+				}
+			}
+		}
+
+		if v.Index == 0 {
+			totalResult += v.Result
+		}
 		if v.Result != "" {
-			err := conn.WriteMessage(websocket.TextMessage, []byte(v.Result))
+			err := conn.WriteMessage(websocket.TextMessage, []byte(prefix+v.Result))
 			if err != nil {
 				return "", errors.New("write_result_error")
 			}
@@ -123,7 +163,10 @@ func Stream(w http.ResponseWriter, r *http.Request, _ router.Params) {
 		return
 	}
 
-	chanRes, err := GenerationStart(r.Context(), userID, input)
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	chanRes, err := GenerationStart(ctx, userID, input)
 	if err != nil {
 		fmt.Println(err)
 		ReturnErrorsStream(conn, record, err)
@@ -135,20 +178,25 @@ func Stream(w http.ResponseWriter, r *http.Request, _ router.Params) {
 		TokenUsage: options.TokenUsage{Input: 0, Output: 0},
 	}
 
-	chanStop := make(chan bool)
+	// The generation is cancelled when the client sends STOP or disconnects
 	go func() {
 		for {
-			size, message, _ := conn.ReadMessage()
-			if string(message) == "STOP" {
-				chanStop <- true
-			}
-			if size == -1 {
-				break
+			_, message, err := conn.ReadMessage()
+			if err != nil || string(message) == "STOP" {
+				cancel()
+				return
 			}
 		}
 	}()
 
-	totalResult, err := WriteToWebSocketConn(chanRes, &result, conn, chanStop)
+	totalResult, err := WriteToWebSocketConn(
+		ctx,
+		cancel,
+		chanRes,
+		&result,
+		conn,
+		input,
+	)
 	if err != nil {
 		utils.RespondErrorStream(conn, record, err.Error())
 		return
@@ -171,14 +219,7 @@ func Stream(w http.ResponseWriter, r *http.Request, _ router.Params) {
 		}
 	}
 
-	var recordProps []utils.KeyValue = make([]utils.KeyValue, 0)
-	if input.SystemPromptID != nil {
-		recordProps = append(
-			recordProps,
-			utils.KeyValue{Key: "PromptID", Value: *input.SystemPromptID},
-		)
-	}
-	record(totalResult, recordProps...)
+	record(totalResult, promptRecordProps(input, result)...)
 
 	err = conn.WriteMessage(websocket.TextMessage, []byte(""))
 	if err != nil {
@@ -0,0 +1,60 @@
+package completion
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/polyfire/api/llm/providers/options"
+)
+
+func TestWriteToClosedWebSocketConn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	billed := false
+	chanRes := make(chan options.Result)
+	go func() {
+		defer close(chanRes)
+		for i := 0; i < 3 && ctx.Err() == nil; i++ {
+			chanRes <- options.Result{Result: "chunk"}
+		}
+		billed = true
+	}()
+
+	errs := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		// The client is gone, the first write fails
+		_ = conn.UnderlyingConn().Close()
+
+		var result options.Result
+		_, err = WriteToWebSocketConn(ctx, cancel, &chanRes, &result, conn, GenerateRequestBody{})
+		errs <- err
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := <-errs; err == nil || err.Error() != "write_result_error" {
+		t.Fatalf("The write should fail, got %v", err)
+	}
+	if !billed {
+		t.Fatal("The generation should end and be billed after the write failed")
+	}
+	if ctx.Err() == nil {
+		t.Fatal("The generation should be cancelled")
+	}
+}
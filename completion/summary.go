@@ -0,0 +1,56 @@
+package completion
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	completionContext "github.com/polyfire/api/completion/context"
+	"github.com/polyfire/api/llm"
+	"github.com/polyfire/api/llm/providers/options"
+)
+
+// The length of the summaries of the chat histories
+const ChatSummaryMaxTokens = 512
+
+var ErrEmptySummary = errors.New("The model returned an empty summary")
+
+/*
+ * chatSummarizer summarizes the chat histories with the model of the generation. The
+ * usage goes through the callback, so it is counted like the generation itself.
+ */
+func chatSummarizer(
+	ctx context.Context,
+	model string,
+	callback options.ProviderCallback,
+) completionContext.ChatSummarizer {
+	return func(messages []options.Message) (string, error) {
+		provider, err := llm.NewProvider(ctx, model)
+		if err != nil {
+			return "", err
+		}
+
+		maxTokens := ChatSummaryMaxTokens
+		opts := options.ProviderOptions{MaxTokens: &maxTokens}
+
+		// The channel is read until the end after an error so the provider can bill it
+		var summary strings.Builder
+		generationError := ""
+		for res := range provider.Generate(ctx, messages, callback, &opts) {
+			if res.Err != "" && generationError == "" {
+				generationError = res.Err
+			}
+			summary.WriteString(res.Result)
+		}
+
+		if generationError != "" {
+			return "", errors.New(generationError)
+		}
+
+		if strings.TrimSpace(summary.String()) == "" {
+			return "", ErrEmptySummary
+		}
+
+		return strings.TrimSpace(summary.String()), nil
+	}
+}
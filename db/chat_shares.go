@@ -0,0 +1,116 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+/*
+ * ChatShare gives a read-only access to the active branch of a chat to anyone with its
+ * token. The user is the one of the chat, the shared chat is only read with both.
+ */
+type ChatShare struct {
+	ID        string     `json:"id"`
+	ChatID    string     `json:"chat_id"`
+	UserID    string     `json:"user_id"`
+	Token     string     `json:"token"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+func (ChatShare) TableName() string {
+	return "chat_shares"
+}
+
+func generateShareToken() (string, error) {
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// CreateChatShare returns nil when the chat isn't one of the user
+func (db DB) CreateChatShare(
+	userID string,
+	chatID string,
+	expiresAt *time.Time,
+) (*ChatShare, error) {
+	var result *ChatShare
+
+	token, err := generateShareToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.sql.Raw(
+		"INSERT INTO chat_shares (chat_id, user_id, token, expires_at) SELECT id, user_id, ?, ? FROM chats WHERE id = try_cast_uuid(?) AND user_id = ?::uuid RETURNING *",
+		token,
+		expiresAt,
+		chatID,
+		userID,
+	).Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (db DB) ListChatShares(userID string, chatID string) ([]ChatShare, error) {
+	var results []ChatShare
+
+	err := db.sql.
+		Order("created_at DESC").
+		Find(&results, "chat_id = try_cast_uuid(?) AND user_id = ?::uuid", chatID, userID).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// RevokeChatShare returns gorm.ErrRecordNotFound when there's no active share to revoke
+func (db DB) RevokeChatShare(userID string, chatID string, id string) error {
+	result := db.sql.Exec(
+		"UPDATE chat_shares SET revoked_at = now() WHERE id = try_cast_uuid(?) AND chat_id = try_cast_uuid(?) AND user_id = ?::uuid AND revoked_at IS NULL",
+		id,
+		chatID,
+		userID,
+	)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetChatShareByToken returns nil when the share doesn't exist, is revoked or has expired
+func (db DB) GetChatShareByToken(token string) (*ChatShare, error) {
+	var results []ChatShare
+
+	err := db.sql.
+		Where("token = ? AND revoked_at IS NULL", token).
+		Where("expires_at IS NULL OR expires_at > now()").
+		Limit(1).
+		Find(&results).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	return &results[0], nil
+}
@@ -2,15 +2,23 @@ package db
 
 import (
 	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
 type Chat struct {
-	ID             string        `json:"id,omitempty"`
-	UserID         string        `json:"user_id"`
-	SystemPrompt   *string       `json:"system_prompt"`
-	SystemPromptID *string       `json:"system_prompt_id"`
-	ChatMessages   []ChatMessage `json:"chat_messages,omitempty"`
-	Name           *string       `json:"name"`
+	ID             string  `json:"id,omitempty"`
+	UserID         string  `json:"user_id"`
+	SystemPrompt   *string `json:"system_prompt"`
+	SystemPromptID *string `json:"system_prompt_id"`
+	// Unset when the chat follows the latest version of its prompt
+	SystemPromptVersion *int          `json:"system_prompt_version"`
+	ChatMessages        []ChatMessage `json:"chat_messages,omitempty"`
+	Name                *string       `json:"name"`
+	// The last message of the active branch, the one the next messages are added to
+	ActiveMessageID *string   `json:"active_message_id"`
+	CreatedAt       time.Time `json:"created_at"`
 }
 
 type ChatWithLatestMessage struct {
@@ -42,11 +50,12 @@ func (db DB) CreateChat(
 	userID string,
 	systemPrompt *string,
 	SystemPromptID *string,
+	systemPromptVersion *int,
 	name *string,
 ) (*Chat, error) {
 	var result *Chat
 
-	err := db.sql.Raw("INSERT INTO chats (user_id, system_prompt, system_prompt_id, name) VALUES (?::uuid, ?, ?, ?) RETURNING *", userID, systemPrompt, SystemPromptID, name).
+	err := db.sql.Raw("INSERT INTO chats (user_id, system_prompt, system_prompt_id, system_prompt_version, name) VALUES (?::uuid, ?, ?, ?, ?) RETURNING *", userID, systemPrompt, SystemPromptID, systemPromptVersion, name).
 		Scan(&result).
 		Error
 	if err != nil {
@@ -62,13 +71,7 @@ func (db DB) ListChats(userID string) ([]ChatWithLatestMessage, error) {
 	err := db.sql.Raw(`
 	SELECT c.*, cm.content AS latest_message_content, cm.created_at AS latest_message_created_at
 	FROM chats c
-	LEFT JOIN LATERAL (
-		SELECT cm.content, cm.created_at
-		FROM chat_messages cm
-		WHERE cm.chat_id = c.id
-		ORDER BY cm.created_at DESC
-		LIMIT 1
-	) cm ON true
+	LEFT JOIN chat_messages cm ON cm.id = c.active_message_id
 	WHERE c.user_id = ?
 	`, userID).Scan(&result).Error
 	if err != nil {
@@ -97,18 +100,33 @@ func (db DB) UpdateChat(userID string, id string, name string) (*Chat, error) {
 	return result, err
 }
 
+/*
+ * The messages of a chat form a tree: editing a user message or regenerating an answer
+ * adds a sibling to it, starting a new branch. The chat follows the branch ending with its
+ * active message.
+ */
 type ChatMessage struct {
 	ID            *string `json:"id"`
 	ChatID        string  `json:"chat_id"`
+	ParentID      *string `json:"parent_id"`
 	IsUserMessage bool    `json:"is_user_message"`
 	Content       string  `json:"content"`
 	CreatedAt     string  `json:"created_at"`
+
+	Images datatypes.JSONSlice[string] `json:"images,omitempty"`
+
+	// The summary of the branch up to this message, the history of the chat starts after it
+	Summary *string `json:"summary,omitempty"`
+
+	// The ids of the messages sharing its parent, itself included, from the oldest
+	SiblingIDs datatypes.JSONSlice[string] `json:"sibling_ids,omitempty" gorm:"->"`
 }
 
 func (ChatMessage) TableName() string {
 	return "chat_messages"
 }
 
+// GetChatMessages returns the messages of the active branch of the chat
 func (db DB) GetChatMessages(
 	userID string,
 	chatID string,
@@ -118,20 +136,32 @@ func (db DB) GetChatMessages(
 ) ([]ChatMessage, error) {
 	var results []ChatMessage
 
-	query := db.sql.
-		Select("chat_messages.*").
-		Joins("JOIN chats ON chats.id = chat_messages.chat_id").
-		Where("chats.id = ? AND chats.user_id = ?", chatID, userID)
-
+	// The depth is 0 for the active message and grows up to the first message
+	order := "depth DESC"
 	if orderByDESC {
-		query = query.Order("chat_messages.created_at DESC")
-	} else {
-		query = query.Order("chat_messages.created_at ASC")
+		order = "depth ASC"
 	}
 
-	query = query.Limit(limit).Offset(offset)
-
-	err := query.Find(&results).Error
+	err := db.sql.Raw(`
+	WITH RECURSIVE branch AS (
+		SELECT cm.*, 0 AS depth
+		FROM chat_messages cm
+		JOIN chats c ON c.active_message_id = cm.id
+		WHERE c.id = ? AND c.user_id = ?
+		UNION ALL
+		SELECT cm.*, b.depth + 1
+		FROM chat_messages cm
+		JOIN branch b ON cm.id = b.parent_id
+	)
+	SELECT b.*, (
+		SELECT jsonb_agg(s.id ORDER BY s.created_at)
+		FROM chat_messages s
+		WHERE s.chat_id = b.chat_id AND s.parent_id IS NOT DISTINCT FROM b.parent_id
+	) AS sibling_ids
+	FROM branch b
+	ORDER BY `+order+`
+	LIMIT ? OFFSET ?
+	`, chatID, userID, limit, offset).Scan(&results).Error
 	if err != nil {
 		return nil, err
 	}
@@ -139,12 +169,40 @@ func (db DB) GetChatMessages(
 	return results, nil
 }
 
-func (db DB) AddChatMessage(chatID string, isUserMessage bool, content string) error {
-	err := db.sql.Exec(
-		"INSERT INTO chat_messages (chat_id, is_user_message, content) VALUES (?, ?, ?)",
-		chatID,
+func (db DB) GetChatMessage(chatID string, id string) (*ChatMessage, error) {
+	var result ChatMessage
+
+	err := db.sql.First(&result, "id = try_cast_uuid(?) AND chat_id = ?", id, chatID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// AddChatMessage appends the message to the active branch and makes it the active message
+func (db DB) AddChatMessage(
+	chatID string,
+	isUserMessage bool,
+	content string,
+	images []string,
+) error {
+	if images == nil {
+		images = []string{}
+	}
+
+	err := db.sql.Exec(`
+	WITH message AS (
+		INSERT INTO chat_messages (chat_id, parent_id, is_user_message, content, images)
+		SELECT id, active_message_id, ?, ?, ? FROM chats WHERE id = ?
+		RETURNING id, chat_id
+	)
+	UPDATE chats SET active_message_id = message.id FROM message WHERE chats.id = message.chat_id
+	`,
 		isUserMessage,
 		content,
+		datatypes.NewJSONSlice(images),
+		chatID,
 	).Error
 	if err != nil {
 		return err
@@ -152,3 +210,142 @@ func (db DB) AddChatMessage(chatID string, isUserMessage bool, content string) e
 
 	return nil
 }
+
+func (db DB) SetChatMessageSummary(id string, summary string) error {
+	return db.sql.Exec("UPDATE chat_messages SET summary = ? WHERE id = ?", summary, id).Error
+}
+
+// SetChatActiveMessage moves the end of the active branch, nil empties it
+func (db DB) SetChatActiveMessage(chatID string, messageID *string) error {
+	return db.sql.Exec(
+		"UPDATE chats SET active_message_id = ?::uuid WHERE id = ?",
+		messageID,
+		chatID,
+	).Error
+}
+
+/*
+ * SwitchChatBranch makes the branch going through the message the active one. The branch
+ * continues from the message with its most recent replies.
+ */
+func (db DB) SwitchChatBranch(userID string, chatID string, messageID string) (*Chat, error) {
+	var result *Chat
+
+	err := db.sql.Raw(`
+	WITH RECURSIVE descendants AS (
+		SELECT id, 0 AS depth
+		FROM chat_messages
+		WHERE id = try_cast_uuid(?) AND chat_id = ?
+		UNION ALL
+		SELECT child.id, d.depth + 1
+		FROM descendants d
+		CROSS JOIN LATERAL (
+			SELECT id FROM chat_messages WHERE parent_id = d.id ORDER BY created_at DESC LIMIT 1
+		) child
+	)
+	UPDATE chats SET active_message_id = (SELECT id FROM descendants ORDER BY depth DESC LIMIT 1)
+	WHERE id = ? AND user_id = ? AND EXISTS (SELECT 1 FROM descendants)
+	RETURNING *
+	`, messageID, chatID, chatID, userID).Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetAllChatMessages returns the messages of every branch of the chat, from the oldest
+func (db DB) GetAllChatMessages(userID string, chatID string) ([]ChatMessage, error) {
+	var results []ChatMessage
+
+	err := db.sql.
+		Select("chat_messages.*").
+		Joins("JOIN chats ON chats.id = chat_messages.chat_id").
+		Where("chats.id = ? AND chats.user_id = ?", chatID, userID).
+		Order("chat_messages.created_at ASC").
+		Find(&results).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+type ChatMessageImport struct {
+	Parent        int // The index of the parent in the imported messages, -1 for none
+	IsUserMessage bool
+	Content       string
+	Images        []string
+	CreatedAt     *time.Time // The time of the insertion when unset
+}
+
+/*
+ * ImportChat creates the chat with its messages, whose parents must come before them. The
+ * message at activeMessage, if any, becomes the end of the active branch.
+ */
+func (db DB) ImportChat(
+	chat Chat,
+	messages []ChatMessageImport,
+	activeMessage int,
+) (*Chat, error) {
+	var result *Chat
+
+	err := db.sql.Transaction(func(tx *gorm.DB) error {
+		err := tx.Raw(
+			"INSERT INTO chats (user_id, system_prompt, system_prompt_id, system_prompt_version, name) VALUES (?::uuid, ?, ?, ?, ?) RETURNING *",
+			chat.UserID,
+			chat.SystemPrompt,
+			chat.SystemPromptID,
+			chat.SystemPromptVersion,
+			chat.Name,
+		).Scan(&result).Error
+		if err != nil {
+			return err
+		}
+
+		ids := make([]*string, len(messages))
+		for i, message := range messages {
+			var parentID *string
+			if message.Parent >= 0 {
+				parentID = ids[message.Parent]
+			}
+
+			images := message.Images
+			if images == nil {
+				images = []string{}
+			}
+
+			var id string
+			err := tx.Raw(
+				"INSERT INTO chat_messages (chat_id, parent_id, is_user_message, content, images, created_at) VALUES (?, ?::uuid, ?, ?, ?, COALESCE(?, clock_timestamp())) RETURNING id",
+				result.ID,
+				parentID,
+				message.IsUserMessage,
+				message.Content,
+				datatypes.NewJSONSlice(images),
+				message.CreatedAt,
+			).Scan(&id).Error
+			if err != nil {
+				return err
+			}
+			ids[i] = &id
+		}
+
+		if activeMessage < 0 || activeMessage >= len(ids) {
+			return nil
+		}
+
+		result.ActiveMessageID = ids[activeMessage]
+		return tx.Exec(
+			"UPDATE chats SET active_message_id = ? WHERE id = ?",
+			result.ActiveMessageID,
+			result.ID,
+		).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
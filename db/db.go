@@ -3,7 +3,9 @@ package db
 import (
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/polyfire/api/registry"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -68,6 +70,7 @@ type Database interface {
 		responseBody string,
 		error bool,
 		promptID string,
+		promptVersion int,
 		eventType string,
 		orginDomain string,
 	)
@@ -78,11 +81,31 @@ type Database interface {
 	ListKV(userID string) ([]KVStore, error)
 	GetPromptByIDOrSlug(id string) (*Prompt, error)
 	RetrieveSystemPromptID(systemPromptIDOrSlug *string) (*string, error)
+	CreatePrompt(userID string, projectID string, prompt PromptInsert) (*Prompt, error)
+	UpdatePrompt(userID string, id string, update PromptUpdate) (*Prompt, error)
+	DeletePrompt(userID string, id string) error
+	ListPrompts(userID string) ([]Prompt, error)
+	GetPromptVersion(promptID string, version int) (*PromptVersion, error)
+	ListPromptVersions(promptID string) ([]PromptVersion, error)
+	CreatePromptExperiment(
+		promptID string,
+		projectID *string,
+		name string,
+		variants []PromptExperimentVariant,
+	) (*PromptExperiment, error)
+	ListPromptExperiments(promptID string) ([]PromptExperiment, error)
+	StopPromptExperiment(promptID string, id string) error
+	GetActivePromptExperiment(promptID string, projectID string) (*PromptExperiment, error)
+	ListPublicPrompts(userID string, filters PublicPromptFilters) ([]PromptWithLikes, error)
+	LikePrompt(userID string, promptID string) error
+	UnlikePrompt(userID string, promptID string) error
+	GetPromptLikes(userID string, promptID string) (int, bool, error)
 	GetChatByID(id string) (*Chat, error)
 	CreateChat(
 		userID string,
 		systemPrompt *string,
 		SystemPromptID *string,
+		systemPromptVersion *int,
 		name *string,
 	) (*Chat, error)
 	ListChats(userID string) ([]ChatWithLatestMessage, error)
@@ -95,8 +118,24 @@ type Database interface {
 		limit int,
 		offset int,
 	) ([]ChatMessage, error)
-	AddChatMessage(chatID string, isUserMessage bool, content string) error
-	CreateMemory(memoryID string, userID string, public bool) error
+	AddChatMessage(chatID string, isUserMessage bool, content string, images []string) error
+	GetChatMessage(chatID string, id string) (*ChatMessage, error)
+	SetChatActiveMessage(chatID string, messageID *string) error
+	SetChatMessageSummary(id string, summary string) error
+	SwitchChatBranch(userID string, chatID string, messageID string) (*Chat, error)
+	GetAllChatMessages(userID string, chatID string) ([]ChatMessage, error)
+	ImportChat(chat Chat, messages []ChatMessageImport, activeMessage int) (*Chat, error)
+	CreateChatShare(userID string, chatID string, expiresAt *time.Time) (*ChatShare, error)
+	ListChatShares(userID string, chatID string) ([]ChatShare, error)
+	RevokeChatShare(userID string, chatID string, id string) error
+	GetChatShareByToken(token string) (*ChatShare, error)
+	CreateMemory(
+		memoryID string,
+		userID string,
+		public bool,
+		embeddingModel string,
+		embeddingDimension int,
+	) error
 	GetMemory(memoryID string) (*Memory, error)
 	AddMemory(userID string, memoryID string, content string, embedding []float32) error
 	AddMemories(memoryID string, embeddings []Embedding) error
@@ -106,7 +145,20 @@ type Database interface {
 	GetProjectByID(id string) (*Project, error)
 	GetProjectUserByID(id string) (*ProjectUser, error)
 	GetProjectForUserID(userID string) (*string, error)
-	GetModelByAliasAndProjectID(alias string, projectID string, modelType string) (*Model, error)
+	GetModelsByAliasAndProjectID(alias string, projectID string, modelType string) ([]Model, error)
+	GetRegistryModels() ([]registry.Model, error)
+	CreateGenerationJob(
+		userID string,
+		input []byte,
+		webhookURL *string,
+		webhookSecret *string,
+		eventID string,
+		tokenVersion int,
+	) (*GenerationJob, error)
+	GetGenerationJob(userID string, id string) (*GenerationJob, error)
+	ClaimGenerationJob(id string, claimedBy string, lease time.Duration) (*GenerationJob, error)
+	CompleteGenerationJob(id string, status GenerationJobStatus, result []byte) error
+	GetUnfinishedGenerationJobs() ([]GenerationJob, error)
 }
 
 type DB struct {
@@ -114,7 +166,10 @@ type DB struct {
 }
 
 func InitDB() DB {
-	db, err := gorm.Open(postgres.Open(os.Getenv("POSTGRES_URI")), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(os.Getenv("POSTGRES_URI")), &gorm.Config{
+		// Makes the unique violations return gorm.ErrDuplicatedKey
+		TranslateError: true,
+	})
 	if err != nil {
 		fmt.Println("POSTGRES_URI: ", os.Getenv("POSTGRES_URI"))
 		panic("POSTGRES_URI: " + os.Getenv("POSTGRES_URI"))
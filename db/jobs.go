@@ -0,0 +1,139 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+type GenerationJobStatus string
+
+const (
+	GenerationJobPending   = GenerationJobStatus("pending")
+	GenerationJobRunning   = GenerationJobStatus("running")
+	GenerationJobSucceeded = GenerationJobStatus("succeeded")
+	GenerationJobFailed    = GenerationJobStatus("failed")
+)
+
+type GenerationJob struct {
+	ID     string              `json:"id"`
+	UserID string              `json:"user_id"`
+	Status GenerationJobStatus `json:"status"`
+	Input  datatypes.JSON      `json:"-"`
+	Result datatypes.JSON      `json:"result"`
+
+	WebhookURL    *string `json:"webhook_url"`
+	WebhookSecret *string `json:"-"`
+
+	// Needed to resume the job after a restart
+	EventID      *string `json:"-"`
+	TokenVersion int     `json:"-"`
+
+	// The server running the job
+	ClaimedBy  *string    `json:"-"`
+	LeaseUntil *time.Time `json:"-"`
+
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+func (GenerationJob) TableName() string {
+	return "generation_jobs"
+}
+
+func (db DB) CreateGenerationJob(
+	userID string,
+	input []byte,
+	webhookURL *string,
+	webhookSecret *string,
+	eventID string,
+	tokenVersion int,
+) (*GenerationJob, error) {
+	var result *GenerationJob
+
+	err := db.sql.Raw(
+		"INSERT INTO generation_jobs (user_id, input, webhook_url, webhook_secret, event_id, token_version) VALUES (?::uuid, ?::jsonb, ?, ?, ?, ?) RETURNING *",
+		userID,
+		string(input),
+		webhookURL,
+		webhookSecret,
+		eventID,
+		tokenVersion,
+	).Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (db DB) GetGenerationJob(userID string, id string) (*GenerationJob, error) {
+	var result GenerationJob
+
+	err := db.sql.First(&result, "id = try_cast_uuid(?) AND user_id = ?::uuid", id, userID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+/*
+ * ClaimGenerationJob marks a job as running on a server until the end of the lease. A job
+ * can only be claimed if it's pending, if it's already claimed by the same server or if the
+ * server that claimed it has stopped without finishing it. Otherwise it returns nil.
+ */
+func (db DB) ClaimGenerationJob(
+	id string,
+	claimedBy string,
+	lease time.Duration,
+) (*GenerationJob, error) {
+	var result *GenerationJob
+
+	err := db.sql.Raw(
+		`UPDATE generation_jobs
+		SET status = ?, claimed_by = ?, lease_until = now() + make_interval(secs => ?)
+		WHERE id = ?::uuid AND (
+			status = ?
+			OR (status = ? AND (claimed_by = ? OR lease_until IS NULL OR lease_until < now()))
+		)
+		RETURNING *`,
+		GenerationJobRunning,
+		claimedBy,
+		lease.Seconds(),
+		id,
+		GenerationJobPending,
+		GenerationJobRunning,
+		claimedBy,
+	).Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (db DB) CompleteGenerationJob(id string, status GenerationJobStatus, result []byte) error {
+	return db.sql.Exec(
+		"UPDATE generation_jobs SET status = ?, result = ?::jsonb, completed_at = now() WHERE id = ?::uuid",
+		status,
+		string(result),
+		id,
+	).Error
+}
+
+// GetUnfinishedGenerationJobs returns the jobs that were pending or running when the
+// server stopped.
+func (db DB) GetUnfinishedGenerationJobs() ([]GenerationJob, error) {
+	var results []GenerationJob
+
+	err := db.sql.Find(&results, "status IN ?", []GenerationJobStatus{
+		GenerationJobPending,
+		GenerationJobRunning,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -11,9 +11,11 @@ import (
 )
 
 type Memory struct {
-	ID     string `json:"id"`
-	UserID string `json:"user_id"`
-	Public bool   `json:"public"`
+	ID                 string `json:"id"`
+	UserID             string `json:"user_id"`
+	Public             bool   `json:"public"`
+	EmbeddingModel     string `json:"embedding_model"`
+	EmbeddingDimension int    `json:"embedding_dimension"`
 }
 
 type MatchParams struct {
@@ -66,12 +68,20 @@ type Embedding struct {
 	Embedding FloatArray      `json:"embedding"`
 }
 
-func (db DB) CreateMemory(memoryID string, userID string, public bool) error {
+func (db DB) CreateMemory(
+	memoryID string,
+	userID string,
+	public bool,
+	embeddingModel string,
+	embeddingDimension int,
+) error {
 	err := db.sql.Exec(
-		"INSERT INTO memories (id, user_id, public) VALUES (?, ?::uuid, ?)",
+		"INSERT INTO memories (id, user_id, public, embedding_model, embedding_dimension) VALUES (?, ?::uuid, ?, ?, ?)",
 		memoryID,
 		userID,
 		public,
+		embeddingModel,
+		embeddingDimension,
 	).Error
 	if err != nil {
 		return err
@@ -1,5 +1,11 @@
 package db
 
+import (
+	"time"
+
+	"github.com/polyfire/api/registry"
+)
+
 type MockDatabase struct {
 	MockgetUserInfos                    func(userID string) (*UserInfos, error)
 	MockCheckDBVersionRateLimit         func(userID string, version int) (*UserInfos, RateLimitStatus, CreditsStatus, error)
@@ -16,7 +22,7 @@ type MockDatabase struct {
 	MockGetExactCompletionCacheByHash   func(provider string, model string, input string) (*CompletionCache, error)
 	MockLogRequests                     func(eventID string, userID string, providerName string, modelName string, inputTokenCount int, outputTokenCount int, kind Kind, countCredits bool)
 	MockLogRequestsCredits              func(eventID string, userID string, modelName string, credits int, inputTokenCount int, outputTokenCount int, kind Kind)
-	MockLogEvents                       func(id string, path string, userID string, projectID string, requestBody string, responseBody string, error bool, promptID string, eventType string, orginDomain string)
+	MockLogEvents                       func(id string, path string, userID string, projectID string, requestBody string, responseBody string, error bool, promptID string, promptVersion int, eventType string, orginDomain string)
 	MockSetKV                           func(userID, key, value string) error
 	MockGetKV                           func(userID, key string) (*KVStore, error)
 	MockGetKVMap                        func(userID string, keys []string) (map[string]string, error)
@@ -24,13 +30,37 @@ type MockDatabase struct {
 	MockListKV                          func(userID string) ([]KVStore, error)
 	MockGetPromptByIDOrSlug             func(id string) (*Prompt, error)
 	MockRetrieveSystemPromptID          func(systemPromptIDOrSlug *string) (*string, error)
+	MockCreatePrompt                    func(userID string, projectID string, prompt PromptInsert) (*Prompt, error)
+	MockUpdatePrompt                    func(userID string, id string, update PromptUpdate) (*Prompt, error)
+	MockDeletePrompt                    func(userID string, id string) error
+	MockListPrompts                     func(userID string) ([]Prompt, error)
+	MockGetPromptVersion                func(promptID string, version int) (*PromptVersion, error)
+	MockListPromptVersions              func(promptID string) ([]PromptVersion, error)
+	MockCreatePromptExperiment          func(promptID string, projectID *string, name string, variants []PromptExperimentVariant) (*PromptExperiment, error)
+	MockListPromptExperiments           func(promptID string) ([]PromptExperiment, error)
+	MockStopPromptExperiment            func(promptID string, id string) error
+	MockGetActivePromptExperiment       func(promptID string, projectID string) (*PromptExperiment, error)
+	MockListPublicPrompts               func(userID string, filters PublicPromptFilters) ([]PromptWithLikes, error)
+	MockLikePrompt                      func(userID string, promptID string) error
+	MockUnlikePrompt                    func(userID string, promptID string) error
+	MockGetPromptLikes                  func(userID string, promptID string) (int, bool, error)
 	MockGetChatByID                     func(id string) (*Chat, error)
-	MockCreateChat                      func(userID string, systemPrompt *string, SystemPromptID *string, name *string) (*Chat, error)
+	MockCreateChat                      func(userID string, systemPrompt *string, SystemPromptID *string, systemPromptVersion *int, name *string) (*Chat, error)
 	MockListChats                       func(userID string) ([]ChatWithLatestMessage, error)
 	MockDeleteChat                      func(userID string, id string) error
 	MockUpdateChat                      func(userID string, id string, name string) (*Chat, error)
 	MockGetChatMessages                 func(userID string, chatID string, orderByDESC bool, limit int, offset int) ([]ChatMessage, error)
-	MockAddChatMessage                  func(chatID string, isUserMessage bool, content string) error
+	MockAddChatMessage                  func(chatID string, isUserMessage bool, content string, images []string) error
+	MockGetChatMessage                  func(chatID string, id string) (*ChatMessage, error)
+	MockSetChatActiveMessage            func(chatID string, messageID *string) error
+	MockSetChatMessageSummary           func(id string, summary string) error
+	MockSwitchChatBranch                func(userID string, chatID string, messageID string) (*Chat, error)
+	MockGetAllChatMessages              func(userID string, chatID string) ([]ChatMessage, error)
+	MockImportChat                      func(chat Chat, messages []ChatMessageImport, activeMessage int) (*Chat, error)
+	MockCreateChatShare                 func(userID string, chatID string, expiresAt *time.Time) (*ChatShare, error)
+	MockListChatShares                  func(userID string, chatID string) ([]ChatShare, error)
+	MockRevokeChatShare                 func(userID string, chatID string, id string) error
+	MockGetChatShareByToken             func(token string) (*ChatShare, error)
 	MockCreateMemory                    func(memoryID string, userID string, public bool) error
 	MockGetMemory                       func(memoryID string) (*Memory, error)
 	MockAddMemory                       func(userID string, memoryID string, content string, embedding []float32) error
@@ -41,22 +71,105 @@ type MockDatabase struct {
 	MockGetProjectByID                  func(id string) (*Project, error)
 	MockGetProjectUserByID              func(id string) (*ProjectUser, error)
 	MockGetProjectForUserID             func(userID string) (*string, error)
-	MockGetModelByAliasAndProjectID     func(alias string, projectID string, modelType string) (*Model, error)
+	MockGetModelsByAliasAndProjectID    func(alias string, projectID string, modelType string) ([]Model, error)
+	MockGetRegistryModels               func() ([]registry.Model, error)
+	MockCreateGenerationJob             func(userID string, input []byte, webhookURL *string, webhookSecret *string, eventID string, tokenVersion int) (*GenerationJob, error)
+	MockGetGenerationJob                func(userID string, id string) (*GenerationJob, error)
+	MockClaimGenerationJob              func(id string, claimedBy string, lease time.Duration) (*GenerationJob, error)
+	MockCompleteGenerationJob           func(id string, status GenerationJobStatus, result []byte) error
+	MockGetUnfinishedGenerationJobs     func() ([]GenerationJob, error)
+}
+
+func (mdb MockDatabase) CreateGenerationJob(
+	userID string,
+	input []byte,
+	webhookURL *string,
+	webhookSecret *string,
+	eventID string,
+	tokenVersion int,
+) (*GenerationJob, error) {
+	if mdb.MockCreateGenerationJob != nil {
+		return mdb.MockCreateGenerationJob(
+			userID,
+			input,
+			webhookURL,
+			webhookSecret,
+			eventID,
+			tokenVersion,
+		)
+	}
+	panic("Mock CreateGenerationJob Unimplemented")
+}
+
+func (mdb MockDatabase) GetGenerationJob(userID string, id string) (*GenerationJob, error) {
+	if mdb.MockGetGenerationJob != nil {
+		return mdb.MockGetGenerationJob(userID, id)
+	}
+	panic("Mock GetGenerationJob Unimplemented")
 }
 
-func (mdb MockDatabase) GetModelByAliasAndProjectID(_ string, _ string, _ string) (*Model, error) {
-	panic("Mock GetModelByAliasAndProjectID Unimplemented")
+func (mdb MockDatabase) ClaimGenerationJob(
+	id string,
+	claimedBy string,
+	lease time.Duration,
+) (*GenerationJob, error) {
+	if mdb.MockClaimGenerationJob != nil {
+		return mdb.MockClaimGenerationJob(id, claimedBy, lease)
+	}
+	panic("Mock ClaimGenerationJob Unimplemented")
+}
+
+func (mdb MockDatabase) CompleteGenerationJob(
+	id string,
+	status GenerationJobStatus,
+	result []byte,
+) error {
+	if mdb.MockCompleteGenerationJob != nil {
+		return mdb.MockCompleteGenerationJob(id, status, result)
+	}
+	panic("Mock CompleteGenerationJob Unimplemented")
+}
+
+func (mdb MockDatabase) GetUnfinishedGenerationJobs() ([]GenerationJob, error) {
+	if mdb.MockGetUnfinishedGenerationJobs != nil {
+		return mdb.MockGetUnfinishedGenerationJobs()
+	}
+	panic("Mock GetUnfinishedGenerationJobs Unimplemented")
+}
+
+func (mdb MockDatabase) GetRegistryModels() ([]registry.Model, error) {
+	if mdb.MockGetRegistryModels != nil {
+		return mdb.MockGetRegistryModels()
+	}
+	panic("Mock GetRegistryModels Unimplemented")
+}
+
+func (mdb MockDatabase) GetModelsByAliasAndProjectID(
+	alias string,
+	projectID string,
+	modelType string,
+) ([]Model, error) {
+	if mdb.MockGetModelsByAliasAndProjectID != nil {
+		return mdb.MockGetModelsByAliasAndProjectID(alias, projectID, modelType)
+	}
+	panic("Mock GetModelsByAliasAndProjectID Unimplemented")
 }
 
 func (mdb MockDatabase) GetProjectForUserID(_ string) (*string, error) {
 	panic("Mock GetProjectForUserID Unimplemented")
 }
 
-func (mdb MockDatabase) GetProjectUserByID(_ string) (*ProjectUser, error) {
+func (mdb MockDatabase) GetProjectUserByID(id string) (*ProjectUser, error) {
+	if mdb.MockGetProjectUserByID != nil {
+		return mdb.MockGetProjectUserByID(id)
+	}
 	panic("Mock GetProjectUserByID Unimplemented")
 }
 
-func (mdb MockDatabase) GetProjectByID(_ string) (*Project, error) {
+func (mdb MockDatabase) GetProjectByID(id string) (*Project, error) {
+	if mdb.MockGetProjectByID != nil {
+		return mdb.MockGetProjectByID(id)
+	}
 	panic("Mock GetProjectByID Unimplemented")
 }
 
@@ -90,28 +203,124 @@ func (mdb MockDatabase) AddMemories(_ string, _ []Embedding) error {
 	panic("Mock AddMemories Unimplemented")
 }
 
-func (mdb MockDatabase) GetMemory(_ string) (*Memory, error) {
+func (mdb MockDatabase) GetMemory(memoryID string) (*Memory, error) {
+	if mdb.MockGetMemory != nil {
+		return mdb.MockGetMemory(memoryID)
+	}
 	panic("Mock GetMemory Unimplemented")
 }
 
-func (mdb MockDatabase) CreateMemory(_ string, _ string, _ bool) error {
+func (mdb MockDatabase) CreateMemory(_ string, _ string, _ bool, _ string, _ int) error {
 	panic("Mock CreateMemory Unimplemented")
 }
 
-func (mdb MockDatabase) AddChatMessage(_ string, _ bool, _ string) error {
+func (mdb MockDatabase) AddChatMessage(
+	chatID string,
+	isUserMessage bool,
+	content string,
+	images []string,
+) error {
+	if mdb.MockAddChatMessage != nil {
+		return mdb.MockAddChatMessage(chatID, isUserMessage, content, images)
+	}
 	panic("Mock AddChatMessage Unimplemented")
 }
 
 func (mdb MockDatabase) GetChatMessages(
-	_ string,
-	_ string,
-	_ bool,
-	_ int,
-	_ int,
+	userID string,
+	chatID string,
+	orderByDESC bool,
+	limit int,
+	offset int,
 ) ([]ChatMessage, error) {
+	if mdb.MockGetChatMessages != nil {
+		return mdb.MockGetChatMessages(userID, chatID, orderByDESC, limit, offset)
+	}
 	panic("Mock GetChatMessages Unimplemented")
 }
 
+func (mdb MockDatabase) GetChatMessage(chatID string, id string) (*ChatMessage, error) {
+	if mdb.MockGetChatMessage != nil {
+		return mdb.MockGetChatMessage(chatID, id)
+	}
+	panic("Mock GetChatMessage Unimplemented")
+}
+
+func (mdb MockDatabase) SetChatActiveMessage(chatID string, messageID *string) error {
+	if mdb.MockSetChatActiveMessage != nil {
+		return mdb.MockSetChatActiveMessage(chatID, messageID)
+	}
+	panic("Mock SetChatActiveMessage Unimplemented")
+}
+
+func (mdb MockDatabase) SetChatMessageSummary(id string, summary string) error {
+	if mdb.MockSetChatMessageSummary != nil {
+		return mdb.MockSetChatMessageSummary(id, summary)
+	}
+	panic("Mock SetChatMessageSummary Unimplemented")
+}
+
+func (mdb MockDatabase) GetAllChatMessages(userID string, chatID string) ([]ChatMessage, error) {
+	if mdb.MockGetAllChatMessages != nil {
+		return mdb.MockGetAllChatMessages(userID, chatID)
+	}
+	panic("Mock GetAllChatMessages Unimplemented")
+}
+
+func (mdb MockDatabase) ImportChat(
+	chat Chat,
+	messages []ChatMessageImport,
+	activeMessage int,
+) (*Chat, error) {
+	if mdb.MockImportChat != nil {
+		return mdb.MockImportChat(chat, messages, activeMessage)
+	}
+	panic("Mock ImportChat Unimplemented")
+}
+
+func (mdb MockDatabase) CreateChatShare(
+	userID string,
+	chatID string,
+	expiresAt *time.Time,
+) (*ChatShare, error) {
+	if mdb.MockCreateChatShare != nil {
+		return mdb.MockCreateChatShare(userID, chatID, expiresAt)
+	}
+	panic("Mock CreateChatShare Unimplemented")
+}
+
+func (mdb MockDatabase) ListChatShares(userID string, chatID string) ([]ChatShare, error) {
+	if mdb.MockListChatShares != nil {
+		return mdb.MockListChatShares(userID, chatID)
+	}
+	panic("Mock ListChatShares Unimplemented")
+}
+
+func (mdb MockDatabase) RevokeChatShare(userID string, chatID string, id string) error {
+	if mdb.MockRevokeChatShare != nil {
+		return mdb.MockRevokeChatShare(userID, chatID, id)
+	}
+	panic("Mock RevokeChatShare Unimplemented")
+}
+
+func (mdb MockDatabase) GetChatShareByToken(token string) (*ChatShare, error) {
+	if mdb.MockGetChatShareByToken != nil {
+		return mdb.MockGetChatShareByToken(token)
+	}
+	panic("Mock GetChatShareByToken Unimplemented")
+}
+
+func (mdb MockDatabase) SwitchChatBranch(
+	userID string,
+	chatID string,
+	messageID string,
+) (*Chat, error) {
+	if mdb.MockSwitchChatBranch != nil {
+		return mdb.MockSwitchChatBranch(userID, chatID, messageID)
+	}
+	panic("Mock SwitchChatBranch Unimplemented")
+}
+
 func (mdb MockDatabase) UpdateChat(_ string, _ string, _ string) (*Chat, error) {
 	panic("Mock UpdateChat Unimplemented")
 }
@@ -124,11 +333,20 @@ func (mdb MockDatabase) ListChats(_ string) ([]ChatWithLatestMessage, error) {
 	panic("Mock ListChats Unimplemented")
 }
 
-func (mdb MockDatabase) CreateChat(_ string, _ *string, _ *string, _ *string) (*Chat, error) {
+func (mdb MockDatabase) CreateChat(
+	_ string,
+	_ *string,
+	_ *string,
+	_ *int,
+	_ *string,
+) (*Chat, error) {
 	panic("Mock CreateChat Unimplemented")
 }
 
-func (mdb MockDatabase) GetChatByID(_ string) (*Chat, error) {
+func (mdb MockDatabase) GetChatByID(id string) (*Chat, error) {
+	if mdb.MockGetChatByID != nil {
+		return mdb.MockGetChatByID(id)
+	}
 	panic("Mock GetChatByID Unimplemented")
 }
 
@@ -136,10 +354,130 @@ func (mdb MockDatabase) RetrieveSystemPromptID(_ *string) (*string, error) {
 	panic("Mock RetrieveSystemPromptID Unimplemented")
 }
 
-func (mdb MockDatabase) GetPromptByIDOrSlug(_ string) (*Prompt, error) {
+func (mdb MockDatabase) GetPromptByIDOrSlug(id string) (*Prompt, error) {
+	if mdb.MockGetPromptByIDOrSlug != nil {
+		return mdb.MockGetPromptByIDOrSlug(id)
+	}
 	panic("Mock GetPromptByIDOrSlug Unimplemented")
 }
 
+func (mdb MockDatabase) CreatePrompt(
+	userID string,
+	projectID string,
+	prompt PromptInsert,
+) (*Prompt, error) {
+	if mdb.MockCreatePrompt != nil {
+		return mdb.MockCreatePrompt(userID, projectID, prompt)
+	}
+	panic("Mock CreatePrompt Unimplemented")
+}
+
+func (mdb MockDatabase) UpdatePrompt(
+	userID string,
+	id string,
+	update PromptUpdate,
+) (*Prompt, error) {
+	if mdb.MockUpdatePrompt != nil {
+		return mdb.MockUpdatePrompt(userID, id, update)
+	}
+	panic("Mock UpdatePrompt Unimplemented")
+}
+
+func (mdb MockDatabase) DeletePrompt(userID string, id string) error {
+	if mdb.MockDeletePrompt != nil {
+		return mdb.MockDeletePrompt(userID, id)
+	}
+	panic("Mock DeletePrompt Unimplemented")
+}
+
+func (mdb MockDatabase) GetPromptVersion(promptID string, version int) (*PromptVersion, error) {
+	if mdb.MockGetPromptVersion != nil {
+		return mdb.MockGetPromptVersion(promptID, version)
+	}
+	panic("Mock GetPromptVersion Unimplemented")
+}
+
+func (mdb MockDatabase) ListPromptVersions(promptID string) ([]PromptVersion, error) {
+	if mdb.MockListPromptVersions != nil {
+		return mdb.MockListPromptVersions(promptID)
+	}
+	panic("Mock ListPromptVersions Unimplemented")
+}
+
+func (mdb MockDatabase) CreatePromptExperiment(
+	promptID string,
+	projectID *string,
+	name string,
+	variants []PromptExperimentVariant,
+) (*PromptExperiment, error) {
+	if mdb.MockCreatePromptExperiment != nil {
+		return mdb.MockCreatePromptExperiment(promptID, projectID, name, variants)
+	}
+	panic("Mock CreatePromptExperiment Unimplemented")
+}
+
+func (mdb MockDatabase) ListPromptExperiments(promptID string) ([]PromptExperiment, error) {
+	if mdb.MockListPromptExperiments != nil {
+		return mdb.MockListPromptExperiments(promptID)
+	}
+	panic("Mock ListPromptExperiments Unimplemented")
+}
+
+func (mdb MockDatabase) StopPromptExperiment(promptID string, id string) error {
+	if mdb.MockStopPromptExperiment != nil {
+		return mdb.MockStopPromptExperiment(promptID, id)
+	}
+	panic("Mock StopPromptExperiment Unimplemented")
+}
+
+func (mdb MockDatabase) GetActivePromptExperiment(
+	promptID string,
+	projectID string,
+) (*PromptExperiment, error) {
+	if mdb.MockGetActivePromptExperiment != nil {
+		return mdb.MockGetActivePromptExperiment(promptID, projectID)
+	}
+	panic("Mock GetActivePromptExperiment Unimplemented")
+}
+
+func (mdb MockDatabase) ListPublicPrompts(
+	userID string,
+	filters PublicPromptFilters,
+) ([]PromptWithLikes, error) {
+	if mdb.MockListPublicPrompts != nil {
+		return mdb.MockListPublicPrompts(userID, filters)
+	}
+	panic("Mock ListPublicPrompts Unimplemented")
+}
+
+func (mdb MockDatabase) LikePrompt(userID string, promptID string) error {
+	if mdb.MockLikePrompt != nil {
+		return mdb.MockLikePrompt(userID, promptID)
+	}
+	panic("Mock LikePrompt Unimplemented")
+}
+
+func (mdb MockDatabase) UnlikePrompt(userID string, promptID string) error {
+	if mdb.MockUnlikePrompt != nil {
+		return mdb.MockUnlikePrompt(userID, promptID)
+	}
+	panic("Mock UnlikePrompt Unimplemented")
+}
+
+func (mdb MockDatabase) GetPromptLikes(userID string, promptID string) (int, bool, error) {
+	if mdb.MockGetPromptLikes != nil {
+		return mdb.MockGetPromptLikes(userID, promptID)
+	}
+	panic("Mock GetPromptLikes Unimplemented")
+}
+
+func (mdb MockDatabase) ListPrompts(userID string) ([]Prompt, error) {
+	if mdb.MockListPrompts != nil {
+		return mdb.MockListPrompts(userID)
+	}
+	panic("Mock ListPrompts Unimplemented")
+}
+
 func (mdb MockDatabase) ListKV(_ string) ([]KVStore, error) {
 	panic("Mock ListKV Unimplemented")
 }
@@ -148,7 +486,10 @@ func (mdb MockDatabase) DeleteKV(_ string, _ string) error {
 	panic("Mock DeleteKV Unimplemented")
 }
 
-func (mdb MockDatabase) GetKVMap(_ string, _ []string) (map[string]string, error) {
+func (mdb MockDatabase) GetKVMap(userID string, keys []string) (map[string]string, error) {
+	if mdb.MockGetKVMap != nil {
+		return mdb.MockGetKVMap(userID, keys)
+	}
 	panic("Mock GetKVMap Unimplemented")
 }
 
@@ -169,6 +510,7 @@ func (mdb MockDatabase) LogEvents(
 	_ string,
 	_ bool,
 	_ string,
+	_ int,
 	_ string,
 	_ string,
 ) {
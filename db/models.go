@@ -0,0 +1,74 @@
+package db
+
+import (
+	"github.com/polyfire/api/registry"
+)
+
+type RegistryModel struct {
+	Model            string      `json:"model"`
+	Provider         string      `json:"provider"`
+	Aliases          StringArray `json:"aliases"`
+	ContextWindow    *int        `json:"context_window"`
+	CreditInput      *int        `json:"credit_input"`
+	CreditOutput     *int        `json:"credit_output"`
+	Credit           *int        `json:"credit"`
+	CreditPerSecond  *float64    `json:"credit_per_second"`
+	ReplicateVersion *string     `json:"replicate_version"`
+	OptionStream     bool        `json:"option_stream"`
+	OptionJSON       bool        `json:"option_json"`
+	OptionJSONSchema bool        `json:"option_json_schema"`
+	OptionTools      bool        `json:"option_tools"`
+	OptionVision     bool        `json:"option_vision"`
+	Dimension        *int        `json:"dimension"`
+}
+
+func valueOr[T any](value *T, def T) T {
+	if value == nil {
+		return def
+	}
+	return *value
+}
+
+func (m RegistryModel) ToRegistry() registry.Model {
+	return registry.Model{
+		Aliases:       m.Aliases,
+		Provider:      m.Provider,
+		Model:         m.Model,
+		Version:       valueOr(m.ReplicateVersion, ""),
+		ContextWindow: valueOr(m.ContextWindow, 0),
+		Pricing: registry.Pricing{
+			Input:   float64(valueOr(m.CreditInput, 0)),
+			Output:  float64(valueOr(m.CreditOutput, 0)),
+			Request: valueOr(m.Credit, 0),
+			Second:  valueOr(m.CreditPerSecond, 0),
+		},
+		Capabilities: registry.Capabilities{
+			Stream:     m.OptionStream,
+			JSON:       m.OptionJSON,
+			JSONSchema: m.OptionJSONSchema,
+			Tools:      m.OptionTools,
+			Vision:     m.OptionVision,
+		},
+		Dimension: valueOr(m.Dimension, 0),
+	}
+}
+
+// GetRegistryModels returns the models that are part of the model registry. A model
+// only joins it once its aliases are set, even to an empty array.
+func (db DB) GetRegistryModels() ([]registry.Model, error) {
+	var rows []RegistryModel
+
+	err := db.sql.Raw(
+		"SELECT model, provider, aliases, context_window, credit_input, credit_output, credit, credit_per_second, replicate_version, option_stream, option_json, option_json_schema, option_tools, option_vision, dimension FROM models WHERE aliases IS NOT NULL",
+	).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]registry.Model, len(rows))
+	for i, row := range rows {
+		models[i] = row.ToRegistry()
+	}
+
+	return models, nil
+}
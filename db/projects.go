@@ -105,31 +105,35 @@ type AuthUserProject struct {
 }
 
 type Model struct {
-	ID       int    `json:"id"`
-	Model    string `json:"model"`
-	Provider string `json:"provider"`
+	ID              int     `json:"id"`
+	Model           string  `json:"model"`
+	Provider        string  `json:"provider"`
+	BaseURL         *string `json:"base_url"`
+	EncryptedAPIKey *string `json:"-"`
 }
 
 func (Model) TableName() string {
 	return "models"
 }
 
-func (db DB) GetModelByAliasAndProjectID(
+// GetModelsByAliasAndProjectID returns the fallback chain of an alias. The project's
+// own aliases come first, then the global ones, each ordered by priority.
+func (db DB) GetModelsByAliasAndProjectID(
 	alias string,
 	projectID string,
 	modelType string,
-) (*Model, error) {
-	model := Model{}
+) ([]Model, error) {
+	var models []Model
 
 	err := db.sql.Raw(
-		"SELECT models.* FROM models JOIN model_aliases ON model_aliases.model_id = models.id WHERE model_aliases.alias = ? AND (model_aliases.project_id = ? OR model_aliases.project_id IS NULL) AND models.type = ? LIMIT 1",
+		"SELECT models.* FROM models JOIN model_aliases ON model_aliases.model_id = models.id WHERE model_aliases.alias = ? AND (model_aliases.project_id = ? OR model_aliases.project_id IS NULL) AND models.type = ? ORDER BY model_aliases.project_id IS NULL, model_aliases.priority",
 		alias,
 		projectID,
 		modelType,
-	).First(&model).Error
+	).Scan(&models).Error
 	if err != nil {
 		return nil, err
 	}
 
-	return &model, nil
+	return models, nil
 }
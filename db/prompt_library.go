@@ -0,0 +1,96 @@
+package db
+
+import "gorm.io/gorm/clause"
+
+type PromptWithLikes struct {
+	Prompt
+	LikesCount   int  `json:"likes_count"`
+	UserHasLiked bool `json:"user_has_liked"`
+}
+
+type PublicPromptFilters struct {
+	Tag    string
+	Search string // Full-text search over the name and description
+	Sort   string // "likes", "recent", or the relevance of the search by default
+	Limit  int
+	Offset int
+}
+
+func (db DB) ListPublicPrompts(
+	userID string,
+	filters PublicPromptFilters,
+) ([]PromptWithLikes, error) {
+	var results []PromptWithLikes
+
+	query := db.sql.Table("prompts p").
+		Select(
+			"p.*, COALESCE(l.likes_count, 0) AS likes_count, EXISTS(SELECT 1 FROM prompts_likes ul WHERE ul.prompt_id = p.id AND ul.user_id = ?::uuid) AS user_has_liked",
+			userID,
+		).
+		Joins("LEFT JOIN (SELECT prompt_id, count(*) AS likes_count FROM prompts_likes GROUP BY prompt_id) l ON l.prompt_id = p.id").
+		Where("p.public")
+
+	if filters.Tag != "" {
+		query = query.Where("? = ANY(p.tags)", filters.Tag)
+	}
+
+	if filters.Search != "" {
+		query = query.Where("p.search @@ websearch_to_tsquery('english', ?)", filters.Search)
+	}
+
+	switch {
+	case filters.Sort == "likes":
+		query = query.Order("likes_count DESC")
+	case filters.Search != "" && filters.Sort != "recent":
+		query = query.Order(clause.Expr{
+			SQL:  "ts_rank(p.search, websearch_to_tsquery('english', ?)) DESC",
+			Vars: []interface{}{filters.Search},
+		})
+	}
+
+	err := query.
+		Order("p.created_at DESC").
+		Limit(filters.Limit).
+		Offset(filters.Offset).
+		Scan(&results).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (db DB) LikePrompt(userID string, promptID string) error {
+	return db.sql.Exec(
+		"INSERT INTO prompts_likes (prompt_id, user_id) VALUES (?, ?::uuid) ON CONFLICT (prompt_id, user_id) DO NOTHING",
+		promptID,
+		userID,
+	).Error
+}
+
+func (db DB) UnlikePrompt(userID string, promptID string) error {
+	return db.sql.Exec(
+		"DELETE FROM prompts_likes WHERE prompt_id = ? AND user_id = ?::uuid",
+		promptID,
+		userID,
+	).Error
+}
+
+func (db DB) GetPromptLikes(userID string, promptID string) (int, bool, error) {
+	var result struct {
+		LikesCount   int
+		UserHasLiked bool
+	}
+
+	err := db.sql.Raw(
+		"SELECT count(*) AS likes_count, COALESCE(bool_or(user_id = ?::uuid), false) AS user_has_liked FROM prompts_likes WHERE prompt_id = ?",
+		userID,
+		promptID,
+	).Scan(&result).Error
+	if err != nil {
+		return 0, false, err
+	}
+
+	return result.LikesCount, result.UserHasLiked, nil
+}
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidPromptVersion = errors.New("Invalid prompt version")
+
+// The versions are saved by the database each time the name, description or prompt of a
+// prompt changes, they can't be edited.
+type PromptVersion struct {
+	ID          string    `json:"id"`
+	PromptID    string    `json:"prompt_id"`
+	Version     int       `json:"version"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Prompt      string    `json:"prompt"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (PromptVersion) TableName() string {
+	return "prompt_versions"
+}
+
+// ServedPrompt is the version of a prompt used by a generation
+type ServedPrompt struct {
+	ID           string  `json:"id"`
+	Version      int     `json:"version"`
+	ExperimentID *string `json:"experiment_id,omitempty"`
+}
+
+// ParsePromptRef splits an id or slug pinned to a version like "my-prompt@3"
+func ParsePromptRef(ref string) (string, *int, error) {
+	idOrSlug, version, pinned := strings.Cut(ref, "@")
+	if !pinned {
+		return ref, nil, nil
+	}
+
+	v, err := strconv.Atoi(version)
+	if err != nil || v < 1 {
+		return "", nil, ErrInvalidPromptVersion
+	}
+
+	return idOrSlug, &v, nil
+}
+
+func (db DB) GetPromptVersion(promptID string, version int) (*PromptVersion, error) {
+	var result PromptVersion
+
+	err := db.sql.First(&result, "prompt_id = ? AND version = ?", promptID, version).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (db DB) ListPromptVersions(promptID string) ([]PromptVersion, error) {
+	var results []PromptVersion
+
+	err := db.sql.Order("version DESC").Find(&results, "prompt_id = ?", promptID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+type PromptExperimentVariant struct {
+	Version int `json:"version"`
+	Weight  int `json:"weight"` // In percents, the weights of an experiment add up to 100
+}
+
+type PromptExperiment struct {
+	ID        string                                       `json:"id"`
+	PromptID  string                                       `json:"prompt_id"`
+	ProjectID *string                                      `json:"project_id"`
+	Name      string                                       `json:"name"`
+	Variants  datatypes.JSONSlice[PromptExperimentVariant] `json:"variants"`
+	Active    bool                                         `json:"active"`
+	CreatedAt time.Time                                    `json:"created_at"`
+	StoppedAt *time.Time                                   `json:"stopped_at"`
+}
+
+func (PromptExperiment) TableName() string {
+	return "prompt_experiments"
+}
+
+/*
+ * Assign picks the version served to a user. The users are split by a hash of their id so
+ * each one always gets the same version while the experiment runs.
+ */
+func (e PromptExperiment) Assign(userID string) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(e.ID + ":" + userID))
+	bucket := int(hash.Sum32() % 100)
+
+	for _, variant := range e.Variants {
+		if bucket < variant.Weight {
+			return variant.Version
+		}
+		bucket -= variant.Weight
+	}
+
+	return e.Variants[len(e.Variants)-1].Version
+}
+
+// Creating an experiment stops the one running on the same prompt and project
+func (db DB) CreatePromptExperiment(
+	promptID string,
+	projectID *string,
+	name string,
+	variants []PromptExperimentVariant,
+) (*PromptExperiment, error) {
+	var result *PromptExperiment
+
+	variantsJSON, err := json.Marshal(variants)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.sql.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(
+			"UPDATE prompt_experiments SET active = false, stopped_at = now() WHERE prompt_id = ? AND project_id IS NOT DISTINCT FROM ?::uuid AND active",
+			promptID,
+			projectID,
+		).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Raw(
+			"INSERT INTO prompt_experiments (prompt_id, project_id, name, variants) VALUES (?, ?::uuid, ?, ?::jsonb) RETURNING *",
+			promptID,
+			projectID,
+			name,
+			string(variantsJSON),
+		).Scan(&result).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (db DB) ListPromptExperiments(promptID string) ([]PromptExperiment, error) {
+	var results []PromptExperiment
+
+	err := db.sql.Order("created_at DESC").Find(&results, "prompt_id = ?", promptID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (db DB) StopPromptExperiment(promptID string, id string) error {
+	result := db.sql.Exec(
+		"UPDATE prompt_experiments SET active = false, stopped_at = now() WHERE id = try_cast_uuid(?) AND prompt_id = ? AND active",
+		id,
+		promptID,
+	)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// An experiment of the project takes precedence over the ones running for every project
+func (db DB) GetActivePromptExperiment(
+	promptID string,
+	projectID string,
+) (*PromptExperiment, error) {
+	var results []PromptExperiment
+
+	err := db.sql.
+		Where("prompt_id = ? AND active", promptID).
+		Where("project_id IS NULL OR project_id = NULLIF(?, '')::uuid", projectID).
+		Order("project_id NULLS LAST, created_at DESC").
+		Limit(1).
+		Find(&results).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	return &results[0], nil
+}
@@ -7,8 +7,13 @@ import (
 	"regexp"
 	"strings"
 	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var ErrPromptAlreadyExists = errors.New("A prompt with this name or slug already exists")
+
 type StringArray []string
 
 func (o *StringArray) Scan(src any) error {
@@ -51,6 +56,8 @@ type Prompt struct {
 	Public      bool        `json:"public"`
 	UserID      string      `json:"user_id"`
 	Slug        string      `json:"slug"`
+	Version     int         `json:"version"` // The latest one
+	ForkedFrom  *string     `json:"forked_from,omitempty"`
 }
 
 func (db DB) GetPromptByIDOrSlug(id string) (*Prompt, error) {
@@ -107,3 +114,107 @@ func (db DB) RetrieveSystemPromptID(systemPromptIDOrSlug *string) (*string, erro
 
 	return &prompt.ID, nil
 }
+
+// The name and slug of the prompts are unique across all the users
+type PromptInsert struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Prompt      string      `json:"prompt"`
+	Tags        StringArray `json:"tags"`
+	Public      bool        `json:"public"`
+	Slug        *string     `json:"slug"`
+	ForkedFrom  *string     `json:"-"`
+}
+
+type PromptUpdate struct {
+	Name        *string      `json:"name,omitempty"`
+	Description *string      `json:"description,omitempty"`
+	Prompt      *string      `json:"prompt,omitempty"`
+	Tags        *StringArray `json:"tags,omitempty"`
+	Public      *bool        `json:"public,omitempty"`
+	Slug        *string      `json:"slug,omitempty"`
+}
+
+// The names and slugs are checked by their unique constraints, a separate check would be racy
+func promptError(err error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrPromptAlreadyExists
+	}
+	return err
+}
+
+func (db DB) CreatePrompt(userID string, projectID string, prompt PromptInsert) (*Prompt, error) {
+	var result *Prompt
+
+	err := db.sql.Raw(
+		"INSERT INTO prompts (user_id, project_id, name, description, prompt, tags, public, slug, forked_from) VALUES (?::uuid, NULLIF(?, '')::uuid, ?, ?, ?, ?, ?, ?, ?::uuid) RETURNING *",
+		userID,
+		projectID,
+		prompt.Name,
+		prompt.Description,
+		prompt.Prompt,
+		prompt.Tags,
+		prompt.Public,
+		prompt.Slug,
+		prompt.ForkedFrom,
+	).Scan(&result).Error
+	if err != nil {
+		return nil, promptError(err)
+	}
+
+	return result, nil
+}
+
+func (db DB) UpdatePrompt(userID string, id string, update PromptUpdate) (*Prompt, error) {
+	var result Prompt
+
+	values := map[string]interface{}{"updated_at": time.Now()}
+	if update.Name != nil {
+		values["name"] = *update.Name
+	}
+	if update.Description != nil {
+		values["description"] = *update.Description
+	}
+	if update.Prompt != nil {
+		values["prompt"] = *update.Prompt
+	}
+	if update.Tags != nil {
+		values["tags"] = *update.Tags
+	}
+	if update.Public != nil {
+		values["public"] = *update.Public
+	}
+	if update.Slug != nil {
+		values["slug"] = *update.Slug
+	}
+
+	err := db.sql.Model(&result).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND user_id = ?", id, userID).
+		Updates(values).
+		Error
+	if err != nil {
+		return nil, promptError(err)
+	}
+
+	if result.ID == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return &result, nil
+}
+
+func (db DB) DeletePrompt(userID string, id string) error {
+	return db.sql.Exec("DELETE FROM prompts WHERE id = ? AND user_id = ?", id, userID).Error
+}
+
+func (db DB) ListPrompts(userID string) ([]Prompt, error) {
+	var results []Prompt
+
+	err := db.sql.Order("created_at DESC").Find(&results, "user_id = ?", userID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -3,7 +3,7 @@ package db
 import (
 	"database/sql"
 
-	"github.com/polyfire/api/codegen"
+	"github.com/polyfire/api/registry"
 )
 
 type Kind string
@@ -23,46 +23,6 @@ type RequestLog struct {
 	Kind             Kind   `json:"kind"`
 }
 
-func tokenToCredit(
-	providerName string,
-	modelName string,
-	inputTokenCount int,
-	outputTokenCount int,
-) int {
-	switch providerName {
-	case "openai":
-		switch modelName {
-		case "gpt-3.5-turbo":
-			return (inputTokenCount * 5) + (outputTokenCount * 15)
-		case "gpt-3.5-turbo-16k":
-			return (inputTokenCount * 5) + (outputTokenCount * 15)
-		case "gpt-4":
-			return (inputTokenCount * 300) + (outputTokenCount * 600)
-		case "gpt-4-32k":
-			return (inputTokenCount * 600) + (outputTokenCount * 1200)
-		case "gpt-4o":
-			return (inputTokenCount * 50) + (outputTokenCount * 150)
-		case "gpt-4o-mini":
-			return (inputTokenCount * 50) + (outputTokenCount * 150)
-		case "gpt-4-turbo":
-			return (inputTokenCount * 100) + (outputTokenCount * 300)
-		case "text-embedding-ada-002":
-			return inputTokenCount * 1
-		case "dall-e-2":
-			return 200000
-		case "dall-e-3":
-			return 800000
-		}
-	case "openrouter":
-		return codegen.OpenRouterPrices(modelName, inputTokenCount, outputTokenCount)
-	case "llama":
-		return 0
-	case "cohere":
-		return (inputTokenCount + outputTokenCount) * 150
-	}
-	return 0
-}
-
 func (db DB) LogRequests(
 	eventID string,
 	userID string,
@@ -79,7 +39,7 @@ func (db DB) LogRequests(
 	var credits int
 
 	if countCredits {
-		credits = tokenToCredit(providerName, modelName, inputTokenCount, outputTokenCount)
+		credits = registry.Credits(providerName, modelName, inputTokenCount, outputTokenCount)
 	} else {
 		credits = 0
 	}
@@ -152,11 +112,12 @@ func (db DB) LogEvents(
 	responseBody string,
 	error bool,
 	promptID string,
+	promptVersion int,
 	eventType string,
 	orginDomain string,
 ) {
 	err := db.sql.Exec(
-		`INSERT INTO events (id, path, user_id, project_id, request_body, response_body, error, prompt_id, type, origin_domain)
+		`INSERT INTO events (id, path, user_id, project_id, request_body, response_body, error, prompt_id, prompt_version, type, origin_domain)
 		VALUES (
 			@id,
 			@path,
@@ -166,6 +127,7 @@ func (db DB) LogEvents(
 			@response_body,
 			@error,
 			(SELECT id FROM prompts WHERE id = try_cast_uuid(@prompt_id) OR slug = @prompt_id LIMIT 1)::uuid,
+			NULLIF(@prompt_version, 0),
 			@type,
 			@origin_domain
 		) ON CONFLICT DO NOTHING`,
@@ -177,6 +139,7 @@ func (db DB) LogEvents(
 		sql.Named("response_body", responseBody),
 		sql.Named("error", error),
 		sql.Named("prompt_id", promptID),
+		sql.Named("prompt_version", promptVersion),
 		sql.Named("type", eventType),
 		sql.Named("origin_domain", orginDomain),
 	).Error
@@ -42,6 +42,7 @@ type UserInfos struct {
 	OpenaiOrg            string      `json:"openai_org"`
 	ElevenlabsToken      string      `json:"elevenlabs_token"` // Same here, don't change to ElevenLabs
 	ReplicateToken       string      `json:"replicate_token"`
+	AnthropicToken       string      `json:"anthropic_token"`
 	AuthorizedDomains    StringArray `json:"authorized_domains"`
 	ProjectID            string      `json:"project_id"`
 	ProjectUserID        string      `json:"project_user_id"`
@@ -60,6 +61,7 @@ func (db DB) getUserInfos(userID string) (*UserInfos, error) {
 			dev_users.openai_token as openai_token,
 			dev_users.openai_org as openai_org,
 			dev_users.replicate_token as replicate_token,
+			dev_users.anthropic_token as anthropic_token,
 			dev_users.elevenlabs_token as elevenlabs_token,
 			projects.authorized_domains as authorized_domains,
 			CASE
@@ -0,0 +1,260 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	router "github.com/julienschmidt/httprouter"
+
+	"github.com/polyfire/api/completion"
+	database "github.com/polyfire/api/db"
+	options "github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/middlewares"
+	"github.com/polyfire/api/utils"
+)
+
+const (
+	MaxRunningJobs = 32
+	JobTimeout     = 30 * time.Minute
+	// Long enough for the generation and the delivery of the webhook
+	JobLease = JobTimeout + 5*time.Minute
+)
+
+// The jobs waiting for a slot stay pending
+var runningJobs = make(chan struct{}, MaxRunningJobs)
+
+// Identifies this server in the claims of the jobs
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+type JobResponse struct {
+	database.GenerationJob
+	// Only given when the job is created, it is needed to check the webhook signatures
+	WebhookSecret *string `json:"webhook_secret,omitempty"`
+}
+
+/*
+ * run generates the answer of a job and stores it, billing goes through the LogRequests
+ * callback of GenerationStart like for /generate. The context must not be cancelled when
+ * the request that created the job ends.
+ *
+ * The job is claimed once it has a slot, it isn't run if another server has claimed it.
+ */
+func run(ctx context.Context, job database.GenerationJob) {
+	db := ctx.Value(utils.ContextKeyDB).(database.Database)
+
+	runningJobs <- struct{}{}
+	defer func() { <-runningJobs }()
+
+	claimed, err := db.ClaimGenerationJob(job.ID, instanceID, JobLease)
+	if err != nil {
+		log.Printf("[ERROR] Could not claim generation job %s: %v", job.ID, err)
+		return
+	}
+	if claimed == nil {
+		return
+	}
+	job = *claimed
+
+	ctx, cancel := context.WithTimeout(ctx, JobTimeout)
+	defer cancel()
+
+	result := options.Result{}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ERROR] Generation job %s panicked: %v", job.ID, r)
+			result = options.Result{Err: "internal_error"}
+		}
+		complete(ctx, job, result)
+	}()
+
+	var input completion.GenerateRequestBody
+	if err := json.Unmarshal(job.Input, &input); err != nil {
+		result = options.Result{Err: "invalid_json"}
+		return
+	}
+
+	resChan, err := completion.GenerationStart(ctx, job.UserID, input)
+	if err != nil {
+		result = options.Result{Err: completion.ErrorCode(err)}
+		return
+	}
+
+	result = completion.CollectResult(resChan, input)
+}
+
+func complete(ctx context.Context, job database.GenerationJob, result options.Result) {
+	db := ctx.Value(utils.ContextKeyDB).(database.Database)
+
+	job.Status = database.GenerationJobSucceeded
+	if result.Err != "" {
+		job.Status = database.GenerationJobFailed
+	}
+
+	job.Result, _ = result.JSON()
+	now := time.Now()
+	job.CompletedAt = &now
+
+	if err := db.CompleteGenerationJob(job.ID, job.Status, job.Result); err != nil {
+		log.Printf("[ERROR] Could not complete generation job %s: %v", job.ID, err)
+	}
+
+	if job.WebhookURL == nil || job.WebhookSecret == nil {
+		return
+	}
+
+	body, _ := json.Marshal(job)
+	// The webhook is sent even if the job timed out
+	err := sendWebhook(utils.Detach(ctx), *job.WebhookURL, *job.WebhookSecret, body)
+	if err != nil {
+		log.Printf("[ERROR] Generation job %s: %v", job.ID, err)
+	}
+}
+
+/*
+ * Resume restarts the jobs that were pending or running when the server stopped. They
+ * run again from the start with the current infos of their user, the ones whose token
+ * has been revoked since fail.
+ *
+ * The other servers can be resuming the same jobs, or still running some of them, so a
+ * job is only resumed by the server that claims it.
+ */
+func Resume(ctx context.Context) {
+	db := ctx.Value(utils.ContextKeyDB).(database.Database)
+
+	jobs, err := db.GetUnfinishedGenerationJobs()
+	if err != nil {
+		log.Println("[ERROR] Could not get the unfinished generation jobs: ", err)
+		return
+	}
+
+	resumed := 0
+	for _, unfinished := range jobs {
+		job, err := db.ClaimGenerationJob(unfinished.ID, instanceID, JobLease)
+		if err != nil {
+			log.Printf("[ERROR] Could not claim generation job %s: %v", unfinished.ID, err)
+			continue
+		}
+		if job == nil {
+			continue
+		}
+		resumed++
+
+		user, rateLimitStatus, creditsStatus, err := db.CheckDBVersionRateLimit(
+			job.UserID,
+			job.TokenVersion,
+		)
+		if errors.Is(err, database.ErrDBVersionMismatch) ||
+			errors.Is(err, database.ErrUnknownUserID) {
+			go complete(ctx, *job, options.Result{Err: "job_interrupted"})
+			continue
+		}
+		if err != nil {
+			log.Printf("[ERROR] Could not get the user of generation job %s: %v", job.ID, err)
+			go complete(ctx, *job, options.Result{Err: "database_error"})
+			continue
+		}
+
+		jobCtx := middlewares.UserContext(ctx, job.UserID, user, rateLimitStatus, creditsStatus)
+		eventID := ""
+		if job.EventID != nil {
+			eventID = *job.EventID
+		}
+		jobCtx = context.WithValue(jobCtx, utils.ContextKeyEventID, eventID)
+
+		go run(jobCtx, *job)
+	}
+
+	log.Printf("[INFO] Resumed %d generation jobs", resumed)
+}
+
+func CreateGeneration(w http.ResponseWriter, r *http.Request, _ router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	eventID, _ := r.Context().Value(utils.ContextKeyEventID).(string)
+	tokenVersion, _ := r.Context().Value(utils.ContextKeyTokenVersion).(int)
+
+	// The same body as /generate with the webhook to call at the end
+	var requestBody struct {
+		completion.GenerateRequestBody
+		WebhookURL *string `json:"webhook_url,omitempty"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		utils.RespondError(w, record, "invalid_json")
+		return
+	}
+
+	var webhookSecret *string
+	if requestBody.WebhookURL != nil {
+		if !isValidWebhookURL(r.Context(), *requestBody.WebhookURL) {
+			utils.RespondError(w, record, "invalid_webhook_url")
+			return
+		}
+
+		secret, err := newWebhookSecret()
+		if err != nil {
+			utils.RespondError(w, record, "internal_error")
+			return
+		}
+		webhookSecret = &secret
+	}
+
+	input, _ := json.Marshal(requestBody.GenerateRequestBody)
+
+	job, err := db.CreateGenerationJob(
+		userID,
+		input,
+		requestBody.WebhookURL,
+		webhookSecret,
+		eventID,
+		tokenVersion,
+	)
+	if err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	go run(utils.Detach(r.Context()), *job)
+
+	response := JobResponse{GenerationJob: *job, WebhookSecret: webhookSecret}
+
+	responseJSON, _ := json.Marshal(response)
+	record(string(responseJSON))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write(responseJSON)
+}
+
+func Get(w http.ResponseWriter, r *http.Request, p router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+
+	job, err := db.GetGenerationJob(userID, p.ByName("id"))
+	if err != nil {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	responseJSON, _ := json.Marshal(job)
+	record(string(responseJSON))
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
@@ -0,0 +1,138 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/utils"
+)
+
+func mockLogRequests(
+	_ string,
+	_ string,
+	_ string,
+	_ string,
+	_ int,
+	_ int,
+	_ database.Kind,
+	_ bool,
+) {
+}
+
+func TestRunWithWebhook(t *testing.T) {
+	utils.SetLogLevel("WARN")
+	ctx := utils.MockOpenAIServer(context.Background())
+
+	secret := "secret"
+	webhookCalls := make(chan []byte, 1)
+
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+
+		if r.Header.Get(WebhookSignatureHeader) != SignWebhook(secret, timestamp, body) {
+			t.Errorf("The webhook signature doesn't match")
+		}
+
+		webhookCalls <- body
+	}))
+	defer webhook.Close()
+
+	job := database.GenerationJob{
+		ID:            "00000000-0000-0000-0000-000000000001",
+		UserID:        "00000000-0000-0000-0000-000000000000",
+		Input:         []byte(`{"task": "Test"}`),
+		WebhookURL:    &webhook.URL,
+		WebhookSecret: &secret,
+	}
+
+	var completedStatus database.GenerationJobStatus
+	ctx = context.WithValue(ctx, utils.ContextKeyDB, database.MockDatabase{
+		MockLogRequests: mockLogRequests,
+		MockClaimGenerationJob: func(
+			_ string,
+			_ string,
+			_ time.Duration,
+		) (*database.GenerationJob, error) {
+			return &job, nil
+		},
+		MockCompleteGenerationJob: func(_ string, s database.GenerationJobStatus, _ []byte) error {
+			completedStatus = s
+			return nil
+		},
+	})
+	ctx = context.WithValue(ctx, utils.ContextKeyRateLimitStatus, database.RateLimitStatusOk)
+	ctx = context.WithValue(ctx, utils.ContextKeyCreditsStatus, database.CreditsStatusOk)
+	ctx = context.WithValue(ctx, utils.ContextKeyEventID, "00000000-0000-0000-0000-000000000000")
+
+	run(ctx, job)
+
+	if completedStatus != database.GenerationJobSucceeded {
+		t.Fatalf("The job should have succeeded, got %v", completedStatus)
+	}
+
+	var payload struct {
+		Status database.GenerationJobStatus `json:"status"`
+		Result struct {
+			Result string `json:"result"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(<-webhookCalls, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if payload.Status != database.GenerationJobSucceeded || payload.Result.Result != "Test response" {
+		t.Fatalf("Unexpected webhook payload: %v", payload)
+	}
+}
+
+func TestRunClaimedJobOnce(t *testing.T) {
+	utils.SetLogLevel("WARN")
+
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, database.MockDatabase{
+		// Another server has claimed the job
+		MockClaimGenerationJob: func(
+			_ string,
+			_ string,
+			_ time.Duration,
+		) (*database.GenerationJob, error) {
+			return nil, nil
+		},
+		MockGetUnfinishedGenerationJobs: func() ([]database.GenerationJob, error) {
+			return []database.GenerationJob{{ID: "00000000-0000-0000-0000-000000000001"}}, nil
+		},
+	})
+
+	// The mock panics if the job is generated or completed without being claimed
+	run(ctx, database.GenerationJob{ID: "00000000-0000-0000-0000-000000000001"})
+	Resume(ctx)
+}
+
+func TestIsValidWebhookURL(t *testing.T) {
+	ctx := context.Background()
+
+	if !isValidWebhookURL(ctx, "https://8.8.8.8/hook") {
+		t.Fatal("An https URL should be accepted")
+	}
+
+	for _, u := range []string{
+		"http://8.8.8.8/hook",
+		"https://",
+		"not a url",
+		"https://127.0.0.1/hook",
+		"https://localhost/hook",
+		"https://10.0.0.1/hook",
+		"https://169.254.169.254/latest/meta-data",
+	} {
+		if isValidWebhookURL(ctx, u) {
+			t.Fatalf("%q should be rejected", u)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/polyfire/api/utils"
+)
+
+const (
+	WebhookSignatureHeader = "X-Polyfire-Signature"
+	WebhookTimestampHeader = "X-Polyfire-Timestamp"
+	WebhookAttempts        = 3
+)
+
+var ErrWebhookDelivery = errors.New("Webhook delivery failed")
+
+// The webhooks are given by the users, they must not be able to reach our internal services
+var webhookHTTPClient = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: utils.PublicHTTPTransport(),
+}
+
+func isValidWebhookURL(ctx context.Context, webhookURL string) bool {
+	return utils.ValidatePublicURL(ctx, webhookURL) == nil
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// SignWebhook returns the signature of a webhook body, the hex encoded HMAC-SHA256 of
+// "<timestamp>.<body>" with the secret given when the job was created.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendWebhook posts the body to the webhook, retrying a few times when it doesn't answer
+// with a 2xx status. Each attempt is signed with its own timestamp.
+func sendWebhook(ctx context.Context, webhookURL string, secret string, body []byte) error {
+	client := webhookHTTPClient
+	if c, ok := ctx.Value(utils.ContextKeyHTTPClient).(*http.Client); ok {
+		client = c
+	}
+
+	for attempt := 0; attempt < WebhookAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt*attempt) * time.Second)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+
+		timestamp := time.Now().Unix()
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, timestamp, body))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Printf("[WARNING] Webhook %s failed: %v", webhookURL, err)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		log.Printf("[WARNING] Webhook %s answered %d", webhookURL, resp.StatusCode)
+	}
+
+	return fmt.Errorf("%w: %s", ErrWebhookDelivery, webhookURL)
+}
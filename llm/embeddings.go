@@ -2,28 +2,171 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
 
+	database "github.com/polyfire/api/db"
 	providers "github.com/polyfire/api/llm/providers"
+	"github.com/polyfire/api/registry"
+	"github.com/polyfire/api/resilience"
 	llmTokens "github.com/polyfire/api/tokens"
 	goOpenai "github.com/sashabaranov/go-openai"
 
 	"github.com/polyfire/api/utils"
 )
 
-func Embed(ctx context.Context, contents []string, c *func(string, int)) ([][]float32, error) {
+var (
+	ErrUnknownEmbeddingModel = errors.New("Unknown embedding model")
+	ErrEmbeddingDimension    = errors.New("Embedding dimension mismatch")
+)
+
+const DefaultEmbeddingModel = "text-embedding-ada-002"
+
+type EmbeddingModel struct {
+	Provider  string
+	Model     string
+	Dimension int // 0 when it's not known yet, for the projects' own models
+
+	// Only for the openai-compatible models
+	BaseURL string
+	APIKey  string
+}
+
+type EmbeddingCallback *func(providerName string, modelName string, inputCount int)
+
+/*
+ * GetEmbeddingModel finds an embedding model by name. The registry is checked first,
+ * then the project's own models so a memory can use an OpenAI compatible server.
+ */
+func GetEmbeddingModel(ctx context.Context, name string) (EmbeddingModel, error) {
+	if name == "" {
+		name = DefaultEmbeddingModel
+	}
+
+	for _, provider := range []string{"openai", "cohere"} {
+		if m, ok := registry.Find(provider, name); ok && m.Dimension > 0 {
+			return EmbeddingModel{Provider: m.Provider, Model: m.Model, Dimension: m.Dimension}, nil
+		}
+	}
+
+	db := ctx.Value(utils.ContextKeyDB).(database.Database)
+	projectID, _ := ctx.Value(utils.ContextKeyProjectID).(string)
+
+	models, err := db.GetModelsByAliasAndProjectID(name, projectID, "embedding")
+	if err != nil || len(models) == 0 {
+		return EmbeddingModel{}, ErrUnknownEmbeddingModel
+	}
+
+	model := models[0]
+	if model.Provider != "openai-compatible" || model.BaseURL == nil || *model.BaseURL == "" {
+		return EmbeddingModel{}, ErrInvalidModelConfiguration
+	}
+
+	if err := utils.ValidatePublicURL(ctx, *model.BaseURL); err != nil {
+		log.Printf("[WARNING] Rejected the model base URL %s: %v", *model.BaseURL, err)
+		return EmbeddingModel{}, ErrInvalidModelConfiguration
+	}
+
+	apiKey := ""
+	if model.EncryptedAPIKey != nil && *model.EncryptedAPIKey != "" {
+		apiKey, err = utils.Decrypt(*model.EncryptedAPIKey)
+		if err != nil {
+			log.Println("[ERROR] Could not decrypt the model API key: ", err)
+			return EmbeddingModel{}, ErrInvalidModelConfiguration
+		}
+	}
+
+	return EmbeddingModel{
+		Provider: model.Provider,
+		Model:    model.Model,
+		BaseURL:  *model.BaseURL,
+		APIKey:   apiKey,
+	}, nil
+}
+
+// Embed uses the default embedding model, it's the one of the completion cache.
+func Embed(ctx context.Context, contents []string, c EmbeddingCallback) ([][]float32, error) {
+	model, err := GetEmbeddingModel(ctx, DefaultEmbeddingModel)
+	if err != nil {
+		return nil, err
+	}
+
+	return EmbedWithModel(ctx, model, contents, c)
+}
+
+func EmbedWithModel(
+	ctx context.Context,
+	model EmbeddingModel,
+	contents []string,
+	c EmbeddingCallback,
+) ([][]float32, error) {
+	var embeddings [][]float32
+	var tokenUsage int
+	var err error
+
+	switch model.Provider {
+	case "openai", "openai-compatible":
+		embeddings, tokenUsage, err = embedOpenAI(ctx, model, contents)
+	case "cohere":
+		embeddings, tokenUsage, err = embedCohere(ctx, model, contents)
+	default:
+		return nil, ErrUnknownEmbeddingModel
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, embedding := range embeddings {
+		if model.Dimension > 0 && len(embedding) != model.Dimension {
+			return nil, ErrEmbeddingDimension
+		}
+	}
+
+	if tokenUsage == 0 {
+		for _, content := range contents {
+			tokenUsage += llmTokens.CountTokens(content)
+		}
+	}
+
+	if c != nil {
+		(*c)(model.Provider, model.Model, tokenUsage)
+	}
+
+	return embeddings, nil
+}
+
+func embedOpenAI(
+	ctx context.Context,
+	model EmbeddingModel,
+	contents []string,
+) ([][]float32, int, error) {
 	userID := ctx.Value(utils.ContextKeyUserID).(string)
 
-	client := providers.NewOpenAIStreamProvider(ctx, fmt.Sprint(goOpenai.AdaEmbeddingV2)).Client
+	var client goOpenai.Client
+	if model.Provider == "openai-compatible" {
+		client = providers.NewOpenAICompatibleProvider(
+			ctx,
+			model.Model,
+			model.BaseURL,
+			model.APIKey,
+		).Client
+	} else {
+		client = providers.NewOpenAIStreamProvider(ctx, model.Model).Client
+	}
 
-	embeddingCtx := context.Background()
-	res, err := client.CreateEmbeddings(embeddingCtx, goOpenai.EmbeddingRequestStrings{
+	res, err := client.CreateEmbeddings(ctx, goOpenai.EmbeddingRequestStrings{
 		Input: contents,
-		Model: goOpenai.AdaEmbeddingV2,
+		Model: goOpenai.EmbeddingModel(model.Model),
 		User:  userID,
 	})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	var embeddings [][]float32
@@ -32,16 +175,70 @@ func Embed(ctx context.Context, contents []string, c *func(string, int)) ([][]fl
 		embeddings = append(embeddings, embed.Embedding)
 	}
 
-	model := "text-embedding-ada-002"
+	return embeddings, res.Usage.PromptTokens, nil
+}
+
+var cohereHTTPClient = resilience.Client("cohere")
+
+type CohereEmbedRequest struct {
+	Texts     []string `json:"texts"`
+	Model     string   `json:"model"`
+	InputType string   `json:"input_type"`
+}
 
-	tokenUsage := 0
-	for _, content := range contents {
-		tokenUsage += llmTokens.CountTokens(content)
+type CohereEmbedResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+	Meta       struct {
+		BilledUnits struct {
+			InputTokens int `json:"input_tokens"`
+		} `json:"billed_units"`
+	} `json:"meta"`
+}
+
+func embedCohere(
+	ctx context.Context,
+	model EmbeddingModel,
+	contents []string,
+) ([][]float32, int, error) {
+	body, err := json.Marshal(CohereEmbedRequest{
+		Texts: contents,
+		Model: model.Model,
+		// The same vectors are used to store the documents and to search them
+		InputType: "search_document",
+	})
+	if err != nil {
+		return nil, 0, err
 	}
 
-	if c != nil {
-		(*c)(model, tokenUsage)
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		"https://api.cohere.ai/v1/embed",
+		strings.NewReader(string(body)),
+	)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return embeddings, nil
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("COHERE_API_KEY"))
+
+	resp, err := cohereHTTPClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		content, _ := io.ReadAll(resp.Body)
+		return nil, 0, fmt.Errorf("Cohere embed error %d: %s", resp.StatusCode, content)
+	}
+
+	var res CohereEmbedResponse
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return res.Embeddings, res.Meta.BilledUnits.InputTokens, nil
 }
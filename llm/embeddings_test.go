@@ -0,0 +1,46 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/polyfire/api/utils"
+)
+
+func TestEmbedWithModelDimension(t *testing.T) {
+	utils.SetLogLevel("WARN")
+
+	ctx := utils.MockOpenAIServer(context.Background())
+	ctx = context.WithValue(ctx, utils.ContextKeyUserID, "00000000-0000-0000-0000-000000000000")
+
+	model, err := GetEmbeddingModel(ctx, "text-embedding-3-large")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if model.Provider != "openai" || model.Dimension != 3072 {
+		t.Fatalf("Expected openai/text-embedding-3-large with 3072 dimensions, got %v", model)
+	}
+
+	// The mock server returns vectors of 3 dimensions
+	_, err = EmbedWithModel(ctx, model, []string{"Test"}, nil)
+	if !errors.Is(err, ErrEmbeddingDimension) {
+		t.Fatalf("Expected ErrEmbeddingDimension, got %v", err)
+	}
+
+	var billedProvider string
+	callback := func(providerName string, _ string, _ int) {
+		billedProvider = providerName
+	}
+
+	model.Dimension = 3
+	embeddings, err := EmbedWithModel(ctx, model, []string{"Test"}, &callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(embeddings) != 1 || len(embeddings[0]) != 3 || billedProvider != "openai" {
+		t.Fatalf("Unexpected embeddings %v billed to %q", embeddings, billedProvider)
+	}
+}
@@ -0,0 +1,197 @@
+package llm
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/polyfire/api/llm/providers/options"
+)
+
+/*
+ * FallbackProvider tries the providers of a model alias in order. When a provider fails
+ * with a generation_error before emitting anything we can't take back (a token or a
+ * tool call), its results are dropped and the next provider is tried instead.
+ *
+ * Once a provider has started answering it's the one used for billing, rate limiting
+ * and reported in the infos of the result.
+ */
+type FallbackProvider struct {
+	Providers []Provider
+
+	mutex     *sync.Mutex
+	answering int
+}
+
+func NewFallbackProvider(providers []Provider) *FallbackProvider {
+	return &FallbackProvider{
+		Providers: providers,
+		mutex:     &sync.Mutex{},
+		answering: -1,
+	}
+}
+
+func (m *FallbackProvider) answeringIndex() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.answering
+}
+
+func (m *FallbackProvider) current() Provider {
+	index := m.answeringIndex()
+	if index < 0 {
+		return nil
+	}
+	return m.Providers[index]
+}
+
+func (m *FallbackProvider) setAnswering(index int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.answering = index
+}
+
+func isFallbackError(result options.Result) bool {
+	return result.Err == "generation_error"
+}
+
+func hasContent(result options.Result) bool {
+	return result.Result != "" || len(result.ToolCalls) > 0
+}
+
+func (m *FallbackProvider) Generate(
+	ctx context.Context,
+	messages []options.Message,
+	c options.ProviderCallback,
+	opts *options.ProviderOptions,
+) chan options.Result {
+	chanRes := make(chan options.Result)
+
+	go func() {
+		defer close(chanRes)
+
+		for i, provider := range m.Providers {
+			index := i
+			isLast := i == len(m.Providers)-1
+
+			// Only the provider that answered is billed. A provider can call the callback
+			// before we know it answered, its usage is then billed once it's committed.
+			var callback options.ProviderCallback
+			var pending func()
+			if c != nil {
+				wrapped := func(
+					provider, model string,
+					in, out int,
+					completion string,
+					credit *int,
+				) {
+					if m.answeringIndex() == index {
+						(*c)(provider, model, in, out, completion, credit)
+						return
+					}
+					pending = func() {
+						(*c)(provider, model, in, out, completion, credit)
+					}
+				}
+				callback = &wrapped
+			}
+
+			resChan := provider.Generate(ctx, messages, callback, opts)
+			if resChan == nil {
+				continue
+			}
+
+			var buffered []options.Result
+			committed := isLast
+			failed := false
+
+			if committed {
+				m.setAnswering(index)
+			}
+
+			for res := range resChan {
+				if failed {
+					continue // Drain the channel so the provider can end properly
+				}
+
+				if !committed && isFallbackError(res) {
+					providerName, modelName := provider.ProviderModel()
+					log.Printf(
+						"[WARNING] %s/%s failed, falling back to the next model",
+						providerName,
+						modelName,
+					)
+					failed = true
+					continue
+				}
+
+				if !committed && !hasContent(res) && res.Err == "" {
+					buffered = append(buffered, res)
+					continue
+				}
+
+				if !committed {
+					committed = true
+					m.setAnswering(index)
+					for _, b := range buffered {
+						chanRes <- b
+					}
+				}
+
+				chanRes <- res
+			}
+
+			if failed {
+				continue
+			}
+
+			// The provider ended without error nor content, it still answered
+			if !committed {
+				m.setAnswering(index)
+				for _, b := range buffered {
+					chanRes <- b
+				}
+			}
+
+			// The callbacks are called before the end of the generation
+			if pending != nil {
+				pending()
+			}
+
+			return
+		}
+	}()
+
+	return chanRes
+}
+
+func (m *FallbackProvider) Name() string {
+	if provider := m.current(); provider != nil {
+		return provider.Name()
+	}
+	return m.Providers[0].Name()
+}
+
+func (m *FallbackProvider) ProviderModel() (string, string) {
+	if provider := m.current(); provider != nil {
+		return provider.ProviderModel()
+	}
+	return m.Providers[0].ProviderModel()
+}
+
+// Before any provider answered, we consider that the rate limit applies if it applies
+// to any of the providers.
+func (m *FallbackProvider) DoesFollowRateLimit() bool {
+	if provider := m.current(); provider != nil {
+		return provider.DoesFollowRateLimit()
+	}
+
+	for _, provider := range m.Providers {
+		if provider.DoesFollowRateLimit() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package llm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/utils"
+)
+
+type testProvider struct {
+	name    string
+	results []options.Result
+}
+
+func (p testProvider) Name() string {
+	return p.name
+}
+
+func (p testProvider) ProviderModel() (string, string) {
+	return p.name, p.name + "-model"
+}
+
+func (p testProvider) DoesFollowRateLimit() bool {
+	return true
+}
+
+func (p testProvider) Generate(
+	_ context.Context,
+	_ []options.Message,
+	c options.ProviderCallback,
+	_ *options.ProviderOptions,
+) chan options.Result {
+	chanRes := make(chan options.Result)
+	go func() {
+		defer close(chanRes)
+		for _, r := range p.results {
+			chanRes <- r
+		}
+		if c != nil {
+			(*c)(p.name, p.name+"-model", 1, 1, "", nil)
+		}
+	}()
+	return chanRes
+}
+
+func TestFallbackProviderFailover(t *testing.T) {
+	utils.SetLogLevel("WARN")
+
+	provider := NewFallbackProvider([]Provider{
+		testProvider{name: "first", results: []options.Result{
+			{TokenUsage: options.TokenUsage{Input: 10}},
+			{Err: "generation_error"},
+		}},
+		testProvider{name: "second", results: []options.Result{
+			{TokenUsage: options.TokenUsage{Input: 5}},
+			{Result: "Hello"},
+		}},
+	})
+
+	billed := []string{}
+	callback := func(provider, _ string, _, _ int, _ string, _ *int) {
+		billed = append(billed, provider)
+	}
+
+	result := ""
+	inputTokens := 0
+	for v := range provider.Generate(context.Background(), nil, &callback, nil) {
+		if v.Err != "" {
+			t.Fatalf(`The error of the first provider should have been dropped: %s`, v.Err)
+		}
+		result += v.Result
+		inputTokens += v.TokenUsage.Input
+	}
+
+	if result != "Hello" || inputTokens != 5 {
+		t.Fatalf(
+			`Expected the second provider's result, got "%s" (%d input tokens)`,
+			result,
+			inputTokens,
+		)
+	}
+
+	if len(billed) != 1 || billed[0] != "second" {
+		t.Fatalf(`Only the second provider should have been billed: %v`, billed)
+	}
+
+	if providerName, _ := provider.ProviderModel(); providerName != "second" {
+		t.Fatalf(`The answering provider should be "second" but is "%s"`, providerName)
+	}
+}
+
+func TestFallbackProviderNoFailoverAfterFirstToken(t *testing.T) {
+	utils.SetLogLevel("WARN")
+
+	provider := NewFallbackProvider([]Provider{
+		testProvider{name: "first", results: []options.Result{
+			{Result: "Hel"},
+			{Err: "generation_error"},
+		}},
+		testProvider{name: "second", results: []options.Result{{Result: "Hello"}}},
+	})
+
+	result := ""
+	errorCode := ""
+	for v := range provider.Generate(context.Background(), nil, nil, nil) {
+		result += v.Result
+		if v.Err != "" {
+			errorCode = v.Err
+		}
+	}
+
+	if result != "Hel" || errorCode != "generation_error" {
+		t.Fatalf(
+			`The first provider's partial answer and error should have been kept, got "%s" "%s"`,
+			result,
+			errorCode,
+		)
+	}
+}
+
+func TestFallbackProviderBillsEmptyAnswer(t *testing.T) {
+	utils.SetLogLevel("WARN")
+
+	// The first provider answers nothing, it's only known to have answered once it ended
+	provider := NewFallbackProvider([]Provider{
+		testProvider{name: "first", results: []options.Result{
+			{TokenUsage: options.TokenUsage{Input: 10}},
+		}},
+		testProvider{name: "second", results: []options.Result{{Result: "Hello"}}},
+	})
+
+	billed := []string{}
+	callback := func(provider, _ string, _, _ int, _ string, _ *int) {
+		billed = append(billed, provider)
+	}
+
+	for range provider.Generate(context.Background(), nil, &callback, nil) {
+	}
+
+	if len(billed) != 1 || billed[0] != "first" {
+		t.Fatalf(`The first provider should have been billed: %v`, billed)
+	}
+}
@@ -5,112 +5,140 @@ import (
 	"errors"
 	"log"
 
-	"github.com/polyfire/api/codegen"
 	database "github.com/polyfire/api/db"
 	"github.com/polyfire/api/llm/providers"
 	"github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/registry"
 	"github.com/polyfire/api/utils"
 	"github.com/tmc/langchaingo/llms/cohere"
 )
 
-var ErrUnknownModel = errors.New("Unknown model")
+var (
+	ErrUnknownModel              = errors.New("Unknown model")
+	ErrInvalidModelConfiguration = errors.New("Invalid model configuration")
+)
 
 type Provider interface {
 	Name() string
 	ProviderModel() (string, string)
 	Generate(
-		prompt string,
+		ctx context.Context,
+		messages []options.Message,
 		c options.ProviderCallback,
 		opts *options.ProviderOptions,
 	) chan options.Result
 	DoesFollowRateLimit() bool
 }
 
-func getAvailableModels(model string) (string, string) {
-	switch model {
-	case "cheap":
-		return "llama", "llama2"
-	case "regular":
-		return "openai", "gpt-3.5-turbo"
-	case "best":
-		return "openai", "gpt-4"
-	case "uncensored":
-		return "replicate", "wizard-mega-13b-awq"
-	case "gpt-3.5-turbo":
-		return "openai", "gpt-3.5-turbo"
-	case "gpt-3.5-turbo-16k":
-		return "openai", "gpt-3.5-turbo"
-	case "gpt-4":
-		return "openai", "gpt-4"
-	case "gpt-4-32k":
-		return "openai", "gpt-4-32k"
-	case "gpt-4o":
-		return "openai", "gpt-4o"
-	case "gpt-4o-mini":
-		return "openai", "gpt-4o-mini"
-	case "gpt-4-turbo":
-		return "openai", "gpt-4-turbo"
-	case "cohere":
-		return "cohere", "cohere_command"
-	case "llama-2-70b-chat":
-		return "replicate", "llama-2-70b-chat"
-	case "replit-code-v1-3b":
-		return "replicate", "replit-code-v1-3b"
-	case "wizard-mega-13b-awq":
-		return "replicate", "wizard-mega-13b-awq"
-	case "airoboros-llama-2-70b":
-		return "replicate", "airoboros-llama-2-70b"
-	case "":
-		return "openai", "gpt-3.5-turbo"
-	}
-	if codegen.IsOpenRouterModel(model) {
-		return "openrouter", model
-	}
-	return "", ""
-}
-
-func getModelWithAliases(
+func getModelsWithAliases(
 	ctx context.Context,
 	modelAlias string,
 	projectID string,
-) (string, string) {
+) []database.Model {
 	db := ctx.Value(utils.ContextKeyDB).(database.Database)
-	provider, model := getAvailableModels(modelAlias)
 
-	if model == "" {
-		newModel, err := db.GetModelByAliasAndProjectID(modelAlias, projectID, "completion")
+	if m, ok := registry.Resolve(modelAlias); ok {
+		return []database.Model{{Provider: m.Provider, Model: m.Model}}
+	}
+
+	models, err := db.GetModelsByAliasAndProjectID(modelAlias, projectID, "completion")
+	if err != nil {
+		return nil
+	}
+
+	return models
+}
+
+func newOpenAICompatibleProvider(ctx context.Context, model database.Model) (Provider, error) {
+	if model.BaseURL == nil || *model.BaseURL == "" {
+		return nil, ErrInvalidModelConfiguration
+	}
+
+	if err := utils.ValidatePublicURL(ctx, *model.BaseURL); err != nil {
+		log.Printf("[WARNING] Rejected the model base URL %s: %v", *model.BaseURL, err)
+		return nil, ErrInvalidModelConfiguration
+	}
+
+	apiKey := ""
+	if model.EncryptedAPIKey != nil && *model.EncryptedAPIKey != "" {
+		var err error
+		apiKey, err = utils.Decrypt(*model.EncryptedAPIKey)
 		if err != nil {
-			return "", ""
+			log.Println("[ERROR] Could not decrypt the model API key: ", err)
+			return nil, ErrInvalidModelConfiguration
 		}
-
-		model = newModel.Model
-		provider = newModel.Provider
 	}
 
-	return provider, model
+	return providers.NewOpenAICompatibleProvider(ctx, model.Model, *model.BaseURL, apiKey), nil
 }
 
 func NewProvider(ctx context.Context, modelInput string) (Provider, error) {
 	projectID, _ := ctx.Value(utils.ContextKeyProjectID).(string)
 	log.Println("[INFO] Project ID: ", projectID)
 
-	provider, model := getModelWithAliases(ctx, modelInput, projectID)
+	targets := getModelsWithAliases(ctx, modelInput, projectID)
+	if len(targets) == 0 {
+		return nil, ErrUnknownModel
+	}
+
+	if len(targets) == 1 {
+		return newProviderFromModel(ctx, targets[0])
+	}
+
+	// The misconfigured entries of a fallback chain are skipped, we only fail if none
+	// of them can be used.
+	var chain []Provider
+	var firstErr error
+	for _, target := range targets {
+		provider, err := newProviderFromModel(ctx, target)
+		if err != nil {
+			log.Printf(
+				"[WARNING] Skipping %s/%s in fallback chain: %v",
+				target.Provider,
+				target.Model,
+				err,
+			)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		chain = append(chain, provider)
+	}
+
+	if len(chain) == 0 {
+		return nil, firstErr
+	}
+
+	return NewFallbackProvider(chain), nil
+}
+
+func newProviderFromModel(ctx context.Context, target database.Model) (Provider, error) {
+	model := target.Model
 
-	log.Println("[INFO] Provider: ", provider)
+	log.Println("[INFO] Provider: ", target.Provider)
 
-	switch provider {
+	switch target.Provider {
 	case "openai":
 		log.Println("[INFO] Using OpenAI")
 		llm := providers.NewOpenAIStreamProvider(ctx, model)
 
 		return llm, nil
+	case "openai-compatible":
+		log.Println("[INFO] Using OpenAI compatible endpoint")
+		return newOpenAICompatibleProvider(ctx, target)
 	case "cohere":
 		log.Println("[INFO] Using Cohere")
 		llm, err := cohere.New()
 		if err != nil {
 			return nil, err
 		}
-		return providers.LangchainProvider{Model: llm, ModelName: "cohere_command"}, nil
+		return providers.LangchainProvider{Model: llm, ModelName: model}, nil
+	case "anthropic":
+		log.Println("[INFO] Using Anthropic")
+		llm := providers.NewAnthropicProvider(ctx, model)
+
+		return llm, nil
 	case "llama":
 		return providers.LLaMaProvider{
 			Model: model,
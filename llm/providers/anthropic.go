@@ -0,0 +1,497 @@
+package providers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/resilience"
+	tokens "github.com/polyfire/api/tokens"
+	utils "github.com/polyfire/api/utils"
+)
+
+const (
+	AnthropicDefaultBaseURL   = "https://api.anthropic.com/v1"
+	AnthropicVersion          = "2023-06-01"
+	AnthropicDefaultMaxTokens = 4096
+	// The tool forced on the model to get an answer following a JSON schema
+	AnthropicJSONSchemaTool = "json_answer"
+)
+
+type AnthropicProvider struct {
+	Model         string
+	APIKey        string
+	BaseURL       string
+	HTTPClient    *http.Client
+	IsCustomToken bool
+}
+
+func NewAnthropicProvider(ctx context.Context, model string) AnthropicProvider {
+	var apiKey string
+	var isCustomToken bool
+
+	customToken, ok := ctx.Value(utils.ContextKeyAnthropicToken).(string)
+	if ok {
+		apiKey = customToken
+		isCustomToken = true
+	} else {
+		apiKey = os.Getenv("ANTHROPIC_API_KEY")
+		isCustomToken = false
+	}
+
+	client := resilience.Client("anthropic")
+	if c, ok := ctx.Value(utils.ContextKeyHTTPClient).(*http.Client); ok {
+		client = resilience.WrapClient("anthropic", c)
+	}
+
+	baseURL := AnthropicDefaultBaseURL
+	if base, ok := ctx.Value(utils.ContextKeyAnthropicBaseURL).(string); ok {
+		baseURL = base
+	}
+
+	return AnthropicProvider{
+		Model:         model,
+		APIKey:        apiKey,
+		BaseURL:       baseURL,
+		HTTPClient:    client,
+		IsCustomToken: isCustomToken,
+	}
+}
+
+type AnthropicContentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	Source    *AnthropicImage `json:"source,omitempty"`
+}
+
+type AnthropicImage struct {
+	Type      string `json:"type"` // Either "base64" or "url"
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+func toAnthropicImage(image string) *AnthropicImage {
+	if mediaType, data, ok := options.ParseDataURL(image); ok {
+		return &AnthropicImage{Type: "base64", MediaType: mediaType, Data: data}
+	}
+	return &AnthropicImage{Type: "url", URL: image}
+}
+
+type AnthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []AnthropicContentBlock `json:"content"`
+}
+
+type AnthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type AnthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type AnthropicRequestBody struct {
+	Model         string               `json:"model"`
+	System        string               `json:"system,omitempty"`
+	Messages      []AnthropicMessage   `json:"messages"`
+	MaxTokens     int                  `json:"max_tokens"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Temperature   *float32             `json:"temperature,omitempty"`
+	TopP          *float32             `json:"top_p,omitempty"`
+	Tools         []AnthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *AnthropicToolChoice `json:"tool_choice,omitempty"`
+	Stream        bool                 `json:"stream"`
+}
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type AnthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type AnthropicEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		Usage AnthropicUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock AnthropicContentBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	Usage AnthropicUsage `json:"usage"`
+	Error AnthropicError `json:"error"`
+}
+
+func toAnthropicContentBlocks(m options.Message) []AnthropicContentBlock {
+	if m.Role == options.RoleTool {
+		return []AnthropicContentBlock{
+			{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content},
+		}
+	}
+
+	// Anthropic recommends putting the images before the text they're about
+	var blocks []AnthropicContentBlock
+	for _, image := range m.Images {
+		blocks = append(
+			blocks,
+			AnthropicContentBlock{Type: "image", Source: toAnthropicImage(image)},
+		)
+	}
+
+	if strings.TrimSpace(m.Content) != "" {
+		blocks = append(blocks, AnthropicContentBlock{Type: "text", Text: m.Content})
+	}
+
+	for _, toolCall := range m.ToolCalls {
+		input := json.RawMessage(toolCall.Function.Arguments)
+		if !json.Valid(input) {
+			input = json.RawMessage("{}")
+		}
+		blocks = append(blocks, AnthropicContentBlock{
+			Type:  "tool_use",
+			ID:    toolCall.ID,
+			Name:  toolCall.Function.Name,
+			Input: input,
+		})
+	}
+
+	return blocks
+}
+
+// Anthropic takes the system prompt apart from the messages and requires the user and
+// assistant roles to alternate, consecutive messages with the same role are merged.
+// Tool results are sent back as user messages.
+func toAnthropicMessages(messages []options.Message) (string, []AnthropicMessage) {
+	system := ""
+	result := make([]AnthropicMessage, 0, len(messages))
+
+	for _, m := range messages {
+		if m.Role == options.RoleSystem {
+			system += m.Content + "\n"
+			continue
+		}
+
+		role := string(m.Role)
+		if m.Role == options.RoleTool {
+			role = string(options.RoleUser)
+		}
+
+		blocks := toAnthropicContentBlocks(m)
+		if len(blocks) == 0 {
+			continue
+		}
+
+		if len(result) > 0 && result[len(result)-1].Role == role {
+			result[len(result)-1].Content = append(result[len(result)-1].Content, blocks...)
+			continue
+		}
+
+		result = append(result, AnthropicMessage{Role: role, Content: blocks})
+	}
+
+	return strings.TrimSpace(system), result
+}
+
+func toAnthropicTools(tools []options.Tool) []AnthropicTool {
+	result := make([]AnthropicTool, len(tools))
+	for i, tool := range tools {
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object"}`)
+		}
+		result[i] = AnthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		}
+	}
+	return result
+}
+
+// Converts the OpenAI style tool_choice to the Anthropic one. "none" has no equivalent,
+// the tools are not sent at all in this case.
+func toAnthropicToolChoice(toolChoice interface{}) *AnthropicToolChoice {
+	switch choice := toolChoice.(type) {
+	case string:
+		switch choice {
+		case "auto":
+			return &AnthropicToolChoice{Type: "auto"}
+		case "required":
+			return &AnthropicToolChoice{Type: "any"}
+		}
+	case map[string]interface{}:
+		if function, ok := choice["function"].(map[string]interface{}); ok {
+			if name, ok := function["name"].(string); ok {
+				return &AnthropicToolChoice{Type: "tool", Name: name}
+			}
+		}
+	}
+	return nil
+}
+
+// Anthropic has no structured output but a forced tool call follows the schema of the tool
+// input, which must be an object.
+func toAnthropicJSONSchemaTool(schema json.RawMessage) *AnthropicTool {
+	var s struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(schema, &s); err != nil || s.Type != "object" {
+		return nil
+	}
+
+	return &AnthropicTool{
+		Name:        AnthropicJSONSchemaTool,
+		Description: "Give the answer",
+		InputSchema: schema,
+	}
+}
+
+func (m AnthropicProvider) start(
+	ctx context.Context,
+	body AnthropicRequestBody,
+) (*http.Response, string) {
+	input, err := json.Marshal(body)
+	if err != nil {
+		return nil, "generation_error"
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		m.BaseURL+"/messages",
+		strings.NewReader(string(input)),
+	)
+	if err != nil {
+		return nil, "generation_error"
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", m.APIKey)
+	req.Header.Set("anthropic-version", AnthropicVersion)
+
+	resp, err := m.HTTPClient.Do(req)
+	if err != nil {
+		log.Printf("[ERROR] Anthropic request failed: %v", err)
+		return nil, "generation_error"
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		var errorResponse struct {
+			Error AnthropicError `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&errorResponse)
+		log.Printf("[ERROR] Anthropic error %d: %v", resp.StatusCode, errorResponse.Error)
+
+		if errorResponse.Error.Type == "authentication_error" && m.IsCustomToken {
+			return nil, "anthropic_invalid_api_key"
+		}
+
+		return nil, "generation_error"
+	}
+
+	return resp, ""
+}
+
+func (m AnthropicProvider) Generate(
+	ctx context.Context,
+	messages []options.Message,
+	c options.ProviderCallback,
+	opts *options.ProviderOptions,
+) chan options.Result {
+	chanRes := make(chan options.Result)
+
+	go func() {
+		defer close(chanRes)
+
+		if opts == nil {
+			opts = &options.ProviderOptions{}
+		}
+
+		system, anthropicMessages := toAnthropicMessages(messages)
+
+		body := AnthropicRequestBody{
+			Model:       m.Model,
+			System:      system,
+			Messages:    anthropicMessages,
+			MaxTokens:   AnthropicDefaultMaxTokens,
+			Temperature: opts.Temperature,
+			TopP:        opts.TopP,
+			Stream:      true,
+		}
+
+		if opts.MaxTokens != nil {
+			body.MaxTokens = *opts.MaxTokens
+		}
+
+		if opts.StopWords != nil {
+			body.StopSequences = *opts.StopWords
+		}
+
+		if len(opts.Tools) > 0 && opts.ToolChoice != "none" {
+			body.Tools = toAnthropicTools(opts.Tools)
+			body.ToolChoice = toAnthropicToolChoice(opts.ToolChoice)
+		}
+
+		// The input of the tool call is then sent as the answer
+		jsonSchemaTool := toAnthropicJSONSchemaTool(opts.JSONSchema)
+		if jsonSchemaTool != nil && len(body.Tools) == 0 {
+			body.Tools = []AnthropicTool{*jsonSchemaTool}
+			body.ToolChoice = &AnthropicToolChoice{Type: "tool", Name: AnthropicJSONSchemaTool}
+		}
+		answerIndex := -1
+
+		warnings := opts.UnsupportedOptions(
+			options.OptionTools,
+			options.OptionMaxTokens,
+			options.OptionTopP,
+		)
+		if opts.JSONFormat {
+			warnings = append(
+				warnings,
+				"JSON format is not supported by Anthropic models and has been ignored.",
+			)
+		}
+
+		resp, errorCode := m.start(ctx, body)
+		if errorCode != "" {
+			chanRes <- options.Result{Err: errorCode}
+			return
+		}
+		defer resp.Body.Close()
+
+		usage := AnthropicUsage{}
+		totalCompletion := ""
+		totalToolCalls := ""
+
+		// Anthropic indexes the tool calls with the other content blocks, we renumber them
+		toolCallIndexes := map[int]int{}
+
+		scanner := bufio.NewScanner(resp.Body)
+	events:
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+
+			var event AnthropicEvent
+			if err := json.Unmarshal([]byte(line[6:]), &event); err != nil {
+				log.Printf("[ERROR] Invalid Anthropic event: %v", err)
+				continue
+			}
+
+			switch event.Type {
+			case "message_start":
+				usage.InputTokens = event.Message.Usage.InputTokens
+				chanRes <- options.Result{
+					TokenUsage: options.TokenUsage{Input: usage.InputTokens},
+					Warnings:   warnings,
+				}
+			case "content_block_start":
+				if event.ContentBlock.Type != "tool_use" {
+					continue
+				}
+				if jsonSchemaTool != nil && event.ContentBlock.Name == AnthropicJSONSchemaTool {
+					answerIndex = event.Index
+					continue
+				}
+				index := len(toolCallIndexes)
+				toolCallIndexes[event.Index] = index
+				totalToolCalls += event.ContentBlock.Name
+				chanRes <- options.Result{ToolCalls: []options.ToolCall{{
+					Index:    index,
+					ID:       event.ContentBlock.ID,
+					Type:     "function",
+					Function: options.ToolCallFunction{Name: event.ContentBlock.Name},
+				}}}
+			case "content_block_delta":
+				switch event.Delta.Type {
+				case "text_delta":
+					totalCompletion += event.Delta.Text
+					chanRes <- options.Result{Result: event.Delta.Text}
+				case "input_json_delta":
+					if event.Index == answerIndex {
+						totalCompletion += event.Delta.PartialJSON
+						chanRes <- options.Result{Result: event.Delta.PartialJSON}
+						continue
+					}
+					index, ok := toolCallIndexes[event.Index]
+					if !ok {
+						continue
+					}
+					totalToolCalls += event.Delta.PartialJSON
+					chanRes <- options.Result{ToolCalls: []options.ToolCall{{
+						Index:    index,
+						Function: options.ToolCallFunction{Arguments: event.Delta.PartialJSON},
+					}}}
+				}
+			case "message_delta":
+				usage.OutputTokens = event.Usage.OutputTokens
+			case "error":
+				log.Printf("[ERROR] Anthropic stream error: %v", event.Error)
+				chanRes <- options.Result{Err: "generation_error"}
+				break events
+			}
+		}
+
+		// The usage reported by Anthropic is the one we're billed on, we only count
+		// the tokens ourselves if the stream has been interrupted before it was sent,
+		// by the client or by an error event.
+		if usage.InputTokens == 0 {
+			usage.InputTokens = tokens.CountTokens(options.FlattenMessages(messages, false))
+		}
+		if usage.OutputTokens == 0 {
+			usage.OutputTokens = tokens.CountTokens(totalCompletion + totalToolCalls)
+		}
+
+		chanRes <- options.Result{TokenUsage: options.TokenUsage{Output: usage.OutputTokens}}
+
+		if c != nil {
+			(*c)(
+				"anthropic",
+				m.Model,
+				usage.InputTokens,
+				usage.OutputTokens,
+				totalCompletion,
+				nil,
+			)
+		}
+	}()
+
+	return chanRes
+}
+
+func (m AnthropicProvider) Name() string {
+	return "anthropic"
+}
+
+func (m AnthropicProvider) ProviderModel() (string, string) {
+	return "anthropic", m.Model
+}
+
+func (m AnthropicProvider) DoesFollowRateLimit() bool {
+	return !m.IsCustomToken
+}
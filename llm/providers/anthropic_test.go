@@ -0,0 +1,118 @@
+package providers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/utils"
+)
+
+func TestAnthropicProvider(t *testing.T) {
+	utils.SetLogLevel("WARN")
+	ctx := utils.MockAnthropicServer(context.Background())
+	messages := []options.Message{{Role: options.RoleUser, Content: "Test"}}
+	result := NewAnthropicProvider(ctx, "test-model").Generate(ctx, messages, nil, nil)
+
+	str := ""
+	outputTokens := 0
+
+	for v := range result {
+		str += v.Result
+		outputTokens += v.TokenUsage.Output
+	}
+
+	if str != "Test response" {
+		t.Fatalf(`Generate("Test") should have returned "Test response" but returned "%s"`, str)
+	}
+
+	if outputTokens != 2 {
+		t.Fatalf(`Generate("Test") should have reported 2 output tokens but reported %d`, outputTokens)
+	}
+}
+
+func TestAnthropicProviderToolCalls(t *testing.T) {
+	utils.SetLogLevel("WARN")
+	ctx := utils.MockAnthropicServer(context.Background())
+	messages := []options.Message{{Role: options.RoleUser, Content: "Weather in Paris?"}}
+	opts := options.ProviderOptions{
+		Tools: []options.Tool{{
+			Type: "function",
+			Function: options.ToolFunction{
+				Name:       "get_weather",
+				Parameters: []byte(`{"type":"object","properties":{"city":{"type":"string"}}}`),
+			},
+		}},
+	}
+	result := NewAnthropicProvider(ctx, "test-model").Generate(ctx, messages, nil, &opts)
+
+	var toolCalls []options.ToolCall
+	for v := range result {
+		toolCalls = options.MergeToolCalls(toolCalls, v.ToolCalls)
+	}
+
+	if len(toolCalls) != 1 {
+		t.Fatalf(`Generate should have returned 1 tool call but returned %d`, len(toolCalls))
+	}
+
+	if toolCalls[0].ID != "toolu_mock" || toolCalls[0].Function.Name != "get_weather" {
+		t.Fatalf(`Unexpected tool call: %v`, toolCalls[0])
+	}
+
+	if toolCalls[0].Function.Arguments != `{"city": "Paris"}` {
+		t.Fatalf(`Unexpected tool call arguments: %s`, toolCalls[0].Function.Arguments)
+	}
+}
+
+func TestAnthropicProviderUnsupportedOptions(t *testing.T) {
+	utils.SetLogLevel("WARN")
+	ctx := utils.MockAnthropicServer(context.Background())
+	messages := []options.Message{{Role: options.RoleUser, Content: "Test"}}
+	maxTokens := 16
+	seed := 42
+	opts := options.ProviderOptions{MaxTokens: &maxTokens, Seed: &seed}
+	result := NewAnthropicProvider(ctx, "test-model").Generate(ctx, messages, nil, &opts)
+
+	var warnings []string
+	for v := range result {
+		warnings = append(warnings, v.Warnings...)
+	}
+
+	expected := "The seed option is not supported by this model and has been ignored."
+	if len(warnings) != 1 || warnings[0] != expected {
+		t.Fatalf(`Only the seed should have been reported as unsupported, got %v`, warnings)
+	}
+}
+
+func TestAnthropicImages(t *testing.T) {
+	// A 1x1 png
+	png := "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg=="
+	dataURL, err := options.NormalizeImage(png)
+	if err != nil || dataURL != "data:image/png;base64,"+png {
+		t.Fatalf(`NormalizeImage should have returned a png data URL: "%s", %v`, dataURL, err)
+	}
+
+	_, messages := toAnthropicMessages([]options.Message{{
+		Role:    options.RoleUser,
+		Content: "What's on these images?",
+		Images:  []string{dataURL, "https://example.com/image.jpg"},
+	}})
+
+	blocks := messages[0].Content
+	if len(blocks) != 3 || blocks[2].Type != "text" {
+		t.Fatalf(`The images should have been sent before the text: %v`, blocks)
+	}
+
+	if blocks[0].Source.Type != "base64" || blocks[0].Source.MediaType != "image/png" ||
+		blocks[0].Source.Data != png {
+		t.Fatalf(`Unexpected base64 image source: %v`, blocks[0].Source)
+	}
+
+	if blocks[1].Source.Type != "url" || blocks[1].Source.URL != "https://example.com/image.jpg" {
+		t.Fatalf(`Unexpected url image source: %v`, blocks[1].Source)
+	}
+
+	if _, err := options.NormalizeImage("not an image"); err == nil {
+		t.Fatal(`NormalizeImage("not an image") should have failed`)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 
 	"github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/resilience"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/schema"
 )
@@ -14,8 +15,26 @@ type LangchainProvider struct {
 	ModelName string
 }
 
-func (m LangchainProvider) Call(prompt string, opts *options.ProviderOptions) (string, error) {
-	ctx := context.Background()
+func toLangchainMessages(messages []options.Message) []schema.ChatMessage {
+	result := make([]schema.ChatMessage, len(messages))
+	for i, m := range messages {
+		switch m.Role {
+		case options.RoleSystem:
+			result[i] = schema.SystemChatMessage{Content: m.Content}
+		case options.RoleAssistant:
+			result[i] = schema.AIChatMessage{Content: m.Content}
+		default:
+			result[i] = schema.HumanChatMessage{Content: m.Content}
+		}
+	}
+	return result
+}
+
+func (m LangchainProvider) Call(
+	ctx context.Context,
+	messages []options.Message,
+	opts *options.ProviderOptions,
+) (string, error) {
 	var result string
 	var err error
 
@@ -23,20 +42,27 @@ func (m LangchainProvider) Call(prompt string, opts *options.ProviderOptions) (s
 		opts = &options.ProviderOptions{}
 	}
 
-	options := llms.CallOptions{}
-
-	if opts.StopWords != nil {
-		options.StopWords = *opts.StopWords
-	}
+	callOptions := llms.CallOptions{}
 
 	if llm, ok := m.Model.(llms.LLM); ok {
-		result, err = llm.Call(ctx, prompt, llms.WithOptions(options))
+		if stopWords := options.FlattenedStopWords(messages, opts); stopWords != nil {
+			callOptions.StopWords = *stopWords
+		}
+		prompt := options.FlattenMessages(messages, opts.AutoComplete)
+		result, err = llm.Call(ctx, prompt, llms.WithOptions(callOptions))
 	} else if chat, ok := m.Model.(llms.ChatLLM); ok {
+		if opts.StopWords != nil {
+			callOptions.StopWords = *opts.StopWords
+		}
 		var chatMessage *schema.AIChatMessage
-		chatMessage, err = chat.Call(ctx, []schema.ChatMessage{
-			schema.HumanChatMessage{Content: prompt},
-		}, llms.WithOptions(options))
-		result = chatMessage.Content
+		chatMessage, err = chat.Call(
+			ctx,
+			toLangchainMessages(messages),
+			llms.WithOptions(callOptions),
+		)
+		if err == nil {
+			result = chatMessage.Content
+		}
 	} else {
 		return "", errors.New("Model is neither LLM nor Chat")
 	}
@@ -49,7 +75,8 @@ func (m LangchainProvider) Call(prompt string, opts *options.ProviderOptions) (s
 }
 
 func (m LangchainProvider) Generate(
-	task string,
+	ctx context.Context,
+	messages []options.Message,
 	c options.ProviderCallback,
 	opts *options.ProviderOptions,
 ) chan options.Result {
@@ -58,8 +85,14 @@ func (m LangchainProvider) Generate(
 	go func(chanRes chan options.Result) {
 		defer close(chanRes)
 		tokenUsage := options.TokenUsage{Input: 0, Output: 0}
-		inputPrompt := task
-		completion, err := m.Call(inputPrompt, opts)
+		inputPrompt := options.FlattenMessages(messages, false)
+		// langchaingo doesn't let us give it an http.Client, the calls are retried around it
+		var completion string
+		err := resilience.Call(ctx, "cohere", func() error {
+			var err error
+			completion, err = m.Call(ctx, messages, opts)
+			return err
+		})
 		if err != nil {
 			chanRes <- options.Result{Err: "generation_error"}
 			return
@@ -84,7 +117,7 @@ func (m LangchainProvider) Generate(
 		chanRes <- result
 	}(chanRes)
 
-	return chanRes
+	return options.UnsupportedOptionsWarning(opts, chanRes)
 }
 
 func (m LangchainProvider) Name() string {
@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/resilience"
 	tokens "github.com/polyfire/api/tokens"
 )
 
@@ -19,12 +21,15 @@ type LLaMaInputBody struct {
 	Temperature *float32 `json:"temperature"`
 }
 
+var llamaHTTPClient = resilience.Client("llama")
+
 type LLaMaProvider struct {
 	Model string
 }
 
 func (m LLaMaProvider) Generate(
-	task string,
+	ctx context.Context,
+	messages []options.Message,
 	c options.ProviderCallback,
 	opts *options.ProviderOptions,
 ) chan options.Result {
@@ -33,6 +38,8 @@ func (m LLaMaProvider) Generate(
 	go func() {
 		defer close(chanRes)
 		tokenUsage := options.TokenUsage{Input: 0, Output: 0}
+		autoComplete := opts != nil && opts.AutoComplete
+		task := options.FlattenMessages(messages, autoComplete)
 		body := LLaMaInputBody{Prompt: task, Model: m.Model}
 		if opts != nil && opts.Temperature != nil {
 			body.Temperature = opts.Temperature
@@ -46,35 +53,45 @@ func (m LLaMaProvider) Generate(
 		}
 		reqBody := string(input)
 		fmt.Println(reqBody)
-		resp, err := http.Post(
+		req, err := http.NewRequestWithContext(
+			ctx,
+			"POST",
 			os.Getenv("LLAMA_URL"),
-			"application/json",
 			strings.NewReader(reqBody),
 		)
 		if err != nil {
 			chanRes <- options.Result{Err: "generation_error"}
 			return
 		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := llamaHTTPClient.Do(req)
+		if err != nil {
+			chanRes <- options.Result{Err: "generation_error"}
+			return
+		}
 		defer resp.Body.Close()
 		p := make([]byte, 128)
-		totalOutput := 0
 		totalCompletion := ""
 		for {
 			nb, err := resp.Body.Read(p)
 			if errors.Is(err, io.EOF) || err != nil {
 				break
 			}
-			tokenUsage.Output = tokens.CountTokens(string(p[:nb]))
-			totalOutput += tokenUsage.Output
 			totalCompletion += string(p[:nb])
-			chanRes <- options.Result{Result: string(p[:nb]), TokenUsage: tokenUsage}
+			chanRes <- options.Result{Result: string(p[:nb])}
 		}
+
+		// Our llama server doesn't report its usage. The completion is counted as a whole
+		// since the chunks can split the tokens.
+		tokenUsage.Output = tokens.CountTokens(totalCompletion)
+		chanRes <- options.Result{TokenUsage: tokenUsage}
+
 		if c != nil {
-			(*c)("llama", m.Model, tokenUsage.Input, totalOutput, totalCompletion, nil)
+			(*c)("llama", m.Model, tokenUsage.Input, tokenUsage.Output, totalCompletion, nil)
 		}
 	}()
 
-	return chanRes
+	return options.UnsupportedOptionsWarning(opts, chanRes)
 }
 
 func (m LLaMaProvider) Name() string {
@@ -2,6 +2,7 @@ package providers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -11,6 +12,8 @@ import (
 	"strings"
 
 	"github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/registry"
+	"github.com/polyfire/api/resilience"
 	tokens "github.com/polyfire/api/tokens"
 	utils "github.com/polyfire/api/utils"
 	goOpenai "github.com/sashabaranov/go-openai"
@@ -41,8 +44,9 @@ func NewOpenAIStreamProvider(ctx context.Context, model string) OpenAIStreamProv
 		isCustomToken = false
 	}
 
+	config.HTTPClient = resilience.Client("openai")
 	if client, ok := ctx.Value(utils.ContextKeyHTTPClient).(*http.Client); ok {
-		config.HTTPClient = client
+		config.HTTPClient = resilience.WrapClient("openai", client)
 	}
 
 	if base, ok := ctx.Value(utils.ContextKeyOpenAIBaseURL).(string); ok {
@@ -57,8 +61,129 @@ func NewOpenAIStreamProvider(ctx context.Context, model string) OpenAIStreamProv
 	}
 }
 
+func toOpenAIMessages(messages []options.Message) []goOpenai.ChatCompletionMessage {
+	result := make([]goOpenai.ChatCompletionMessage, len(messages))
+	for i, m := range messages {
+		result[i] = goOpenai.ChatCompletionMessage{
+			Role:       string(m.Role),
+			Content:    m.Content,
+			ToolCallID: m.ToolCallID,
+		}
+		// The content can't be both a string and a list of parts
+		if len(m.Images) > 0 {
+			result[i].Content = ""
+			result[i].MultiContent = []goOpenai.ChatMessagePart{
+				{Type: goOpenai.ChatMessagePartTypeText, Text: m.Content},
+			}
+			for _, image := range m.Images {
+				result[i].MultiContent = append(result[i].MultiContent, goOpenai.ChatMessagePart{
+					Type:     goOpenai.ChatMessagePartTypeImageURL,
+					ImageURL: &goOpenai.ChatMessageImageURL{URL: image},
+				})
+			}
+		}
+		for _, toolCall := range m.ToolCalls {
+			result[i].ToolCalls = append(result[i].ToolCalls, goOpenai.ToolCall{
+				ID:   toolCall.ID,
+				Type: goOpenai.ToolTypeFunction,
+				Function: goOpenai.FunctionCall{
+					Name:      toolCall.Function.Name,
+					Arguments: toolCall.Function.Arguments,
+				},
+			})
+		}
+	}
+	return result
+}
+
+func toOpenAITools(tools []options.Tool) []goOpenai.Tool {
+	result := make([]goOpenai.Tool, len(tools))
+	for i, tool := range tools {
+		result[i] = goOpenai.Tool{
+			Type: goOpenai.ToolTypeFunction,
+			Function: &goOpenai.FunctionDefinition{
+				Name:        tool.Function.Name,
+				Description: tool.Function.Description,
+				Parameters:  tool.Function.Parameters,
+			},
+		}
+	}
+	return result
+}
+
+func fromOpenAIToolCalls(toolCalls []goOpenai.ToolCall) []options.ToolCall {
+	result := make([]options.ToolCall, len(toolCalls))
+	for i, toolCall := range toolCalls {
+		index := i
+		if toolCall.Index != nil {
+			index = *toolCall.Index
+		}
+		result[i] = options.ToolCall{
+			Index: index,
+			ID:    toolCall.ID,
+			Type:  string(toolCall.Type),
+			Function: options.ToolCallFunction{
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			},
+		}
+	}
+	return result
+}
+
+func fromOpenAILogprobs(logprobs []goOpenai.ChatCompletionTokenLogprob) []options.TokenLogprob {
+	result := make([]options.TokenLogprob, len(logprobs))
+	for i, logprob := range logprobs {
+		result[i] = options.TokenLogprob{Token: logprob.Token, Logprob: logprob.Logprob}
+		for _, top := range logprob.TopLogprobs {
+			result[i].TopLogprobs = append(
+				result[i].TopLogprobs,
+				options.TopLogprob{Token: top.Token, Logprob: top.Logprob},
+			)
+		}
+	}
+	return result
+}
+
+// The models without structured output still get the JSON mode when they have it, the
+// schema itself is given in the prompt and the answer validated afterwards.
+func openAIJSONSchemaFormat(
+	provider string,
+	model string,
+	schema json.RawMessage,
+) *goOpenai.ChatCompletionResponseFormat {
+	m, _ := registry.Find(provider, model)
+
+	switch {
+	case m.Capabilities.JSONSchema:
+		return &goOpenai.ChatCompletionResponseFormat{
+			Type: goOpenai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &goOpenai.ChatCompletionResponseFormatJSONSchema{
+				Name:   "response",
+				Schema: schema,
+			},
+		}
+	case m.Capabilities.JSON:
+		return &goOpenai.ChatCompletionResponseFormat{
+			Type: goOpenai.ChatCompletionResponseFormatTypeJSONObject,
+		}
+	}
+
+	return nil
+}
+
+func mentionsJSON(messages []options.Message) bool {
+	for _, m := range messages {
+		if strings.Contains(strings.ToLower(m.Content), "json") {
+			return true
+		}
+	}
+	return false
+}
+
 func (m OpenAIStreamProvider) Generate(
-	task string,
+	ctx context.Context,
+	messages []options.Message,
 	c options.ProviderCallback,
 	opts *options.ProviderOptions,
 ) chan options.Result {
@@ -67,26 +192,24 @@ func (m OpenAIStreamProvider) Generate(
 	go func() {
 		defer close(chanRes)
 		tokenUsage := options.TokenUsage{Input: 0, Output: 0}
-		ctx := context.Background()
 
 		if opts == nil {
 			opts = &options.ProviderOptions{}
 		}
 
 		req := goOpenai.ChatCompletionRequest{
-			Model: m.Model,
-			Messages: []goOpenai.ChatCompletionMessage{
-				{
-					Role:    goOpenai.ChatMessageRoleUser,
-					Content: task,
-				},
-			},
-			Stream: true,
+			Model:    m.Model,
+			Messages: toOpenAIMessages(messages),
+			Stream:   true,
+			// The usage is sent in a last chunk without any choice
+			StreamOptions: &goOpenai.StreamOptions{IncludeUsage: true},
 		}
 
-		if opts.JSONFormat {
-			// The OpenAI api requires the message to mention the word json
-			if !strings.Contains(strings.ToLower(task), "json") {
+		if len(opts.JSONSchema) > 0 {
+			req.ResponseFormat = openAIJSONSchemaFormat(m.Provider, m.Model, opts.JSONSchema)
+		} else if opts.JSONFormat {
+			// The OpenAI api requires the messages to mention the word json
+			if !mentionsJSON(messages) {
 				chanRes <- options.Result{Err: "json_format_must_mention_json"}
 				return
 			}
@@ -98,6 +221,10 @@ func (m OpenAIStreamProvider) Generate(
 		if opts.StopWords != nil {
 			req.Stop = *opts.StopWords
 		}
+		if len(opts.Tools) > 0 {
+			req.Tools = toOpenAITools(opts.Tools)
+			req.ToolChoice = opts.ToolChoice
+		}
 		if opts.Temperature != nil {
 			if *opts.Temperature == 0.0 {
 				var nearlyZero float32 = math.SmallestNonzeroFloat32
@@ -107,6 +234,30 @@ func (m OpenAIStreamProvider) Generate(
 			}
 		}
 
+		if opts.MaxTokens != nil {
+			req.MaxTokens = *opts.MaxTokens
+		}
+		if opts.TopP != nil {
+			req.TopP = *opts.TopP
+		}
+		if opts.PresencePenalty != nil {
+			req.PresencePenalty = *opts.PresencePenalty
+		}
+		if opts.FrequencyPenalty != nil {
+			req.FrequencyPenalty = *opts.FrequencyPenalty
+		}
+		req.Seed = opts.Seed
+		req.LogitBias = opts.LogitBias
+		if opts.N > 1 {
+			req.N = opts.N
+		}
+		if opts.Logprobs || opts.TopLogprobs != nil {
+			req.LogProbs = true
+			if opts.TopLogprobs != nil {
+				req.TopLogProbs = *opts.TopLogprobs
+			}
+		}
+
 		stream, err := m.Client.CreateChatCompletionStream(ctx, req)
 		if err != nil && strings.Contains(err.Error(), "Incorrect API key provided") &&
 			m.IsCustomToken {
@@ -118,10 +269,10 @@ func (m OpenAIStreamProvider) Generate(
 			return
 		}
 
-		tokenUsage.Input += tokens.CountTokens(task)
-
-		totalOutput := 0
-		totalCompletion := ""
+		var usage *goOpenai.Usage
+		// The completion of each candidate, the first one is the one stored in the chats
+		completions := []string{""}
+		totalToolCalls := ""
 
 		for {
 			completion, err := stream.Recv()
@@ -130,25 +281,54 @@ func (m OpenAIStreamProvider) Generate(
 				break
 			}
 
-			if len(completion.Choices) == 0 {
-				continue
+			if completion.Usage != nil {
+				usage = completion.Usage
 			}
 
-			tokenUsage.Output = tokens.CountTokens(completion.Choices[0].Delta.Content)
+			for _, choice := range completion.Choices {
+				delta := choice.Delta
 
-			totalOutput += tokenUsage.Output
+				result := options.Result{
+					Result: delta.Content,
+					Index:  choice.Index,
+				}
 
-			result := options.Result{
-				Result:     completion.Choices[0].Delta.Content,
-				TokenUsage: tokenUsage,
-			}
+				if len(delta.ToolCalls) > 0 {
+					result.ToolCalls = fromOpenAIToolCalls(delta.ToolCalls)
+					for _, toolCall := range delta.ToolCalls {
+						totalToolCalls += toolCall.Function.Name + toolCall.Function.Arguments
+					}
+				}
 
-			totalCompletion += completion.Choices[0].Delta.Content
+				if choice.Logprobs != nil {
+					result.Logprobs = fromOpenAILogprobs(choice.Logprobs.Content)
+				}
 
-			chanRes <- result
+				for len(completions) <= choice.Index {
+					completions = append(completions, "")
+				}
+				completions[choice.Index] += delta.Content
+
+				chanRes <- result
+			}
+		}
+
+		// The usage reported by the provider is the one we're billed on, we only count the
+		// tokens ourselves when it's missing (interrupted stream, compatible endpoints
+		// that don't support stream_options...)
+		if usage != nil {
+			tokenUsage.Input = usage.PromptTokens
+			tokenUsage.Output = usage.CompletionTokens
+		} else {
+			tokenUsage.Input = tokens.CountTokens(options.FlattenMessages(messages, false)) +
+				options.CountImages(messages)*options.ImageTokenEstimate
+			tokenUsage.Output = tokens.CountTokens(strings.Join(completions, "") + totalToolCalls)
 		}
+
+		chanRes <- options.Result{TokenUsage: tokenUsage}
+
 		if c != nil {
-			(*c)(m.Provider, m.Model, tokenUsage.Input, totalOutput, totalCompletion, nil)
+			(*c)(m.Provider, m.Model, tokenUsage.Input, tokenUsage.Output, completions[0], nil)
 		}
 	}()
 
@@ -0,0 +1,43 @@
+package providers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/polyfire/api/resilience"
+	utils "github.com/polyfire/api/utils"
+	goOpenai "github.com/sashabaranov/go-openai"
+)
+
+/*
+ * Projects can register their own OpenAI compatible endpoints (vLLM, Ollama, LM Studio...)
+ * in the models table. Like OpenRouter, we just reuse the OpenAI client with a different
+ * baseURL. The endpoint is hosted by the project so it's never billed in credits.
+ */
+
+func NewOpenAICompatibleProvider(
+	ctx context.Context,
+	model string,
+	baseURL string,
+	apiKey string,
+) OpenAIStreamProvider {
+	config := goOpenai.DefaultConfig(apiKey)
+	config.BaseURL = baseURL
+
+	// Each endpoint gets its own circuit breaker. The endpoint is chosen by the project so
+	// it must not be able to reach our internal services.
+	breakerName := "openai-compatible:" + baseURL
+	config.HTTPClient = &http.Client{
+		Transport: resilience.NewTransport(breakerName, utils.PublicHTTPTransport()),
+	}
+	if client, ok := ctx.Value(utils.ContextKeyHTTPClient).(*http.Client); ok {
+		config.HTTPClient = resilience.WrapClient(breakerName, client)
+	}
+
+	return OpenAIStreamProvider{
+		Client:        *goOpenai.NewClientWithConfig(config),
+		Model:         model,
+		IsCustomToken: true,
+		Provider:      "openai-compatible",
+	}
+}
@@ -4,21 +4,51 @@ import (
 	"context"
 	"testing"
 
+	"github.com/polyfire/api/llm/providers/options"
 	"github.com/polyfire/api/utils"
 )
 
 func TestOpenAIProvider(t *testing.T) {
 	utils.SetLogLevel("WARN")
 	ctx := utils.MockOpenAIServer(context.Background())
-	result := NewOpenAIStreamProvider(ctx, "test-model").Generate("Test", nil, nil)
+	messages := []options.Message{{Role: options.RoleUser, Content: "Test"}}
+	result := NewOpenAIStreamProvider(ctx, "test-model").Generate(ctx, messages, nil, nil)
 
 	str := ""
+	tokenUsage := options.TokenUsage{}
 
 	for v := range result {
 		str += v.Result
+		tokenUsage.Input += v.TokenUsage.Input
+		tokenUsage.Output += v.TokenUsage.Output
 	}
 
 	if str != "Test response" {
 		t.Fatalf(`Generate("Test") should have returned "Test response" but returned "%s"`, str)
 	}
+
+	if tokenUsage.Input != 8 || tokenUsage.Output != 2 {
+		t.Fatalf(`Generate("Test") should have reported the usage sent by the provider: %v`, tokenUsage)
+	}
+}
+
+func TestOpenAIProviderCandidates(t *testing.T) {
+	utils.SetLogLevel("WARN")
+	ctx := utils.MockOpenAIServer(context.Background())
+	messages := []options.Message{{Role: options.RoleUser, Content: "Test"}}
+	opts := options.ProviderOptions{N: 2, Logprobs: true}
+	result := NewOpenAIStreamProvider(ctx, "test-model").Generate(ctx, messages, nil, &opts)
+
+	var candidates []options.Candidate
+	for v := range result {
+		candidates = options.MergeCandidate(candidates, v)
+	}
+
+	if len(candidates) != 2 || candidates[0].Result != "Yes" || candidates[1].Result != "No" {
+		t.Fatalf(`Generate should have returned the candidates "Yes" and "No": %v`, candidates)
+	}
+
+	if len(candidates[1].Logprobs) != 1 || candidates[1].Logprobs[0].Logprob != -2.3 {
+		t.Fatalf(`Unexpected logprobs for the second candidate: %v`, candidates[1].Logprobs)
+	}
 }
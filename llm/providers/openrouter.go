@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 
+	"github.com/polyfire/api/resilience"
 	goOpenai "github.com/sashabaranov/go-openai"
 )
 
@@ -19,6 +20,7 @@ func NewOpenRouterProvider(_ context.Context, model string) OpenAIStreamProvider
 	config = goOpenai.DefaultConfig(os.Getenv("OPENROUTER_API_KEY"))
 	isCustomToken = false
 	config.BaseURL = "https://openrouter.ai/api/v1"
+	config.HTTPClient = resilience.Client("openrouter")
 
 	return OpenAIStreamProvider{
 		Client:        *goOpenai.NewClientWithConfig(config),
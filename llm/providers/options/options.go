@@ -1,16 +1,334 @@
 package options
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
 
 	"github.com/polyfire/api/db"
 	"github.com/polyfire/api/utils"
 )
 
 type ProviderOptions struct {
-	StopWords   *[]string
-	Temperature *float32
-	JSONFormat  bool
+	StopWords    *[]string
+	Temperature  *float32
+	JSONFormat   bool
+	JSONSchema   json.RawMessage // The providers with a structured output enforce it
+	AutoComplete bool
+	Tools        []Tool
+	// Either "auto", "none", "required" or {"type": "function", "function": {"name": ...}}
+	ToolChoice interface{}
+
+	MaxTokens        *int
+	TopP             *float32
+	PresencePenalty  *float32
+	FrequencyPenalty *float32
+	Seed             *int
+	LogitBias        map[string]int
+
+	// Number of candidates to generate, 0 and 1 both mean a single one
+	N           int
+	Logprobs    bool
+	TopLogprobs *int
+}
+
+// Names of the options that aren't supported by every provider, as in the request body
+const (
+	OptionTools            = "tools"
+	OptionMaxTokens        = "max_tokens"
+	OptionTopP             = "top_p"
+	OptionPresencePenalty  = "presence_penalty"
+	OptionFrequencyPenalty = "frequency_penalty"
+	OptionSeed             = "seed"
+	OptionLogitBias        = "logit_bias"
+	OptionN                = "n"
+	OptionLogprobs         = "logprobs"
+)
+
+func (opts *ProviderOptions) usedOptions() []string {
+	if opts == nil {
+		return nil
+	}
+
+	var used []string
+	if len(opts.Tools) > 0 {
+		used = append(used, OptionTools)
+	}
+	if opts.MaxTokens != nil {
+		used = append(used, OptionMaxTokens)
+	}
+	if opts.TopP != nil {
+		used = append(used, OptionTopP)
+	}
+	if opts.PresencePenalty != nil {
+		used = append(used, OptionPresencePenalty)
+	}
+	if opts.FrequencyPenalty != nil {
+		used = append(used, OptionFrequencyPenalty)
+	}
+	if opts.Seed != nil {
+		used = append(used, OptionSeed)
+	}
+	if len(opts.LogitBias) > 0 {
+		used = append(used, OptionLogitBias)
+	}
+	if opts.N > 1 {
+		used = append(used, OptionN)
+	}
+	if opts.Logprobs || opts.TopLogprobs != nil {
+		used = append(used, OptionLogprobs)
+	}
+	return used
+}
+
+// UnsupportedOptions returns a warning for each option given by the user that isn't in
+// the supported ones.
+func (opts *ProviderOptions) UnsupportedOptions(supported ...string) []string {
+	var warnings []string
+
+	for _, option := range opts.usedOptions() {
+		isSupported := false
+		for _, s := range supported {
+			if s == option {
+				isSupported = true
+				break
+			}
+		}
+		if isSupported {
+			continue
+		}
+
+		if option == OptionTools {
+			warnings = append(warnings, ToolsNotSupportedWarning)
+		} else {
+			warnings = append(
+				warnings,
+				"The "+option+" option is not supported by this model and has been ignored.",
+			)
+		}
+	}
+
+	return warnings
+}
+
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"` // JSON schema of the arguments
+}
+
+type Tool struct {
+	Type     string       `json:"type"` // Only "function" is supported for now
+	Function ToolFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// While streaming, a tool call is sent as a list of deltas sharing the same Index. The
+// first one holds the ID and the function name, the next ones a part of the arguments.
+type ToolCall struct {
+	Index    int              `json:"index"`
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+const ToolsNotSupportedWarning = "Tool calling is not supported by this model and the tools have been ignored."
+
+// UnsupportedOptionsWarning forwards the results of a provider, warning the user first
+// about the options they gave that the provider doesn't support.
+func UnsupportedOptionsWarning(
+	opts *ProviderOptions,
+	input chan Result,
+	supported ...string,
+) chan Result {
+	warnings := opts.UnsupportedOptions(supported...)
+	if input == nil || len(warnings) == 0 {
+		return input
+	}
+
+	output := make(chan Result)
+	go func() {
+		defer close(output)
+		output <- Result{Warnings: warnings}
+		for v := range input {
+			output <- v
+		}
+	}()
+
+	return output
+}
+
+// MergeToolCalls adds the tool call deltas to the tool calls they are part of.
+func MergeToolCalls(toolCalls []ToolCall, deltas []ToolCall) []ToolCall {
+	for _, delta := range deltas {
+		found := false
+		for i := range toolCalls {
+			if toolCalls[i].Index != delta.Index {
+				continue
+			}
+			found = true
+			if delta.ID != "" {
+				toolCalls[i].ID = delta.ID
+			}
+			if delta.Type != "" {
+				toolCalls[i].Type = delta.Type
+			}
+			if delta.Function.Name != "" {
+				toolCalls[i].Function.Name = delta.Function.Name
+			}
+			toolCalls[i].Function.Arguments += delta.Function.Arguments
+		}
+
+		if !found {
+			toolCalls = append(toolCalls, delta)
+		}
+	}
+
+	return toolCalls
+}
+
+type Role string
+
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+	RoleTool      Role = "tool"
+)
+
+type Message struct {
+	Role    Role   `json:"role"`
+	Content string `json:"content"`
+	// Tool calls requested by the assistant
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// For Role=tool, the ID of the tool call this message is the result of
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// URLs of the images attached to the message, base64 images are data URLs
+	Images []string `json:"images,omitempty"`
+}
+
+// Rough count of the tokens of an image, used when the provider doesn't report its usage
+const ImageTokenEstimate = 765
+
+var ErrInvalidImage = errors.New("Invalid image")
+
+/*
+ * NormalizeImage turns an image given by the user into a URL. It can either already be
+ * an http(s) or data URL, or the base64 encoding of the image in which case its type is
+ * guessed from its content.
+ */
+func NormalizeImage(image string) (string, error) {
+	image = strings.TrimSpace(image)
+
+	if strings.HasPrefix(image, "https://") || strings.HasPrefix(image, "http://") {
+		return image, nil
+	}
+
+	if strings.HasPrefix(image, "data:image/") && strings.Contains(image, ";base64,") {
+		return image, nil
+	}
+
+	content, err := base64.StdEncoding.DecodeString(image)
+	if err != nil {
+		return "", ErrInvalidImage
+	}
+
+	mediaType := http.DetectContentType(content)
+	if !strings.HasPrefix(mediaType, "image/") {
+		return "", ErrInvalidImage
+	}
+
+	return "data:" + mediaType + ";base64," + image, nil
+}
+
+// ParseDataURL returns the media type and the base64 data of a data URL.
+func ParseDataURL(url string) (string, string, bool) {
+	if !strings.HasPrefix(url, "data:") {
+		return "", "", false
+	}
+
+	mediaType, data, found := strings.Cut(strings.TrimPrefix(url, "data:"), ";base64,")
+	if !found {
+		return "", "", false
+	}
+
+	return mediaType, data, true
+}
+
+func CountImages(messages []Message) int {
+	count := 0
+	for _, m := range messages {
+		count += len(m.Images)
+	}
+	return count
+}
+
+/*
+ * Text-only models can't take a structured conversation, FlattenMessages renders it
+ * as a single prompt using the "User:"/"You:" format. In auto-completion mode the
+ * contents are just concatenated so the model continues the text.
+ */
+func FlattenMessages(messages []Message, autoComplete bool) string {
+	if autoComplete {
+		contents := make([]string, len(messages))
+		for i, m := range messages {
+			contents[i] = m.Content
+		}
+		return strings.Join(contents, "\n")
+	}
+
+	prompt := ""
+	for _, m := range messages {
+		switch m.Role {
+		case RoleSystem:
+			prompt += m.Content + "\n"
+		case RoleUser:
+			prompt += "User:\n" + m.Content + "\n"
+		case RoleAssistant:
+			prompt += "You:\n" + m.Content + "\n"
+		case RoleTool:
+			prompt += "Tool result:\n" + m.Content + "\n"
+		}
+	}
+
+	return prompt + "You:\n"
+}
+
+var flattenedChatStopWords = []string{"User:", "You:"}
+
+// Once flattened, a conversation with previous turns tends to be continued by the model
+// writing the next user message itself. We stop it before it does.
+func FlattenedStopWords(messages []Message, opts *ProviderOptions) *[]string {
+	var stopWords []string
+	if opts != nil && opts.StopWords != nil {
+		stopWords = append(stopWords, *opts.StopWords...)
+	}
+
+	if opts != nil && opts.AutoComplete {
+		if len(stopWords) == 0 {
+			return nil
+		}
+		return &stopWords
+	}
+
+	for _, m := range messages {
+		if m.Role == RoleAssistant {
+			stopWords = append(stopWords, flattenedChatStopWords...)
+			break
+		}
+	}
+
+	if len(stopWords) == 0 {
+		return nil
+	}
+
+	return &stopWords
 }
 
 type TokenUsage struct {
@@ -18,22 +336,85 @@ type TokenUsage struct {
 	Output int `json:"output"`
 }
 
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+}
+
+type TokenLogprob struct {
+	Token       string       `json:"token"`
+	Logprob     float64      `json:"logprob"`
+	TopLogprobs []TopLogprob `json:"top_logprobs,omitempty"`
+}
+
+type Candidate struct {
+	Index     int            `json:"index"`
+	Result    string         `json:"result"`
+	ToolCalls []ToolCall     `json:"tool_calls,omitempty"`
+	Logprobs  []TokenLogprob `json:"logprobs,omitempty"`
+}
+
+// MergeCandidate adds a result chunk to the candidate it is part of.
+func MergeCandidate(candidates []Candidate, r Result) []Candidate {
+	for len(candidates) <= r.Index {
+		candidates = append(candidates, Candidate{Index: len(candidates)})
+	}
+
+	candidate := &candidates[r.Index]
+	candidate.Result += r.Result
+	candidate.Logprobs = append(candidate.Logprobs, r.Logprobs...)
+	if len(r.ToolCalls) > 0 {
+		candidate.ToolCalls = MergeToolCalls(candidate.ToolCalls, r.ToolCalls)
+	}
+
+	return candidates
+}
+
 type Result struct {
 	Result     string           `json:"result"`
 	TokenUsage TokenUsage       `json:"token_usage"`
 	Resources  []db.MatchResult `json:"ressources,omitempty"`
 	Err        string           `json:"error,omitempty"`
 	Warnings   []string         `json:"warnings,omitempty"`
+	ToolCalls  []ToolCall       `json:"tool_calls,omitempty"`
+	// The provider and model that answered, they can differ from the requested model
+	// when it has fallbacks
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+	// The candidate a chunk is part of when several have been asked for
+	Index    int            `json:"index,omitempty"`
+	Logprobs []TokenLogprob `json:"logprobs,omitempty"`
+	// Only set in the aggregated results, the first candidate is also in Result
+	Candidates    []Candidate    `json:"candidates,omitempty"`
+	ContextBudget *ContextBudget `json:"context_budget,omitempty"`
+	// The version of the saved system prompt that was used
+	Prompt *db.ServedPrompt `json:"prompt,omitempty"`
 }
 
 type ProviderCallback *func(string, string, int, int, string, *int)
 
+// ContextBudget is the part of the model's context window given to the context (system
+// prompt, memory, chat history and web results) once the task and the answer are counted.
+type ContextBudget struct {
+	ContextWindow  int `json:"context_window,omitempty"` // Unset when the model's isn't known
+	TaskTokens     int `json:"task_tokens"`
+	ReservedOutput int `json:"reserved_output"`
+	Budget         int `json:"budget"`
+}
+
 type jsonableResult struct {
 	Result     string           `json:"result"`
 	TokenUsage TokenUsage       `json:"token_usage"`
 	Resources  []db.MatchResult `json:"ressources,omitempty"`
 	Error      *utils.APIError  `json:"error,omitempty"`
 	Warnings   []string         `json:"warnings,omitempty"`
+	ToolCalls  []ToolCall       `json:"tool_calls,omitempty"`
+	Provider   string           `json:"provider,omitempty"`
+	Model      string           `json:"model,omitempty"`
+	Candidates []Candidate      `json:"candidates,omitempty"`
+
+	ContextBudget *ContextBudget   `json:"context_budget,omitempty"`
+	Prompt        *db.ServedPrompt `json:"prompt,omitempty"`
 }
 
 func (r Result) JSON() ([]byte, error) {
@@ -54,6 +435,13 @@ func (r Result) JSON() ([]byte, error) {
 		Resources:  r.Resources,
 		Error:      apiError,
 		Warnings:   r.Warnings,
+		ToolCalls:  r.ToolCalls,
+		Provider:   r.Provider,
+		Model:      r.Model,
+		Candidates: r.Candidates,
+
+		ContextBudget: r.ContextBudget,
+		Prompt:        r.Prompt,
 	})
 	if err != nil {
 		return []byte{}, err
@@ -2,12 +2,12 @@ package providers
 
 import (
 	"context"
-	"errors"
-	"fmt"
+	"log"
 	"os"
 
 	"github.com/polyfire/api/llm/providers/options"
 	replicate "github.com/polyfire/api/llm/providers/replicate"
+	"github.com/polyfire/api/registry"
 	"github.com/polyfire/api/utils"
 )
 
@@ -30,64 +30,51 @@ func NewReplicateProvider(ctx context.Context, model string) ReplicateProvider {
 	return ReplicateProvider{Model: model, ReplicateAPIKey: apiKey, IsCustomAPIKey: ok}
 }
 
-func (m ReplicateProvider) GetCreditsPerSecond() float64 {
-	switch m.Model {
-	case "llama-2-70b-chat":
-		return 14000.0
-	case "replit-code-v1-3b":
-		return 11500.0
-	case "wizard-mega-13b-awq":
-		return 7250.0
-	case "airoboros-llama-2-70b":
-		return 14000.0
-	default:
-		fmt.Printf("Invalid model: %v\n", m.Model)
-		return 0.0
-	}
-}
-
-func (m ReplicateProvider) GetVersion() (string, bool, error) {
-	switch m.Model {
-	case "llama-2-70b-chat":
-		return "02e509c789964a7ea8736978a43525956ef40397be9033abf9fd2badfe68c9e3", true, nil
-	case "replit-code-v1-3b":
-		return "b84f4c074b807211cd75e3e8b1589b6399052125b4c27106e43d47189e8415ad", true, nil
-	case "wizard-mega-13b-awq":
-		return "a4be2a7c75e51c53b22167d44de3333436f1aa9253a201d2619cf74286478599", false, nil
-	case "airoboros-llama-2-70b":
-		return "ae090a64e6b4468d7fa85c6ca33c979b3cd941c12b1cfa2a237b4a7aa6ebaac4", true, nil
-	default:
-		return "", false, errors.New("Invalid model")
-	}
-}
-
 func (m ReplicateProvider) Generate(
-	task string,
+	ctx context.Context,
+	messages []options.Message,
 	c options.ProviderCallback,
 	opts *options.ProviderOptions,
 ) chan options.Result {
-	version, stream, err := m.GetVersion()
-	if err != nil {
-		fmt.Printf("Error getting version: %v\n", err)
-		return nil
+	entry, ok := registry.Find("replicate", m.Model)
+	if !ok || entry.Version == "" {
+		log.Printf("[ERROR] Invalid Replicate model: %v", m.Model)
+		chanRes := make(chan options.Result, 1)
+		chanRes <- options.Result{Err: "generation_error"}
+		close(chanRes)
+		return chanRes
 	}
 
 	replicateProvider := replicate.ReplicateProvider{
 		Model:            m.Model,
 		ReplicateAPIKey:  m.ReplicateAPIKey,
 		IsCustomAPIKey:   m.IsCustomAPIKey,
-		Version:          version,
-		CreditsPerSecond: m.GetCreditsPerSecond(),
+		Version:          entry.Version,
+		CreditsPerSecond: entry.Pricing.Second,
+	}
+
+	if opts == nil {
+		opts = &options.ProviderOptions{}
 	}
 
+	task := options.FlattenMessages(messages, opts.AutoComplete)
+	flattenedOpts := *opts
+	flattenedOpts.StopWords = options.FlattenedStopWords(messages, opts)
+
 	var chanRes chan options.Result
-	if stream {
-		chanRes = replicateProvider.Stream(task, c, opts)
+	if entry.Capabilities.Stream {
+		chanRes = replicateProvider.Stream(ctx, task, c, &flattenedOpts)
 	} else {
-		chanRes = replicateProvider.NoStream(task, c, opts)
+		chanRes = replicateProvider.NoStream(ctx, task, c, &flattenedOpts)
 	}
 
-	return chanRes
+	return options.UnsupportedOptionsWarning(
+		opts,
+		chanRes,
+		options.OptionMaxTokens,
+		options.OptionTopP,
+		options.OptionSeed,
+	)
 }
 
 func (m ReplicateProvider) Name() string {
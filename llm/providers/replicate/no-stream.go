@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -9,13 +10,20 @@ import (
 	"github.com/polyfire/api/tokens"
 )
 
+type ReplicatePredictionMetrics struct {
+	InputTokenCount  int `json:"input_token_count"`
+	OutputTokenCount int `json:"output_token_count"`
+}
+
 type ReplicatePredictionOutput struct {
-	ID     string `json:"id"`
-	Status string `json:"status"`
-	Output string `json:"output"`
+	ID      string                     `json:"id"`
+	Status  string                     `json:"status"`
+	Output  string                     `json:"output"`
+	Metrics ReplicatePredictionMetrics `json:"metrics"`
 }
 
 func (m ReplicateProvider) NoStream(
+	ctx context.Context,
 	task string,
 	c options.ProviderCallback,
 	opts *options.ProviderOptions,
@@ -28,7 +36,7 @@ func (m ReplicateProvider) NoStream(
 		replicateStartTime := time.Now()
 		replicateAfterBootTime := time.Now()
 
-		startResponse, errorCode := m.ReplicateStart(task, opts, false)
+		startResponse, errorCode := m.ReplicateStart(ctx, task, opts, false)
 		if errorCode != "" {
 			chanRes <- options.Result{Err: errorCode}
 			return
@@ -40,8 +48,12 @@ func (m ReplicateProvider) NoStream(
 		tokenUsage.Input = tokens.CountTokens(task)
 		coldBootDetected := false
 
+	polling:
 		for {
-			respBody, err := m.SendRequest(startResponse.URLs.Get)
+			respBody, err := m.SendRequest(ctx, startResponse.URLs.Get)
+			if ctx.Err() != nil {
+				break
+			}
 			if err != nil {
 				fmt.Println(err)
 				chanRes <- options.Result{Err: "generation_error"}
@@ -68,11 +80,31 @@ func (m ReplicateProvider) NoStream(
 			if output.Status == "succeeded" {
 				completion = output.Output
 				tokenUsage.Output = tokens.CountTokens(completion)
+
+				// Only the language models report their token counts
+				if output.Metrics.InputTokenCount > 0 {
+					tokenUsage.Input = output.Metrics.InputTokenCount
+				}
+				if output.Metrics.OutputTokenCount > 0 {
+					tokenUsage.Output = output.Metrics.OutputTokenCount
+				}
+
 				chanRes <- options.Result{Result: output.Output, TokenUsage: tokenUsage}
 				break
 			}
 
-			time.Sleep(1 * time.Second)
+			select {
+			case <-ctx.Done():
+				break polling
+			case <-time.After(1 * time.Second):
+			}
+		}
+
+		if ctx.Err() != nil {
+			err := m.CancelPrediction(startResponse.URLs.Cancel)
+			if err != nil {
+				fmt.Println(err)
+			}
 		}
 
 		replicateEndTime := time.Now()
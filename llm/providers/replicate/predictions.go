@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,8 +9,11 @@ import (
 	"strings"
 
 	"github.com/polyfire/api/llm/providers/options"
+	"github.com/polyfire/api/resilience"
 )
 
+var replicateHTTPClient = resilience.Client("replicate")
+
 type ReplicateProvider struct {
 	Model            string
 	ReplicateAPIKey  string
@@ -25,6 +29,12 @@ type ReplicateInput struct {
 	Text    string `json:"text"`
 
 	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	// The name of the length limit depends on the model
+	MaxTokens    *int `json:"max_tokens,omitempty"`
+	MaxNewTokens *int `json:"max_new_tokens,omitempty"`
+	MaxLength    *int `json:"max_length,omitempty"`
 }
 
 type ReplicateRequestBody struct {
@@ -50,6 +60,7 @@ type ReplicateStartErrorResponse struct {
 }
 
 func (m ReplicateProvider) ReplicateStart(
+	ctx context.Context,
 	task string,
 	opts *options.ProviderOptions,
 	stream bool,
@@ -60,8 +71,13 @@ func (m ReplicateProvider) ReplicateStart(
 		Stream:  stream,
 	}
 
-	if opts != nil && opts.Temperature != nil {
+	if opts != nil {
 		reqBody.Input.Temperature = opts.Temperature
+		reqBody.Input.TopP = opts.TopP
+		reqBody.Input.Seed = opts.Seed
+		reqBody.Input.MaxTokens = opts.MaxTokens
+		reqBody.Input.MaxNewTokens = opts.MaxTokens
+		reqBody.Input.MaxLength = opts.MaxTokens
 	}
 
 	reqBody.Input.Task = task
@@ -74,7 +90,8 @@ func (m ReplicateProvider) ReplicateStart(
 		return ReplicateStartResponse{}, "generation_error"
 	}
 
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"POST",
 		"https://api.replicate.com/v1/predictions",
 		strings.NewReader(string(input)),
@@ -86,7 +103,7 @@ func (m ReplicateProvider) ReplicateStart(
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Token "+m.ReplicateAPIKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := replicateHTTPClient.Do(req)
 	if err != nil {
 		return ReplicateStartResponse{}, "generation_error"
 	}
@@ -122,3 +139,22 @@ func (m ReplicateProvider) ReplicateStart(
 
 	return startResponse, ""
 }
+
+// CancelPrediction stops the prediction on replicate's side. It doesn't use the request
+// context since it's mostly called once this one has been cancelled.
+func (m ReplicateProvider) CancelPrediction(cancelURL string) error {
+	req, err := http.NewRequest("POST", cancelURL, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Token "+m.ReplicateAPIKey)
+
+	resp, err := replicateHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -85,8 +86,11 @@ type ReplicateStreamPredictionOutput struct {
 	Status string `json:"status"`
 }
 
-func (m ReplicateProvider) SendRequest(streamURL string) (io.ReadCloser, error) {
-	req, err := http.NewRequest("GET", streamURL, nil)
+func (m ReplicateProvider) SendRequest(
+	ctx context.Context,
+	streamURL string,
+) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", streamURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -94,7 +98,7 @@ func (m ReplicateProvider) SendRequest(streamURL string) (io.ReadCloser, error)
 	req.Header.Set("Authorization", "Token "+m.ReplicateAPIKey)
 	req.Header.Set("Accept", "text/event-stream")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := replicateHTTPClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -212,6 +216,7 @@ func ReceiveStream(
 }
 
 func (m ReplicateProvider) Stream(
+	ctx context.Context,
 	task string,
 	c options.ProviderCallback,
 	opts *options.ProviderOptions,
@@ -224,7 +229,7 @@ func (m ReplicateProvider) Stream(
 		tokenUsage.Input += tokens.CountTokens(task)
 		chanRes <- options.Result{TokenUsage: tokenUsage}
 
-		startResponse, errorCode := m.ReplicateStart(task, opts, true)
+		startResponse, errorCode := m.ReplicateStart(ctx, task, opts, true)
 		if errorCode != "" {
 			chanRes <- options.Result{Err: errorCode}
 			return
@@ -237,7 +242,10 @@ func (m ReplicateProvider) Stream(
 		stopWords := StopWords{StopWords: opts.StopWords}
 
 		for {
-			respBody, err := m.SendRequest(startResponse.URLs.Stream)
+			respBody, err := m.SendRequest(ctx, startResponse.URLs.Stream)
+			if ctx.Err() != nil {
+				break
+			}
 			if err != nil {
 				chanRes <- options.Result{Err: "generation_error"}
 				return
@@ -248,11 +256,14 @@ func (m ReplicateProvider) Stream(
 			completion, done := ReceiveStream(chanRes, &stopWords, &eb, &replicateAfterBootTime)
 			totalCompletion += completion
 			totalOutputTokens += tokens.CountTokens(completion)
-			if done {
+			if done || ctx.Err() != nil {
 				break
 			}
 
-			respBody, err = m.SendRequest(startResponse.URLs.Get)
+			respBody, err = m.SendRequest(ctx, startResponse.URLs.Get)
+			if ctx.Err() != nil {
+				break
+			}
 			if err != nil {
 				fmt.Println(err)
 				chanRes <- options.Result{Err: "generation_error"}
@@ -273,8 +284,10 @@ func (m ReplicateProvider) Stream(
 			fmt.Println("Waiting for model to start...", output.Status, output)
 		}
 
-		_, err := m.SendRequest(startResponse.URLs.Cancel)
-		if err != nil {
+		// The prediction is cancelled even if it's done, for the models that would
+		// continue generating after a stop word has been found.
+		err := m.CancelPrediction(startResponse.URLs.Cancel)
+		if err != nil && ctx.Err() == nil {
 			fmt.Println(err)
 			chanRes <- options.Result{Err: "generation_error"}
 			return
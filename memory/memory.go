@@ -3,6 +3,7 @@ package memory
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/google/uuid"
@@ -17,6 +18,23 @@ import (
 
 const BatchSize int = 512
 
+var ErrEmbeddingModelMismatch = errors.New("The memories don't use the same embedding model")
+
+func embeddingCallback(ctx context.Context, userID string) llm.EmbeddingCallback {
+	db := ctx.Value(utils.ContextKeyDB).(database.Database)
+	eventID := ctx.Value(utils.ContextKeyEventID).(string)
+
+	callback := func(providerName string, modelName string, inputCount int) {
+		// The projects pay for their own openai-compatible servers
+		db.LogRequests(
+			eventID,
+			userID, providerName, modelName, inputCount, 0, "embedding",
+			providerName != "openai-compatible")
+	}
+
+	return &callback
+}
+
 func Create(w http.ResponseWriter, r *http.Request, _ router.Params) {
 	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
 	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
@@ -27,7 +45,8 @@ func Create(w http.ResponseWriter, r *http.Request, _ router.Params) {
 	}
 
 	var requestBody struct {
-		Public *bool `json:"public,omitempty"`
+		Public         *bool  `json:"public,omitempty"`
+		EmbeddingModel string `json:"embedding_model,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
@@ -40,15 +59,51 @@ func Create(w http.ResponseWriter, r *http.Request, _ router.Params) {
 		requestBody.Public = &defaultVal
 	}
 
-	memoryID := uuid.New().String()
+	model, err := llm.GetEmbeddingModel(r.Context(), requestBody.EmbeddingModel)
+	if err != nil {
+		utils.RespondError(w, record, "invalid_embedding_model")
+		return
+	}
 
-	if err := db.CreateMemory(memoryID, userID, *requestBody.Public); err != nil {
+	// The dimension of the projects' own models is only known by using them once
+	if model.Dimension == 0 {
+		embeddings, err := llm.EmbedWithModel(
+			r.Context(),
+			model,
+			[]string{"dimension"},
+			embeddingCallback(r.Context(), userID),
+		)
+		if err != nil || len(embeddings) == 0 || len(embeddings[0]) == 0 {
+			utils.RespondError(w, record, "embedding_error")
+			return
+		}
+		model.Dimension = len(embeddings[0])
+	}
+
+	memory := database.Memory{
+		ID:                 uuid.New().String(),
+		UserID:             userID,
+		Public:             *requestBody.Public,
+		EmbeddingModel:     requestBody.EmbeddingModel,
+		EmbeddingDimension: model.Dimension,
+	}
+	if memory.EmbeddingModel == "" {
+		memory.EmbeddingModel = llm.DefaultEmbeddingModel
+	}
+
+	err = db.CreateMemory(
+		memory.ID,
+		userID,
+		memory.Public,
+		memory.EmbeddingModel,
+		memory.EmbeddingDimension,
+	)
+	if err != nil {
 		utils.RespondError(w, record, "db_creation_error")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	memory := database.Memory{ID: memoryID, UserID: userID, Public: *requestBody.Public}
 
 	response, _ := json.Marshal(&memory)
 	record(string(response))
@@ -104,8 +159,9 @@ func (i *InputArray) UnmarshalJSON(data []byte) error {
 
 func ProcessEmbeddingAsBatch(
 	ctx context.Context,
+	model llm.EmbeddingModel,
 	inputs []Input,
-	callback *func(model_name string, input_count int),
+	callback llm.EmbeddingCallback,
 ) ([][]float32, error) {
 	texts := make([]string, len(inputs))
 	for i, input := range inputs {
@@ -120,7 +176,7 @@ func ProcessEmbeddingAsBatch(
 	}
 
 	for _, batch := range batches {
-		embeddingsBatch, err := llm.Embed(ctx, batch, callback)
+		embeddingsBatch, err := llm.EmbedWithModel(ctx, model, batch, callback)
 		if err != nil {
 			return nil, err
 		}
@@ -177,12 +233,18 @@ func Add(w http.ResponseWriter, r *http.Request, _ router.Params) {
 
 	}
 
-	callback := func(model_name string, input_count int) {
-		db.LogRequests(
-			r.Context().Value(utils.ContextKeyEventID).(string),
-			userID, "openai", model_name, input_count, 0, "embedding", true)
+	model, err := GetEmbeddingModel(r.Context(), []string{requestBody.ID})
+	if err != nil {
+		utils.RespondError(w, record, "invalid_embedding_model")
+		return
 	}
-	embeddings, err := ProcessEmbeddingAsBatch(r.Context(), chunks, &callback)
+
+	embeddings, err := ProcessEmbeddingAsBatch(
+		r.Context(),
+		model,
+		chunks,
+		embeddingCallback(r.Context(), userID),
+	)
 	if err != nil {
 		utils.RespondError(w, record, "embedding_error")
 		return
@@ -246,6 +308,40 @@ func Get(w http.ResponseWriter, r *http.Request, _ router.Params) {
 	_ = json.NewEncoder(w).Encode(response)
 }
 
+/*
+ * GetEmbeddingModel returns the embedding model shared by the memories. The vectors of
+ * different models can't be compared, so the memories must all use the same one.
+ */
+func GetEmbeddingModel(ctx context.Context, memoryIDs []string) (llm.EmbeddingModel, error) {
+	db := ctx.Value(utils.ContextKeyDB).(database.Database)
+
+	var modelName string
+	var dimension int
+
+	for i, memoryID := range memoryIDs {
+		memory, err := db.GetMemory(memoryID)
+		if err != nil {
+			return llm.EmbeddingModel{}, err
+		}
+
+		if i > 0 && (memory.EmbeddingModel != modelName || memory.EmbeddingDimension != dimension) {
+			return llm.EmbeddingModel{}, ErrEmbeddingModelMismatch
+		}
+
+		modelName = memory.EmbeddingModel
+		dimension = memory.EmbeddingDimension
+	}
+
+	model, err := llm.GetEmbeddingModel(ctx, modelName)
+	if err != nil {
+		return llm.EmbeddingModel{}, err
+	}
+
+	model.Dimension = dimension
+
+	return model, nil
+}
+
 func Embedder(
 	ctx context.Context,
 	userID string,
@@ -253,13 +349,18 @@ func Embedder(
 	task string,
 ) ([]database.MatchResult, error) {
 	db := ctx.Value(utils.ContextKeyDB).(database.Database)
-	callback := func(model_name string, input_count int) {
-		db.LogRequests(
-			ctx.Value(utils.ContextKeyEventID).(string),
-			userID, "openai", model_name, input_count, 0, "embedding", true)
+
+	model, err := GetEmbeddingModel(ctx, memoryID)
+	if err != nil {
+		return nil, err
 	}
 
-	embeddings, err := llm.Embed(ctx, []string{task}, &callback)
+	embeddings, err := llm.EmbedWithModel(
+		ctx,
+		model,
+		[]string{task},
+		embeddingCallback(ctx, userID),
+	)
 	if err != nil {
 		return nil, err
 	}
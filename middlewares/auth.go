@@ -32,15 +32,16 @@ func ParseJWT(token string) (jwt.MapClaims, error) {
 	return claims, nil
 }
 
-func createUserContext(
-	r *http.Request,
+// UserContext adds the infos of an authenticated user to a context. It is also used to
+// resume the work of a user outside of a request, like the generation jobs.
+func UserContext(
+	ctx context.Context,
 	userID string,
 	user *database.UserInfos,
 	rateLimitStatus database.RateLimitStatus,
 	creditsStatus database.CreditsStatus,
 ) context.Context {
-	recordEventWithUserID := r.Context().Value(utils.ContextKeyRecordEventWithUserID).(utils.RecordWithUserIDFunc)
-	newCtx := context.WithValue(r.Context(), utils.ContextKeyUserID, userID)
+	newCtx := context.WithValue(ctx, utils.ContextKeyUserID, userID)
 	newCtx = context.WithValue(newCtx, utils.ContextKeyRateLimitStatus, rateLimitStatus)
 	newCtx = context.WithValue(newCtx, utils.ContextKeyCreditsStatus, creditsStatus)
 	if user != nil {
@@ -51,6 +52,8 @@ func createUserContext(
 			user.ProjectUserRateLimit,
 		)
 		newCtx = context.WithValue(newCtx, utils.ContextKeyProjectID, user.ProjectID)
+		newCtx = context.WithValue(newCtx, utils.ContextKeyCredits, user.Credits)
+		newCtx = context.WithValue(newCtx, utils.ContextKeyTokenVersion, user.Version)
 		if user.OpenaiToken != "" {
 			newCtx = context.WithValue(newCtx, utils.ContextKeyOpenAIToken, user.OpenaiToken)
 			if user.OpenaiOrg != "" {
@@ -60,6 +63,9 @@ func createUserContext(
 		if user.ReplicateToken != "" {
 			newCtx = context.WithValue(newCtx, utils.ContextKeyReplicateToken, user.ReplicateToken)
 		}
+		if user.AnthropicToken != "" {
+			newCtx = context.WithValue(newCtx, utils.ContextKeyAnthropicToken, user.AnthropicToken)
+		}
 		if user.ElevenlabsToken != "" {
 			newCtx = context.WithValue(
 				newCtx,
@@ -69,6 +75,19 @@ func createUserContext(
 		}
 	}
 
+	return newCtx
+}
+
+func createUserContext(
+	r *http.Request,
+	userID string,
+	user *database.UserInfos,
+	rateLimitStatus database.RateLimitStatus,
+	creditsStatus database.CreditsStatus,
+) context.Context {
+	recordEventWithUserID := r.Context().Value(utils.ContextKeyRecordEventWithUserID).(utils.RecordWithUserIDFunc)
+	newCtx := UserContext(r.Context(), userID, user, rateLimitStatus, creditsStatus)
+
 	var recordEvent utils.RecordFunc = func(response string, props ...utils.KeyValue) {
 		recordEventWithUserID(response, userID, props...)
 	}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 
 	"github.com/google/uuid"
 
@@ -79,6 +80,7 @@ func AddRecord(r *http.Request, eventType utils.EventType) {
 			properties["responseBody"] = response
 			var isError bool = false
 			var promptID string = ""
+			var promptVersion int = 0
 			for _, element := range props {
 				if element.Key == "Error" {
 					isError = true
@@ -86,6 +88,9 @@ func AddRecord(r *http.Request, eventType utils.EventType) {
 				if element.Key == "PromptID" {
 					promptID = element.Value
 				}
+				if element.Key == "PromptVersion" {
+					promptVersion, _ = strconv.Atoi(element.Value)
+				}
 				properties[element.Key] = element.Value
 			}
 			distinctID := userID
@@ -102,6 +107,7 @@ func AddRecord(r *http.Request, eventType utils.EventType) {
 				response,
 				isError,
 				promptID,
+				promptVersion,
 				string(eventType),
 				origin,
 			)
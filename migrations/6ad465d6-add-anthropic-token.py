@@ -0,0 +1,9 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE auth_users ADD anthropic_token text;
+    """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE auth_users DROP COLUMN anthropic_token;
+    """)
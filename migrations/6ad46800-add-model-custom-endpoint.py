@@ -0,0 +1,11 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE models ADD base_url text;
+        ALTER TABLE models ADD encrypted_api_key text;
+    """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE models DROP COLUMN base_url;
+        ALTER TABLE models DROP COLUMN encrypted_api_key;
+    """)
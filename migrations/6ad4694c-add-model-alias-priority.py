@@ -0,0 +1,9 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE model_aliases ADD priority integer NOT NULL DEFAULT 0;
+    """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE model_aliases DROP COLUMN priority;
+    """)
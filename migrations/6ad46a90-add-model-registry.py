@@ -0,0 +1,22 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE models ADD aliases text[];
+        ALTER TABLE models ADD context_window integer;
+        ALTER TABLE models ADD credit_per_second double precision;
+        ALTER TABLE models ADD replicate_version text;
+        ALTER TABLE models ADD option_json boolean NOT NULL DEFAULT false;
+        ALTER TABLE models ADD option_tools boolean NOT NULL DEFAULT false;
+        ALTER TABLE models ADD option_vision boolean NOT NULL DEFAULT false;
+        UPDATE models SET aliases = ARRAY[model] WHERE provider = 'openrouter';
+    """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE models DROP COLUMN aliases;
+        ALTER TABLE models DROP COLUMN context_window;
+        ALTER TABLE models DROP COLUMN credit_per_second;
+        ALTER TABLE models DROP COLUMN replicate_version;
+        ALTER TABLE models DROP COLUMN option_json;
+        ALTER TABLE models DROP COLUMN option_tools;
+        ALTER TABLE models DROP COLUMN option_vision;
+    """)
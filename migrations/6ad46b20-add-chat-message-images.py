@@ -0,0 +1,9 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE chat_messages ADD images jsonb NOT NULL DEFAULT '[]'::jsonb;
+    """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE chat_messages DROP COLUMN images;
+    """)
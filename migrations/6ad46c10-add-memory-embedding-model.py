@@ -0,0 +1,83 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+    ALTER TABLE memories ADD embedding_model text NOT NULL DEFAULT 'text-embedding-ada-002';
+    ALTER TABLE memories ADD embedding_dimension integer NOT NULL DEFAULT 1536;
+    ALTER TABLE models ADD dimension integer;
+
+    -- The vectors of each memory can have a different size. The ivfflat index needs a
+    -- fixed dimension, the search is already restricted to a few memories by their ids.
+    DROP INDEX embeddings_embedding_idx;
+    ALTER TABLE embeddings ALTER COLUMN embedding TYPE vector;
+
+    DROP FUNCTION retrieve_embeddings;
+    CREATE OR REPLACE FUNCTION public.retrieve_embeddings(query_embedding vector, match_threshold double precision, match_count integer, memoryid uuid[], userid text)
+     RETURNS TABLE(id uuid, content text, similarity double precision, metadatas json)
+     LANGUAGE sql
+     STABLE
+    AS $function$
+            -- The distance between vectors of different sizes is an error, so the memories
+            -- of other dimensions are filtered out before computing it (OFFSET 0 keeps the
+            -- subquery from being flattened).
+            SELECT
+              matching.id,
+              matching.content,
+              matching.similarity,
+              matching.metadatas
+            FROM (
+              SELECT
+                embeddings.id,
+                embeddings.content,
+                1 - (embeddings.embedding <=> query_embedding) as similarity,
+                embeddings.metadatas
+              FROM embeddings
+              JOIN memories ON embeddings.memory_id = memories.id
+              WHERE
+                embeddings.memory_id = ANY(memoryid)
+                AND memories.embedding_dimension = vector_dims(query_embedding)
+                AND (
+                  memories.user_id::text = userid
+                  OR memories.public = true
+                )
+              OFFSET 0
+            ) matching
+            WHERE matching.similarity > match_threshold
+            ORDER BY matching.similarity DESC
+            LIMIT match_count;
+     $function$;
+    """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+    DROP FUNCTION retrieve_embeddings;
+    CREATE OR REPLACE FUNCTION public.retrieve_embeddings(query_embedding vector, match_threshold double precision, match_count integer, memoryid uuid[], userid text)
+     RETURNS TABLE(id uuid, content text, similarity double precision, metadatas json)
+     LANGUAGE sql
+     STABLE
+    AS $function$
+            SELECT
+              embeddings.id,
+              embeddings.content,
+              1 - (embeddings.embedding <=> query_embedding) as similarity,
+              embeddings.metadatas
+            FROM embeddings
+            JOIN memories ON embeddings.memory_id = memories.id
+            WHERE
+              1 - (embeddings.embedding <=> query_embedding) > match_threshold
+              AND embeddings.memory_id = ANY(memoryid)
+              AND (
+                memories.user_id::text = userid
+                OR memories.public = true
+              )
+            ORDER BY similarity DESC
+            LIMIT match_count;
+     $function$;
+
+    DELETE FROM embeddings USING memories
+      WHERE embeddings.memory_id = memories.id AND memories.embedding_dimension != 1536;
+    ALTER TABLE embeddings ALTER COLUMN embedding TYPE vector(1536);
+    CREATE INDEX embeddings_embedding_idx ON public.embeddings USING ivfflat (embedding vector_cosine_ops) WITH (lists='100');
+
+    ALTER TABLE models DROP COLUMN dimension;
+    ALTER TABLE memories DROP COLUMN embedding_dimension;
+    ALTER TABLE memories DROP COLUMN embedding_model;
+    """)
@@ -0,0 +1,9 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE models ADD option_json_schema boolean NOT NULL DEFAULT false;
+    """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE models DROP COLUMN option_json_schema;
+    """)
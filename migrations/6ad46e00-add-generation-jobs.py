@@ -0,0 +1,31 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+        CREATE TABLE public.generation_jobs (
+            id uuid DEFAULT gen_random_uuid() NOT NULL,
+            user_id uuid NOT NULL,
+            status text DEFAULT 'pending' NOT NULL,
+            input jsonb NOT NULL,
+            result jsonb,
+            webhook_url text,
+            webhook_secret text,
+            event_id text,
+            token_version integer DEFAULT 0 NOT NULL,
+            created_at timestamp with time zone DEFAULT now() NOT NULL,
+            completed_at timestamp with time zone
+        );
+        ALTER TABLE ONLY public.generation_jobs
+            ADD CONSTRAINT generation_jobs_pkey PRIMARY KEY (id);
+        CREATE INDEX generation_jobs_unfinished_idx ON public.generation_jobs USING btree (status)
+            WHERE status IN ('pending', 'running');
+    """)
+
+    if rls:
+        cur.execute("""
+            ALTER TABLE public.generation_jobs OWNER TO postgres;
+            ALTER TABLE public.generation_jobs ENABLE ROW LEVEL SECURITY;
+        """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+        DROP TABLE public.generation_jobs;
+    """)
@@ -0,0 +1,22 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+    -- The prompts can be deleted by their users, their events are kept and their likes removed
+    ALTER TABLE events DROP CONSTRAINT events_prompt_id_fkey;
+    ALTER TABLE events
+        ADD CONSTRAINT events_prompt_id_fkey FOREIGN KEY (prompt_id) REFERENCES public.prompts(id) ON DELETE SET NULL;
+
+    ALTER TABLE prompts_likes DROP CONSTRAINT prompts_likes_prompt_id_fkey;
+    ALTER TABLE prompts_likes
+        ADD CONSTRAINT prompts_likes_prompt_id_fkey FOREIGN KEY (prompt_id) REFERENCES public.prompts(id) ON DELETE CASCADE;
+    """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+    ALTER TABLE prompts_likes DROP CONSTRAINT prompts_likes_prompt_id_fkey;
+    ALTER TABLE prompts_likes
+        ADD CONSTRAINT prompts_likes_prompt_id_fkey FOREIGN KEY (prompt_id) REFERENCES public.prompts(id);
+
+    ALTER TABLE events DROP CONSTRAINT events_prompt_id_fkey;
+    ALTER TABLE events
+        ADD CONSTRAINT events_prompt_id_fkey FOREIGN KEY (prompt_id) REFERENCES public.prompts(id);
+    """)
@@ -0,0 +1,113 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE public.prompts ADD version integer DEFAULT 1 NOT NULL;
+
+        CREATE TABLE public.prompt_versions (
+            id uuid DEFAULT gen_random_uuid() NOT NULL,
+            prompt_id uuid NOT NULL,
+            version integer NOT NULL,
+            name text,
+            description text,
+            prompt text,
+            created_at timestamp with time zone DEFAULT now() NOT NULL
+        );
+        ALTER TABLE ONLY public.prompt_versions
+            ADD CONSTRAINT prompt_versions_pkey PRIMARY KEY (id);
+        ALTER TABLE ONLY public.prompt_versions
+            ADD CONSTRAINT prompt_versions_prompt_id_version_key UNIQUE (prompt_id, version);
+        ALTER TABLE ONLY public.prompt_versions
+            ADD CONSTRAINT prompt_versions_prompt_id_fkey FOREIGN KEY (prompt_id) REFERENCES public.prompts(id) ON DELETE CASCADE;
+
+        INSERT INTO public.prompt_versions (prompt_id, version, name, description, prompt, created_at)
+            SELECT id, 1, name, description, prompt, COALESCE(updated_at, created_at, now())
+            FROM public.prompts;
+
+        -- The versions are saved by triggers so the prompts edited by hand in the database
+        -- are versioned too
+        CREATE FUNCTION public.bump_prompt_version() RETURNS trigger
+            LANGUAGE plpgsql
+            AS $$
+            BEGIN
+                IF NEW.prompt IS DISTINCT FROM OLD.prompt
+                    OR NEW.name IS DISTINCT FROM OLD.name
+                    OR NEW.description IS DISTINCT FROM OLD.description THEN
+                    NEW.version := OLD.version + 1;
+                ELSE
+                    NEW.version := OLD.version;
+                END IF;
+                RETURN NEW;
+            END;
+            $$;
+        CREATE TRIGGER prompts_bump_version BEFORE UPDATE ON public.prompts
+            FOR EACH ROW EXECUTE FUNCTION public.bump_prompt_version();
+
+        CREATE FUNCTION public.save_prompt_version() RETURNS trigger
+            LANGUAGE plpgsql
+            AS $$
+            BEGIN
+                INSERT INTO public.prompt_versions (prompt_id, version, name, description, prompt)
+                    VALUES (NEW.id, NEW.version, NEW.name, NEW.description, NEW.prompt)
+                    ON CONFLICT (prompt_id, version) DO NOTHING;
+                RETURN NULL;
+            END;
+            $$;
+        CREATE TRIGGER prompts_save_version AFTER INSERT OR UPDATE ON public.prompts
+            FOR EACH ROW EXECUTE FUNCTION public.save_prompt_version();
+
+        CREATE FUNCTION public.prevent_prompt_version_update() RETURNS trigger
+            LANGUAGE plpgsql
+            AS $$
+            BEGIN
+                RAISE EXCEPTION 'The prompt versions are immutable';
+            END;
+            $$;
+        CREATE TRIGGER prompt_versions_immutable BEFORE UPDATE ON public.prompt_versions
+            FOR EACH ROW EXECUTE FUNCTION public.prevent_prompt_version_update();
+
+        CREATE TABLE public.prompt_experiments (
+            id uuid DEFAULT gen_random_uuid() NOT NULL,
+            prompt_id uuid NOT NULL,
+            project_id uuid,
+            name text NOT NULL,
+            variants jsonb NOT NULL,
+            active boolean DEFAULT true NOT NULL,
+            created_at timestamp with time zone DEFAULT now() NOT NULL,
+            stopped_at timestamp with time zone
+        );
+        ALTER TABLE ONLY public.prompt_experiments
+            ADD CONSTRAINT prompt_experiments_pkey PRIMARY KEY (id);
+        ALTER TABLE ONLY public.prompt_experiments
+            ADD CONSTRAINT prompt_experiments_prompt_id_fkey FOREIGN KEY (prompt_id) REFERENCES public.prompts(id) ON DELETE CASCADE;
+        ALTER TABLE ONLY public.prompt_experiments
+            ADD CONSTRAINT prompt_experiments_project_id_fkey FOREIGN KEY (project_id) REFERENCES public.projects(id) ON DELETE CASCADE;
+        CREATE INDEX prompt_experiments_active_idx ON public.prompt_experiments USING btree (prompt_id)
+            WHERE active;
+
+        ALTER TABLE public.chats ADD system_prompt_version integer;
+        ALTER TABLE public.events ADD prompt_version integer;
+    """)
+
+    if rls:
+        cur.execute("""
+            ALTER TABLE public.prompt_versions OWNER TO postgres;
+            ALTER TABLE public.prompt_versions ENABLE ROW LEVEL SECURITY;
+            ALTER TABLE public.prompt_experiments OWNER TO postgres;
+            ALTER TABLE public.prompt_experiments ENABLE ROW LEVEL SECURITY;
+        """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE public.events DROP COLUMN prompt_version;
+        ALTER TABLE public.chats DROP COLUMN system_prompt_version;
+
+        DROP TABLE public.prompt_experiments;
+
+        DROP TRIGGER prompts_save_version ON public.prompts;
+        DROP TRIGGER prompts_bump_version ON public.prompts;
+        DROP TABLE public.prompt_versions;
+        DROP FUNCTION public.prevent_prompt_version_update;
+        DROP FUNCTION public.save_prompt_version;
+        DROP FUNCTION public.bump_prompt_version;
+
+        ALTER TABLE public.prompts DROP COLUMN version;
+    """)
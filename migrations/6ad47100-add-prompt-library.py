@@ -0,0 +1,27 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+        DELETE FROM public.prompts_likes a USING public.prompts_likes b
+            WHERE a.prompt_id = b.prompt_id AND a.user_id = b.user_id AND a.ctid > b.ctid;
+        ALTER TABLE ONLY public.prompts_likes
+            ADD CONSTRAINT prompts_likes_prompt_id_user_id_key UNIQUE (prompt_id, user_id);
+
+        ALTER TABLE public.prompts ADD forked_from uuid;
+        ALTER TABLE ONLY public.prompts
+            ADD CONSTRAINT prompts_forked_from_fkey FOREIGN KEY (forked_from) REFERENCES public.prompts(id) ON DELETE SET NULL;
+
+        ALTER TABLE public.prompts ADD search tsvector
+            GENERATED ALWAYS AS (
+                to_tsvector('english', coalesce(name, '') || ' ' || coalesce(description, ''))
+            ) STORED;
+        CREATE INDEX prompts_search_idx ON public.prompts USING gin (search);
+        CREATE INDEX prompts_tags_idx ON public.prompts USING gin (tags);
+    """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+        DROP INDEX public.prompts_tags_idx;
+        DROP INDEX public.prompts_search_idx;
+        ALTER TABLE public.prompts DROP COLUMN search;
+        ALTER TABLE public.prompts DROP COLUMN forked_from;
+        ALTER TABLE public.prompts_likes DROP CONSTRAINT prompts_likes_prompt_id_user_id_key;
+    """)
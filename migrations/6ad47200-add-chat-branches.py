@@ -0,0 +1,32 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE public.chat_messages ADD parent_id uuid;
+        ALTER TABLE ONLY public.chat_messages
+            ADD CONSTRAINT chat_messages_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES public.chat_messages(id) ON DELETE CASCADE;
+        CREATE INDEX chat_message_parent_id ON public.chat_messages USING btree (parent_id);
+
+        ALTER TABLE public.chats ADD active_message_id uuid;
+        ALTER TABLE ONLY public.chats
+            ADD CONSTRAINT chats_active_message_id_fkey FOREIGN KEY (active_message_id) REFERENCES public.chat_messages(id) ON DELETE SET NULL;
+
+        -- The messages of the existing chats form a single branch
+        UPDATE public.chat_messages m SET parent_id = p.previous_id
+            FROM (
+                SELECT id, lag(id) OVER (PARTITION BY chat_id ORDER BY created_at, id) AS previous_id
+                FROM public.chat_messages
+            ) p
+            WHERE m.id = p.id;
+        UPDATE public.chats c SET active_message_id = (
+            SELECT id FROM public.chat_messages
+                WHERE chat_id = c.id
+                ORDER BY created_at DESC, id DESC
+                LIMIT 1
+        );
+    """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE public.chats DROP COLUMN active_message_id;
+        DROP INDEX public.chat_message_parent_id;
+        ALTER TABLE public.chat_messages DROP COLUMN parent_id;
+    """)
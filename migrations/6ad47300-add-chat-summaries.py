@@ -0,0 +1,10 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+        -- The summary of the branch of the chat ending with the message
+        ALTER TABLE public.chat_messages ADD summary text;
+    """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE public.chat_messages DROP COLUMN summary;
+    """)
@@ -0,0 +1,30 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+        CREATE TABLE public.chat_shares (
+            id uuid DEFAULT gen_random_uuid() NOT NULL,
+            chat_id uuid NOT NULL,
+            user_id uuid NOT NULL,
+            token text NOT NULL,
+            created_at timestamp with time zone DEFAULT now() NOT NULL,
+            expires_at timestamp with time zone,
+            revoked_at timestamp with time zone
+        );
+        ALTER TABLE ONLY public.chat_shares
+            ADD CONSTRAINT chat_shares_pkey PRIMARY KEY (id);
+        ALTER TABLE ONLY public.chat_shares
+            ADD CONSTRAINT chat_shares_token_key UNIQUE (token);
+        ALTER TABLE ONLY public.chat_shares
+            ADD CONSTRAINT chat_shares_chat_id_fkey FOREIGN KEY (chat_id) REFERENCES public.chats(id) ON DELETE CASCADE;
+        CREATE INDEX chat_share_chat_id ON public.chat_shares USING btree (chat_id);
+    """)
+
+    if rls:
+        cur.execute("""
+            ALTER TABLE public.chat_shares OWNER TO postgres;
+            ALTER TABLE public.chat_shares ENABLE ROW LEVEL SECURITY;
+        """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+        DROP TABLE public.chat_shares;
+    """)
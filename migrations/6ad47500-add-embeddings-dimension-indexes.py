@@ -0,0 +1,93 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+    -- The embedding column has no fixed dimension since the memories can use different
+    -- models, so each supported dimension gets its own partial index on a cast of the
+    -- column. ivfflat can't index more than 2000 dimensions, the 3072 dimensions of
+    -- text-embedding-3-large are still searched sequentially.
+    CREATE INDEX embeddings_embedding_1536_idx ON public.embeddings
+      USING ivfflat ((embedding::vector(1536)) vector_cosine_ops) WITH (lists='100')
+      WHERE vector_dims(embedding) = 1536;
+    CREATE INDEX embeddings_embedding_1024_idx ON public.embeddings
+      USING ivfflat ((embedding::vector(1024)) vector_cosine_ops) WITH (lists='100')
+      WHERE vector_dims(embedding) = 1024;
+
+    DROP FUNCTION retrieve_embeddings;
+    CREATE OR REPLACE FUNCTION public.retrieve_embeddings(query_embedding vector, match_threshold double precision, match_count integer, memoryid uuid[], userid text)
+     RETURNS TABLE(id uuid, content text, similarity double precision, metadatas json)
+     LANGUAGE plpgsql
+     STABLE
+    AS $function$
+    BEGIN
+            -- The dimension is written in the query so the cast and the filter match the
+            -- ones of the partial index of this dimension. The nearest embeddings are
+            -- selected first, the threshold is applied on them.
+            RETURN QUERY EXECUTE format($query$
+              SELECT
+                matching.id,
+                matching.content,
+                matching.similarity,
+                matching.metadatas
+              FROM (
+                SELECT
+                  embeddings.id,
+                  embeddings.content,
+                  1 - (embeddings.embedding::vector(%1$s) <=> $1::vector(%1$s)) as similarity,
+                  embeddings.metadatas
+                FROM embeddings
+                JOIN memories ON embeddings.memory_id = memories.id
+                WHERE
+                  vector_dims(embeddings.embedding) = %1$s
+                  AND embeddings.memory_id = ANY($4)
+                  AND (
+                    memories.user_id::text = $5
+                    OR memories.public = true
+                  )
+                ORDER BY embeddings.embedding::vector(%1$s) <=> $1::vector(%1$s)
+                LIMIT $3
+              ) matching
+              WHERE matching.similarity > $2
+              ORDER BY matching.similarity DESC
+            $query$, vector_dims(query_embedding))
+            USING query_embedding, match_threshold, match_count, memoryid, userid;
+    END;
+     $function$;
+    """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+    DROP FUNCTION retrieve_embeddings;
+    CREATE OR REPLACE FUNCTION public.retrieve_embeddings(query_embedding vector, match_threshold double precision, match_count integer, memoryid uuid[], userid text)
+     RETURNS TABLE(id uuid, content text, similarity double precision, metadatas json)
+     LANGUAGE sql
+     STABLE
+    AS $function$
+            SELECT
+              matching.id,
+              matching.content,
+              matching.similarity,
+              matching.metadatas
+            FROM (
+              SELECT
+                embeddings.id,
+                embeddings.content,
+                1 - (embeddings.embedding <=> query_embedding) as similarity,
+                embeddings.metadatas
+              FROM embeddings
+              JOIN memories ON embeddings.memory_id = memories.id
+              WHERE
+                embeddings.memory_id = ANY(memoryid)
+                AND memories.embedding_dimension = vector_dims(query_embedding)
+                AND (
+                  memories.user_id::text = userid
+                  OR memories.public = true
+                )
+              OFFSET 0
+            ) matching
+            WHERE matching.similarity > match_threshold
+            ORDER BY matching.similarity DESC
+            LIMIT match_count;
+     $function$;
+
+    DROP INDEX embeddings_embedding_1024_idx;
+    DROP INDEX embeddings_embedding_1536_idx;
+    """)
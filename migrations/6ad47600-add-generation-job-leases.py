@@ -0,0 +1,13 @@
+def migrate(cur, rls=False):
+    cur.execute("""
+        -- The server running a job holds it until lease_until, so the other servers
+        -- resuming their unfinished jobs don't run it too
+        ALTER TABLE public.generation_jobs ADD claimed_by text;
+        ALTER TABLE public.generation_jobs ADD lease_until timestamp with time zone;
+    """)
+
+def rollback(cur, rls=False):
+    cur.execute("""
+        ALTER TABLE public.generation_jobs DROP COLUMN lease_until;
+        ALTER TABLE public.generation_jobs DROP COLUMN claimed_by;
+    """)
@@ -0,0 +1,214 @@
+package prompt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	router "github.com/julienschmidt/httprouter"
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/utils"
+)
+
+const (
+	DefaultLibraryLimit = 20
+	MaxLibraryLimit     = 100
+)
+
+func parseLibraryFilters(r *http.Request) database.PublicPromptFilters {
+	query := r.URL.Query()
+
+	limit := DefaultLibraryLimit
+	if val, err := strconv.Atoi(query.Get("limit")); err == nil && val > 0 {
+		limit = val
+	}
+	if limit > MaxLibraryLimit {
+		limit = MaxLibraryLimit
+	}
+
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	return database.PublicPromptFilters{
+		Tag:    query.Get("tag"),
+		Search: query.Get("q"),
+		Sort:   query.Get("sort"),
+		Limit:  limit,
+		Offset: offset,
+	}
+}
+
+// ListPublic browses the public prompts of every user, see parseLibraryFilters for the params
+func ListPublic(w http.ResponseWriter, r *http.Request, _ router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	prompts, err := db.ListPublicPrompts(userID, parseLibraryFilters(r))
+	if err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	if prompts == nil {
+		prompts = []database.PromptWithLikes{}
+	}
+
+	response, _ := json.Marshal(prompts)
+	record(string(response))
+
+	_, _ = w.Write(response)
+}
+
+// getVisiblePrompt returns the prompt if it is public or owned by the user
+func getVisiblePrompt(db database.Database, userID string, id string) *database.Prompt {
+	p, err := db.GetPromptByIDOrSlug(id)
+	if err != nil || p == nil || (!p.Public && p.UserID != userID) {
+		return nil
+	}
+
+	return p
+}
+
+func respondLikes(
+	w http.ResponseWriter,
+	record utils.RecordFunc,
+	db database.Database,
+	userID string,
+	promptID string,
+) {
+	likesCount, userHasLiked, err := db.GetPromptLikes(userID, promptID)
+	if err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	response, _ := json.Marshal(struct {
+		LikesCount   int  `json:"likes_count"`
+		UserHasLiked bool `json:"user_has_liked"`
+	}{likesCount, userHasLiked})
+	record(string(response))
+
+	_, _ = w.Write(response)
+}
+
+// Like and Unlike can be repeated, a user likes a prompt at most once
+func Like(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	p := getVisiblePrompt(db, userID, ps.ByName("id"))
+	if p == nil {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	if err := db.LikePrompt(userID, p.ID); err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	respondLikes(w, record, db, userID, p.ID)
+}
+
+func Unlike(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	p := getVisiblePrompt(db, userID, ps.ByName("id"))
+	if p == nil {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	if err := db.UnlikePrompt(userID, p.ID); err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	respondLikes(w, record, db, userID, p.ID)
+}
+
+// forkContent copies the version of the prompt pinned by the reference, or its latest one
+func forkContent(db database.Database, p *database.Prompt, version *int) *database.PromptInsert {
+	fork := &database.PromptInsert{
+		Name:        p.Name,
+		Description: p.Description,
+		Prompt:      p.Prompt,
+		Tags:        p.Tags,
+		ForkedFrom:  &p.ID,
+	}
+
+	if version != nil && *version != p.Version {
+		pv, err := db.GetPromptVersion(p.ID, *version)
+		if err != nil || pv == nil {
+			return nil
+		}
+		fork.Name, fork.Description, fork.Prompt = pv.Name, pv.Description, pv.Prompt
+	}
+
+	return fork
+}
+
+/*
+ * Fork copies a public prompt, or a version of it with "slug@version", into the prompts
+ * of the user. The copy is private and has no slug. As the names are unique, it is
+ * suffixed unless the body sets another one.
+ */
+func Fork(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	projectID, _ := r.Context().Value(utils.ContextKeyProjectID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && err != io.EOF {
+		utils.RespondError(w, record, "decode_error")
+		return
+	}
+
+	idOrSlug, version, err := database.ParsePromptRef(ps.ByName("id"))
+	if err != nil {
+		utils.RespondError(w, record, "invalid_prompt_version")
+		return
+	}
+
+	p := getVisiblePrompt(db, userID, idOrSlug)
+	if p == nil {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	fork := forkContent(db, p, version)
+	if fork == nil {
+		utils.RespondError(w, record, "prompt_version_not_found")
+		return
+	}
+
+	if input.Name != "" {
+		fork.Name = input.Name
+	} else {
+		fork.Name = fmt.Sprintf("%s (fork %s)", fork.Name, uuid.New().String()[:8])
+	}
+
+	forked, err := db.CreatePrompt(userID, projectID, *fork)
+	if err != nil {
+		respondDatabaseError(w, record, err)
+		return
+	}
+
+	response, _ := json.Marshal(forked)
+	record(string(response))
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(response)
+}
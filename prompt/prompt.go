@@ -0,0 +1,183 @@
+package prompt
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+
+	router "github.com/julienschmidt/httprouter"
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/utils"
+	"gorm.io/gorm"
+)
+
+func isValidSlug(slug *string) bool {
+	if slug == nil {
+		return true
+	}
+
+	// A slug looking like a UUID couldn't be told apart from an id
+	matchUUID, _ := regexp.MatchString(database.UUIDRegexp, *slug)
+	matchSlug, _ := regexp.MatchString(database.SlugRegexp, *slug)
+
+	return *slug != "" && matchSlug && !matchUUID
+}
+
+func isEmpty(s *string) bool {
+	return s != nil && *s == ""
+}
+
+// getOwnedPrompt returns the prompt only to its user, the others get a not found
+func getOwnedPrompt(db database.Database, userID string, id string) *database.Prompt {
+	p, err := db.GetPromptByIDOrSlug(id)
+	if err != nil || p == nil || p.UserID != userID {
+		return nil
+	}
+
+	return p
+}
+
+func respondDatabaseError(w http.ResponseWriter, record utils.RecordFunc, err error) {
+	if errors.Is(err, database.ErrPromptAlreadyExists) {
+		utils.RespondError(w, record, "prompt_already_exists")
+		return
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	utils.RespondError(w, record, "database_error")
+}
+
+func Get(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	p, err := db.GetPromptByIDOrSlug(ps.ByName("id"))
+	if err != nil || p == nil || (!p.Public && p.UserID != userID) {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	response, _ := json.Marshal(p)
+	record(string(response))
+
+	_, _ = w.Write(response)
+}
+
+func List(w http.ResponseWriter, r *http.Request, _ router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	prompts, err := db.ListPrompts(userID)
+	if err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	response, _ := json.Marshal(prompts)
+	record(string(response))
+
+	_, _ = w.Write(response)
+}
+
+func Create(w http.ResponseWriter, r *http.Request, _ router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	projectID, _ := r.Context().Value(utils.ContextKeyProjectID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	var input database.PromptInsert
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		utils.RespondError(w, record, "decode_error")
+		return
+	}
+
+	if input.Name == "" || input.Prompt == "" {
+		utils.RespondError(w, record, "invalid_prompt")
+		return
+	}
+
+	if !isValidSlug(input.Slug) {
+		utils.RespondError(w, record, "invalid_slug")
+		return
+	}
+
+	p, err := db.CreatePrompt(userID, projectID, input)
+	if err != nil {
+		respondDatabaseError(w, record, err)
+		return
+	}
+
+	response, _ := json.Marshal(p)
+	record(string(response))
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(response)
+}
+
+func Update(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	var input database.PromptUpdate
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		utils.RespondError(w, record, "decode_error")
+		return
+	}
+
+	if isEmpty(input.Name) || isEmpty(input.Prompt) {
+		utils.RespondError(w, record, "invalid_prompt")
+		return
+	}
+
+	if !isValidSlug(input.Slug) {
+		utils.RespondError(w, record, "invalid_slug")
+		return
+	}
+
+	p := getOwnedPrompt(db, userID, ps.ByName("id"))
+	if p == nil {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	p, err := db.UpdatePrompt(userID, p.ID, input)
+	if err != nil {
+		respondDatabaseError(w, record, err)
+		return
+	}
+
+	response, _ := json.Marshal(p)
+	record(string(response))
+
+	_, _ = w.Write(response)
+}
+
+func Delete(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	p := getOwnedPrompt(db, userID, ps.ByName("id"))
+	if p == nil {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	err := db.DeletePrompt(userID, p.ID)
+	if err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	record("[Empty Response]")
+
+	_, _ = w.Write([]byte("{\"success\":true}"))
+}
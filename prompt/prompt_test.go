@@ -0,0 +1,154 @@
+package prompt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	router "github.com/julienschmidt/httprouter"
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/utils"
+	"gorm.io/gorm"
+)
+
+func TestOwnership(t *testing.T) {
+	prompts := map[string]*database.Prompt{
+		"mine":    {ID: "1", UserID: "user", Slug: "mine"},
+		"private": {ID: "2", UserID: "other", Slug: "private"},
+		"public":  {ID: "3", UserID: "other", Slug: "public", Public: true},
+	}
+
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, database.MockDatabase{
+		MockGetPromptByIDOrSlug: func(id string) (*database.Prompt, error) {
+			return prompts[id], nil
+		},
+		MockUpdatePrompt: func(_ string, _ string, _ database.PromptUpdate) (*database.Prompt, error) {
+			return prompts["mine"], nil
+		},
+	})
+	ctx = context.WithValue(ctx, utils.ContextKeyUserID, "user")
+	ctx = context.WithValue(ctx, utils.ContextKeyRecordEvent, utils.RecordFunc(
+		func(_ string, _ ...utils.KeyValue) {},
+	))
+
+	tests := []struct {
+		handler router.Handle
+		id      string
+		status  int
+	}{
+		{Get, "mine", http.StatusOK},
+		{Get, "private", http.StatusNotFound},
+		{Get, "public", http.StatusOK},
+		{Update, "mine", http.StatusOK},
+		{Update, "public", http.StatusNotFound},
+		{Delete, "private", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("PUT", "/prompt/"+test.id, strings.NewReader(`{"name": "New"}`))
+		w := httptest.NewRecorder()
+
+		test.handler(w, r.WithContext(ctx), router.Params{{Key: "id", Value: test.id}})
+
+		if w.Code != test.status {
+			t.Fatalf("%s: expected %d, got %d", test.id, test.status, w.Code)
+		}
+	}
+}
+
+func TestIsValidSlug(t *testing.T) {
+	slug := func(s string) *string { return &s }
+
+	if !isValidSlug(nil) || !isValidSlug(slug("my-prompt_2")) {
+		t.Fatal("Valid slugs should be accepted")
+	}
+
+	for _, s := range []string{"", "My Prompt", "0b5e6a1c-1d2e-4f3a-8b4c-5d6e7f8a9b0c"} {
+		if isValidSlug(slug(s)) {
+			t.Fatalf("%q should be rejected", s)
+		}
+	}
+}
+
+func TestExperimentOwnership(t *testing.T) {
+	ctx := context.WithValue(context.Background(), utils.ContextKeyDB, database.MockDatabase{
+		MockGetPromptByIDOrSlug: func(id string) (*database.Prompt, error) {
+			return &database.Prompt{ID: id, UserID: "user"}, nil
+		},
+		MockGetProjectUserByID: func(id string) (*database.ProjectUser, error) {
+			return &database.ProjectUser{ID: id, AuthID: "dev"}, nil
+		},
+		MockGetProjectByID: func(id string) (*database.Project, error) {
+			return &database.Project{ID: id, AuthID: "other-dev"}, nil
+		},
+		MockStopPromptExperiment: func(_ string, _ string) error {
+			return gorm.ErrRecordNotFound
+		},
+	})
+	ctx = context.WithValue(ctx, utils.ContextKeyUserID, "user")
+	ctx = context.WithValue(ctx, utils.ContextKeyRecordEvent, utils.RecordFunc(
+		func(_ string, _ ...utils.KeyValue) {},
+	))
+
+	body := `{"name": "Test", "project_id": "theirs", "variants": [{"version": 1, "weight": 100}]}`
+	r := httptest.NewRequest("POST", "/prompt/1/experiments", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	CreateExperiment(w, r.WithContext(ctx), router.Params{{Key: "id", Value: "1"}})
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("The project of another user can't be used, got %d", w.Code)
+	}
+
+	r = httptest.NewRequest("DELETE", "/prompt/1/experiments/missing", nil)
+	w = httptest.NewRecorder()
+	StopExperiment(w, r.WithContext(ctx), router.Params{
+		{Key: "id", Value: "1"},
+		{Key: "experiment_id", Value: "missing"},
+	})
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Stopping a missing experiment should fail, got %d", w.Code)
+	}
+}
+
+func TestForkVersion(t *testing.T) {
+	db := database.MockDatabase{
+		MockGetPromptVersion: func(_ string, version int) (*database.PromptVersion, error) {
+			if version != 1 {
+				return nil, errors.New("not found")
+			}
+			return &database.PromptVersion{Version: 1, Name: "Old", Prompt: "Old prompt"}, nil
+		},
+	}
+	p := &database.Prompt{ID: "1", Name: "New", Prompt: "New prompt", Version: 2, Public: true}
+
+	one, two, three := 1, 2, 3
+
+	if fork := forkContent(db, p, &one); fork == nil || fork.Prompt != "Old prompt" {
+		t.Fatalf("The pinned version should be forked, got %v", fork)
+	}
+	if fork := forkContent(db, p, &two); fork == nil || fork.Prompt != "New prompt" {
+		t.Fatalf("The latest version should be forked, got %v", fork)
+	}
+	if fork := forkContent(db, p, nil); fork == nil || *fork.ForkedFrom != "1" || fork.Public {
+		t.Fatalf("The fork should be a private copy, got %v", fork)
+	}
+	if forkContent(db, p, &three) != nil {
+		t.Fatal("A missing version can't be forked")
+	}
+}
+
+func TestParseLibraryFilters(t *testing.T) {
+	r := httptest.NewRequest("GET", "/prompts/public?tag=code&q=sql+expert&limit=1000&offset=-3", nil)
+	filters := parseLibraryFilters(r)
+
+	if filters.Tag != "code" || filters.Search != "sql expert" {
+		t.Fatalf("Unexpected filters %v", filters)
+	}
+	if filters.Limit != MaxLibraryLimit || filters.Offset != 0 {
+		t.Fatalf("The pagination should be bounded, got %v", filters)
+	}
+}
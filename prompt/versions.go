@@ -0,0 +1,172 @@
+package prompt
+
+import (
+	"encoding/json"
+	"net/http"
+
+	router "github.com/julienschmidt/httprouter"
+	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/utils"
+)
+
+func ListVersions(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	p, err := db.GetPromptByIDOrSlug(ps.ByName("id"))
+	if err != nil || p == nil || (!p.Public && p.UserID != userID) {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	versions, err := db.ListPromptVersions(p.ID)
+	if err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	response, _ := json.Marshal(versions)
+	record(string(response))
+
+	_, _ = w.Write(response)
+}
+
+func ListExperiments(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	p := getOwnedPrompt(db, userID, ps.ByName("id"))
+	if p == nil {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	experiments, err := db.ListPromptExperiments(p.ID)
+	if err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	response, _ := json.Marshal(experiments)
+	record(string(response))
+
+	_, _ = w.Write(response)
+}
+
+// The variants must be existing versions of the prompt and share the whole traffic
+func isValidExperiment(
+	db database.Database,
+	promptID string,
+	name string,
+	variants []database.PromptExperimentVariant,
+) bool {
+	if name == "" || len(variants) == 0 {
+		return false
+	}
+
+	total := 0
+	for _, variant := range variants {
+		if variant.Weight <= 0 {
+			return false
+		}
+		total += variant.Weight
+
+		if _, err := db.GetPromptVersion(promptID, variant.Version); err != nil {
+			return false
+		}
+	}
+
+	return total == 100
+}
+
+// The experiments can only be scoped to the projects of the user
+func getOwnedProject(db database.Database, userID string, projectID string) *database.Project {
+	user, err := db.GetProjectUserByID(userID)
+	if err != nil || user == nil {
+		return nil
+	}
+
+	project, err := db.GetProjectByID(projectID)
+	if err != nil || project == nil || project.AuthID != user.AuthID {
+		return nil
+	}
+
+	return project
+}
+
+/*
+ * CreateExperiment splits the traffic of the requests that don't pin a version of the
+ * prompt between some of its versions. It only applies to the users of the project when
+ * project_id is set, and replaces the experiment running with the same scope.
+ */
+func CreateExperiment(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	var input struct {
+		Name      string                             `json:"name"`
+		ProjectID *string                            `json:"project_id,omitempty"`
+		Variants  []database.PromptExperimentVariant `json:"variants"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		utils.RespondError(w, record, "decode_error")
+		return
+	}
+
+	p := getOwnedPrompt(db, userID, ps.ByName("id"))
+	if p == nil {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	if input.ProjectID != nil {
+		project := getOwnedProject(db, userID, *input.ProjectID)
+		if project == nil {
+			utils.RespondError(w, record, "not_found")
+			return
+		}
+		input.ProjectID = &project.ID
+	}
+
+	if !isValidExperiment(db, p.ID, input.Name, input.Variants) {
+		utils.RespondError(w, record, "invalid_experiment")
+		return
+	}
+
+	experiment, err := db.CreatePromptExperiment(p.ID, input.ProjectID, input.Name, input.Variants)
+	if err != nil {
+		utils.RespondError(w, record, "database_error")
+		return
+	}
+
+	response, _ := json.Marshal(experiment)
+	record(string(response))
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(response)
+}
+
+func StopExperiment(w http.ResponseWriter, r *http.Request, ps router.Params) {
+	db := r.Context().Value(utils.ContextKeyDB).(database.Database)
+	userID := r.Context().Value(utils.ContextKeyUserID).(string)
+	record := r.Context().Value(utils.ContextKeyRecordEvent).(utils.RecordFunc)
+
+	p := getOwnedPrompt(db, userID, ps.ByName("id"))
+	if p == nil {
+		utils.RespondError(w, record, "not_found")
+		return
+	}
+
+	err := db.StopPromptExperiment(p.ID, ps.ByName("experiment_id"))
+	if err != nil {
+		respondDatabaseError(w, record, err)
+		return
+	}
+
+	record("[Empty Response]")
+
+	_, _ = w.Write([]byte("{\"success\":true}"))
+}
@@ -0,0 +1,233 @@
+package registry
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultAlias is the model used when a request doesn't ask for one.
+const DefaultAlias = "gpt-3.5-turbo"
+
+const ReloadInterval = 1 * time.Minute
+
+type Capabilities struct {
+	Stream bool `json:"stream"`
+	JSON   bool `json:"json"`
+	Tools  bool `json:"tools"`
+	Vision bool `json:"vision"`
+	// Structured output following a JSON schema, not only a JSON object
+	JSONSchema bool `json:"json_schema"`
+}
+
+/*
+ * Pricing is expressed in credits. Input and Output are per token, Request is a flat
+ * price per request (e.g. for the image models) and Second is per second of compute
+ * (for replicate). The per token prices can be fractional, the total is rounded up.
+ */
+type Pricing struct {
+	Input   float64 `json:"input"`
+	Output  float64 `json:"output"`
+	Request int     `json:"request"`
+	Second  float64 `json:"second"`
+}
+
+type Model struct {
+	Aliases       []string     `json:"aliases"`
+	Provider      string       `json:"provider"`
+	Model         string       `json:"model"`
+	Version       string       `json:"version,omitempty"`
+	ContextWindow int          `json:"context_window"`
+	Pricing       Pricing      `json:"pricing"`
+	Capabilities  Capabilities `json:"capabilities"`
+	// Size of the vectors of the embedding models
+	Dimension int `json:"dimension,omitempty"`
+}
+
+func (m Model) Credits(inputTokenCount int, outputTokenCount int) int {
+	return m.Pricing.Request + int(math.Ceil(
+		float64(inputTokenCount)*m.Pricing.Input+float64(outputTokenCount)*m.Pricing.Output,
+	))
+}
+
+type modelKey struct {
+	provider string
+	model    string
+}
+
+type Registry struct {
+	mutex    sync.RWMutex
+	aliases  map[string]Model
+	upstream map[modelKey]Model
+}
+
+func New(models []Model) *Registry {
+	r := &Registry{}
+	r.Set(models)
+	return r
+}
+
+// Set replaces the content of the registry. When two entries claim the same alias or
+// the same upstream model, the last one wins.
+func (r *Registry) Set(models []Model) {
+	aliases := make(map[string]Model)
+	upstream := make(map[modelKey]Model)
+
+	for _, m := range models {
+		upstream[modelKey{m.Provider, m.Model}] = m
+		for _, alias := range m.Aliases {
+			aliases[alias] = m
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.aliases = aliases
+	r.upstream = upstream
+}
+
+// Resolve returns the model a public alias points to.
+func (r *Registry) Resolve(alias string) (Model, bool) {
+	if alias == "" {
+		alias = DefaultAlias
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	m, ok := r.aliases[alias]
+	return m, ok
+}
+
+// Find returns the entry of an upstream model.
+func (r *Registry) Find(provider string, model string) (Model, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	m, ok := r.upstream[modelKey{provider, model}]
+	return m, ok
+}
+
+// Credits returns the price of a request, unknown models are free.
+func (r *Registry) Credits(
+	provider string,
+	model string,
+	inputTokenCount int,
+	outputTokenCount int,
+) int {
+	m, ok := r.Find(provider, model)
+	if !ok {
+		log.Printf("[WARNING] No pricing for %s/%s", provider, model)
+		return 0
+	}
+
+	return m.Credits(inputTokenCount, outputTokenCount)
+}
+
+//go:embed models.json
+var defaultModelsJSON []byte
+
+func DefaultModels() []Model {
+	var models []Model
+	err := json.Unmarshal(defaultModelsJSON, &models)
+	if err != nil {
+		panic(err)
+	}
+	return models
+}
+
+var defaultRegistry = New(DefaultModels())
+
+func Resolve(alias string) (Model, bool) {
+	return defaultRegistry.Resolve(alias)
+}
+
+func Find(provider string, model string) (Model, bool) {
+	return defaultRegistry.Find(provider, model)
+}
+
+func Credits(provider string, model string, inputTokenCount int, outputTokenCount int) int {
+	return defaultRegistry.Credits(provider, model, inputTokenCount, outputTokenCount)
+}
+
+// A Source returns the models that are added to, or override, the default ones.
+type Source func() ([]Model, error)
+
+func FileSource(path string) Source {
+	return func() ([]Model, error) {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var models []Model
+		err = json.Unmarshal(content, &models)
+		if err != nil {
+			return nil, err
+		}
+
+		return models, nil
+	}
+}
+
+/*
+ * Merge puts the overrides on top of the base models. An override replaces the base
+ * entry of the same upstream model and takes its aliases from any other entry.
+ */
+func Merge(base []Model, overrides []Model) []Model {
+	overridden := make(map[modelKey]bool)
+	for _, m := range overrides {
+		overridden[modelKey{m.Provider, m.Model}] = true
+	}
+
+	var result []Model
+	for _, m := range base {
+		if !overridden[modelKey{m.Provider, m.Model}] {
+			result = append(result, m)
+		}
+	}
+
+	return append(result, overrides...)
+}
+
+// Load replaces the default registry by the embedded models merged with the source's.
+// On error the registry is left untouched.
+func Load(source Source) error {
+	models, err := source()
+	if err != nil {
+		return err
+	}
+
+	defaultRegistry.Set(Merge(DefaultModels(), models))
+
+	return nil
+}
+
+// Watch loads the source every interval until the context is done.
+func Watch(ctx context.Context, source Source, interval time.Duration) {
+	if err := Load(source); err != nil {
+		log.Println("[WARNING] Could not load the model registry: ", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := Load(source); err != nil {
+					log.Println("[WARNING] Could not reload the model registry: ", err)
+				}
+			}
+		}
+	}()
+}
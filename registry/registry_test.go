@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/polyfire/api/utils"
+)
+
+func TestDefaultModels(t *testing.T) {
+	r := New(DefaultModels())
+
+	m, ok := r.Resolve("")
+	if !ok || m.Provider != "openai" || m.Model != "gpt-3.5-turbo" {
+		t.Fatalf("The default alias should resolve to openai/gpt-3.5-turbo, got %v", m)
+	}
+
+	m, ok = r.Resolve("uncensored")
+	if !ok || m.Version == "" || m.Capabilities.Stream {
+		t.Fatalf("uncensored should be a non streaming replicate model, got %v", m)
+	}
+
+	if credits := r.Credits("openai", "gpt-4", 10, 20); credits != 10*300+20*600 {
+		t.Fatalf("Expected %d credits, got %d", 10*300+20*600, credits)
+	}
+
+	if credits := r.Credits("openai", "dall-e-3", 0, 0); credits != 800000 {
+		t.Fatalf("Expected 800000 credits, got %d", credits)
+	}
+}
+
+func TestLoadOverridesDefaults(t *testing.T) {
+	utils.SetLogLevel("WARN")
+
+	path := filepath.Join(t.TempDir(), "models.json")
+	err := os.WriteFile(path, []byte(`[
+		{
+			"aliases": ["best", "mixtral"],
+			"provider": "openrouter",
+			"model": "mistralai/mixtral-8x7b-instruct",
+			"context_window": 32768,
+			"pricing": { "input": 3, "output": 3 },
+			"capabilities": { "stream": true }
+		},
+		{
+			"aliases": ["gpt-4"],
+			"provider": "openai",
+			"model": "gpt-4",
+			"pricing": { "input": 1, "output": 1 },
+			"capabilities": { "stream": true }
+		}
+	]`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer defaultRegistry.Set(DefaultModels())
+
+	err = Load(FileSource(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, ok := Resolve("best")
+	if !ok || m.Model != "mistralai/mixtral-8x7b-instruct" {
+		t.Fatalf("The override should take the alias, got %v", m)
+	}
+
+	if credits := Credits("openai", "gpt-4", 10, 20); credits != 30 {
+		t.Fatalf("The override should replace the default pricing, got %d", credits)
+	}
+
+	if _, ok := Resolve("claude-3-opus"); !ok {
+		t.Fatal("The default models should be kept")
+	}
+
+	err = Load(FileSource(filepath.Join(t.TempDir(), "missing.json")))
+	if err == nil {
+		t.Fatal("Loading a missing file should fail")
+	}
+
+	if _, ok := Resolve("mixtral"); !ok {
+		t.Fatal("A failed load should leave the registry untouched")
+	}
+}
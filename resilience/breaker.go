@@ -0,0 +1,170 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("Circuit breaker is open")
+
+// StatusError gives the HTTP status of an error returned by an SDK to the circuit breaker.
+type StatusError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d: %v", e.StatusCode, e.Err)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+const (
+	DefaultFailureThreshold = 5
+	DefaultOpenDuration     = 30 * time.Second
+)
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+/*
+ * CircuitBreaker counts the consecutive failures of a provider. Once the threshold is
+ * reached the circuit opens and the calls fail immediately for OpenDuration. After that
+ * a single call is let through: if it succeeds the circuit closes, otherwise it opens
+ * again.
+ */
+type CircuitBreaker struct {
+	Name             string
+	FailureThreshold int
+	OpenDuration     time.Duration
+
+	mutex    sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func NewCircuitBreaker(name string) *CircuitBreaker {
+	return &CircuitBreaker{
+		Name:             name,
+		FailureThreshold: DefaultFailureThreshold,
+		OpenDuration:     DefaultOpenDuration,
+	}
+}
+
+// Allow reports whether a call can be made.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch cb.state {
+	case stateOpen:
+		if time.Since(cb.openedAt) < cb.OpenDuration {
+			return false
+		}
+		cb.state = stateHalfOpen
+		return true
+	case stateHalfOpen:
+		// A trial call is already running
+		return false
+	default:
+		return true
+	}
+}
+
+func (cb *CircuitBreaker) Success() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.state = stateClosed
+	cb.failures = 0
+}
+
+func (cb *CircuitBreaker) Failure() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.failures++
+	if cb.state == stateHalfOpen || cb.failures >= cb.FailureThreshold {
+		if cb.state != stateOpen {
+			log.Printf("[WARNING] Circuit breaker for %s opened", cb.Name)
+		}
+		cb.state = stateOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// Release ends a call that tells nothing about the provider, like a cancelled one. If the
+// circuit is half-open, the trial call is given back so another one can run.
+func (cb *CircuitBreaker) Release() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.state == stateHalfOpen {
+		cb.state = stateOpen
+		cb.openedAt = time.Now().Add(-cb.OpenDuration)
+	}
+}
+
+// Like in Transport.RoundTrip, only the 5xx and the network errors are the provider's fault.
+// An invalid request or key mustn't open the circuit for everyone.
+func isProviderFailure(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Call runs fn through the circuit breaker. It's used for the SDKs we can't give an
+// http.Client to.
+func (cb *CircuitBreaker) Call(fn func() error) error {
+	if !cb.Allow() {
+		return ErrCircuitOpen
+	}
+
+	err := fn()
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		cb.Release()
+	case isProviderFailure(err):
+		cb.Failure()
+	default:
+		cb.Success()
+	}
+
+	return err
+}
+
+var (
+	breakers      = map[string]*CircuitBreaker{}
+	breakersMutex sync.Mutex
+)
+
+// Breaker returns the circuit breaker shared by all the calls to a provider.
+func Breaker(provider string) *CircuitBreaker {
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+
+	cb, ok := breakers[provider]
+	if !ok {
+		cb = NewCircuitBreaker(provider)
+		breakers[provider] = cb
+	}
+
+	return cb
+}
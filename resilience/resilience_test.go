@@ -0,0 +1,176 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/polyfire/api/utils"
+)
+
+func testClient(name string, server *httptest.Server) *http.Client {
+	client := WrapClient(name, server.Client())
+	transport := client.Transport.(*Transport)
+	transport.BaseDelay = time.Millisecond
+	transport.MaxDelay = 10 * time.Millisecond
+	return client
+}
+
+func TestRetryOnServerError(t *testing.T) {
+	utils.SetLogLevel("WARN")
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "body" {
+			t.Errorf(`The body should be replayed on each attempt, got "%s"`, body)
+		}
+
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := testClient("test-retry", server)
+
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf(`Request returned an error: %v`, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || calls != 3 {
+		t.Fatalf(`Expected a 200 after 3 calls, got %d after %d calls`, resp.StatusCode, calls)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	utils.SetLogLevel("WARN")
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := testClient("test-retry-after", server)
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf(`Request returned an error: %v`, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests || calls != 1 {
+		t.Fatalf(`Expected the 429 to be returned directly, got %d after %d calls`, resp.StatusCode, calls)
+	}
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	utils.SetLogLevel("WARN")
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := testClient("test-breaker", server)
+	client.Transport.(*Transport).MaxRetries = 0
+
+	for i := 0; i < DefaultFailureThreshold; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf(`Request %d returned an error: %v`, i, err)
+		}
+		resp.Body.Close()
+	}
+
+	_, err := client.Get(server.URL)
+	if err == nil || !strings.Contains(err.Error(), ErrCircuitOpen.Error()) {
+		t.Fatalf(`The circuit should be open, got error: %v`, err)
+	}
+
+	if calls != DefaultFailureThreshold {
+		t.Fatalf(`The open circuit shouldn't have called the server, got %d calls`, calls)
+	}
+}
+
+func TestCallOnlyCountsProviderFailures(t *testing.T) {
+	utils.SetLogLevel("WARN")
+
+	cb := NewCircuitBreaker("test-call")
+	for i := 0; i < DefaultFailureThreshold; i++ {
+		_ = cb.Call(func() error { return context.Canceled })
+		_ = cb.Call(func() error { return &StatusError{StatusCode: 401, Err: errors.New("key")} })
+	}
+
+	if !cb.Allow() {
+		t.Fatal(`The cancelled calls and the 4xx shouldn't open the circuit`)
+	}
+
+	for i := 0; i < DefaultFailureThreshold; i++ {
+		_ = cb.Call(func() error { return &StatusError{StatusCode: 503, Err: errors.New("down")} })
+	}
+
+	if cb.Allow() {
+		t.Fatal(`The 5xx should open the circuit`)
+	}
+}
+
+func TestCancelledTrialIsReleased(t *testing.T) {
+	utils.SetLogLevel("WARN")
+
+	cb := NewCircuitBreaker("test-release")
+	cb.OpenDuration = time.Millisecond
+	for i := 0; i < DefaultFailureThreshold; i++ {
+		cb.Failure()
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	_ = cb.Call(func() error { return context.Canceled })
+
+	if err := cb.Call(func() error { return nil }); err != nil {
+		t.Fatalf(`A new trial should run after a cancelled one, got error: %v`, err)
+	}
+}
+
+func TestCallRetriesProviderFailures(t *testing.T) {
+	utils.SetLogLevel("WARN")
+
+	transport := NewTransport("test-call-retries", nil)
+	transport.BaseDelay = time.Millisecond
+
+	calls := 0
+	err := transport.Call(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return &StatusError{StatusCode: 503, Err: errors.New("down")}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf(`The call should succeed after 2 retries, got %d calls and error: %v`, calls, err)
+	}
+
+	calls = 0
+	_ = transport.Call(context.Background(), func() error {
+		calls++
+		return &StatusError{StatusCode: 400, Err: errors.New("invalid")}
+	})
+	if calls != 1 {
+		t.Fatalf(`An invalid request shouldn't be retried, got %d calls`, calls)
+	}
+}
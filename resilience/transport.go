@@ -0,0 +1,175 @@
+package resilience
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultMaxRetries = 3
+	DefaultBaseDelay  = 500 * time.Millisecond
+	DefaultMaxDelay   = 10 * time.Second
+)
+
+/*
+ * Transport retries the requests failing with a 429, a 5xx or a network error, with an
+ * exponential backoff and full jitter. A Retry-After header is respected as long as it
+ * isn't longer than MaxDelay, otherwise the response is returned as is.
+ *
+ * The requests whose body can't be replayed (no GetBody) are only tried once.
+ */
+type Transport struct {
+	Base       http.RoundTripper
+	Breaker    *CircuitBreaker
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+func NewTransport(provider string, base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Transport{
+		Base:       base,
+		Breaker:    Breaker(provider),
+		MaxRetries: DefaultMaxRetries,
+		BaseDelay:  DefaultBaseDelay,
+		MaxDelay:   DefaultMaxDelay,
+	}
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func (t *Transport) backoff(attempt int) time.Duration {
+	delay := t.BaseDelay << attempt
+	if delay > t.MaxDelay || delay <= 0 {
+		delay = t.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// retryAfter parses the Retry-After header, either in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date), true
+	}
+
+	return 0, false
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.Breaker.Allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	canRetry := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				t.Breaker.Release()
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		resp, err := t.Base.RoundTrip(req)
+
+		// A cancelled request isn't the provider's fault
+		if req.Context().Err() != nil {
+			t.Breaker.Release()
+			return resp, err
+		}
+
+		// Being rate limited means the provider is up, only errors open the circuit
+		if err != nil || resp.StatusCode >= 500 {
+			t.Breaker.Failure()
+		} else {
+			t.Breaker.Success()
+		}
+
+		if !canRetry || attempt >= t.MaxRetries ||
+			(err == nil && !isRetryableStatus(resp.StatusCode)) {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if err == nil {
+			if after, ok := retryAfter(resp); ok {
+				if after > t.MaxDelay {
+					return resp, nil
+				}
+				delay = after
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if !t.Breaker.Allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		select {
+		case <-req.Context().Done():
+			t.Breaker.Release()
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Client returns an http.Client retrying the failed requests to a provider and sharing
+// its circuit breaker.
+func Client(provider string) *http.Client {
+	return &http.Client{Transport: NewTransport(provider, nil)}
+}
+
+// WrapClient adds the retries and the circuit breaker of a provider to an existing client.
+func WrapClient(provider string, client *http.Client) *http.Client {
+	wrapped := *client
+	wrapped.Transport = NewTransport(provider, client.Transport)
+	return &wrapped
+}
+
+/*
+ * Call runs fn through the circuit breaker and retries it like the requests when it fails
+ * because of the provider. It's used for the SDKs we can't give an http.Client to, fn
+ * must be safe to repeat.
+ */
+func (t *Transport) Call(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := t.Breaker.Call(fn)
+		if err == nil || attempt >= t.MaxRetries || !isProviderFailure(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(t.backoff(attempt)):
+		}
+	}
+}
+
+// Call retries fn with the circuit breaker of a provider, see Transport.Call.
+func Call(ctx context.Context, provider string, fn func() error) error {
+	return NewTransport(provider, nil).Call(ctx, fn)
+}
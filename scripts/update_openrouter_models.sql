@@ -10,16 +10,21 @@ CREATE TEMP TABLE openrouter_models(
 	hidden boolean,
 	option_stream boolean,
 	option_temperature boolean,
-	option_stop boolean
+	option_stop boolean,
+	context_window integer,
+	option_vision boolean
 );
-\copy openrouter_models FROM 'codegen/openrouter-models.csv' DELIMITER ',' CSV HEADER ;
+\copy openrouter_models FROM 'build/openrouter-models.csv' DELIMITER ',' CSV HEADER ;
 UPDATE models
 SET
 	credit_input = openrouter_models.credit_input,
   credit_output = openrouter_models.credit_output,
   option_stream = openrouter_models.option_stream,
   option_temperature = openrouter_models.option_temperature,
-  option_stop = openrouter_models.option_stop
+  option_stop = openrouter_models.option_stop,
+  context_window = openrouter_models.context_window,
+  option_vision = openrouter_models.option_vision,
+  aliases = COALESCE(models.aliases, ARRAY[models.model])
 FROM openrouter_models
 WHERE models.provider = 'openrouter' AND models.model = openrouter_models.model;
 DELETE FROM openrouter_models WHERE model IN (SELECT model FROM models WHERE provider = 'openrouter');
@@ -35,5 +40,8 @@ INSERT INTO models(
 	hidden,
 	option_stream,
 	option_temperature,
-	option_stop
-) SELECT model, provider, credit_input, credit_type, type, credit_output, image_url, official_name, hidden, option_stream, option_temperature, option_stop FROM openrouter_models;
+	option_stop,
+	context_window,
+	option_vision,
+	aliases
+) SELECT model, provider, credit_input, credit_type, type, credit_output, image_url, official_name, hidden, option_stream, option_temperature, option_stop, context_window, option_vision, ARRAY[model] FROM openrouter_models;
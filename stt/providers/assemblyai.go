@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	aai "github.com/AssemblyAI/assemblyai-go-sdk"
+	"github.com/polyfire/api/resilience"
 )
 
 type AssemblyAIProvider struct{}
@@ -49,11 +50,14 @@ func keywordBoostToAssemblyAIKeyword(keywordBoosts []KeywordBoost) []string {
 }
 
 func (AssemblyAIProvider) Transcribe(
-	_ context.Context,
+	ctx context.Context,
 	reader io.Reader,
 	opts TranscriptionInputOptions,
 ) (*TranscriptionResult, error) {
-	client := aai.NewClient(os.Getenv("ASSEMBLYAI_API_KEY"))
+	client := aai.NewClientWithOptions(
+		aai.WithAPIKey(os.Getenv("ASSEMBLYAI_API_KEY")),
+		aai.WithHTTPClient(resilience.Client("assemblyai")),
+	)
 
 	language := "en_US"
 	if opts.Language != nil {
@@ -69,7 +73,7 @@ func (AssemblyAIProvider) Transcribe(
 	}
 
 	transcript, err := client.Transcripts.TranscribeFromReader(
-		context.Background(),
+		ctx,
 		reader,
 		&params,
 	)
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/deepgram-devs/deepgram-go-sdk/deepgram"
+	"github.com/polyfire/api/resilience"
 )
 
 type DeepgramProvider struct{}
@@ -120,21 +121,28 @@ func (DeepgramProvider) Transcribe(
 		language = *(opts.Language)
 	}
 
-	res, err := dg.PreRecordedFromStream(
-		deepgram.ReadStreamSource{
-			Stream:   reader,
-			Mimetype: "audio/mp3",
-		},
-		deepgram.PreRecordedTranscriptionOptions{
-			Punctuate:  true,
-			Diarize:    true,
-			Numerals:   true,
-			Language:   language,
-			Utterances: true,
-			Model:      "nova-2",
-			Keywords:   keywordBoostToDeepgramKeyword(opts.Keywords),
-		},
-	)
+	// The SDK doesn't take an http.Client and the audio stream can't be replayed, we can
+	// only use the circuit breaker here.
+	var res *deepgram.PreRecordedResponse
+	err := resilience.Breaker("deepgram").Call(func() error {
+		var err error
+		res, err = dg.PreRecordedFromStream(
+			deepgram.ReadStreamSource{
+				Stream:   reader,
+				Mimetype: "audio/mp3",
+			},
+			deepgram.PreRecordedTranscriptionOptions{
+				Punctuate:  true,
+				Diarize:    true,
+				Numerals:   true,
+				Language:   language,
+				Utterances: true,
+				Model:      "nova-2",
+				Keywords:   keywordBoostToDeepgramKeyword(opts.Keywords),
+			},
+		)
+		return err
+	})
 	if err != nil {
 		fmt.Println("ERROR", err)
 		return nil, err
@@ -4,11 +4,17 @@ import (
 	"context"
 	"io"
 	"log"
+	"net/http"
 	"strings"
+	"time"
 
 	speech "cloud.google.com/go/speech/apiv1p1beta1"
 	speechpb "cloud.google.com/go/speech/apiv1p1beta1/speechpb"
+	gax "github.com/googleapis/gax-go/v2"
+	"github.com/polyfire/api/resilience"
 	"github.com/polyfire/api/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	wrapperspb "google.golang.org/protobuf/types/known/wrapperspb"
 )
 
@@ -18,6 +24,57 @@ func removePunctuation(word string) string {
 	return strings.ToLower(strings.Trim(word, ".,:;"))
 }
 
+// A stopped request cancels the context, it's not an error of the provider
+func googleError(ctx context.Context, message string, err error) error {
+	if ctx.Err() != nil {
+		log.Printf("[INFO] Google transcription cancelled: %v", ctx.Err())
+		return ctx.Err()
+	}
+
+	log.Printf("[ERROR] %s: %v", message, err)
+	return err
+}
+
+// googleRetryer retries the unavailable and rate limited calls like resilience.Transport
+type googleRetryer struct {
+	backoff  gax.Backoff
+	attempts int
+}
+
+func newGoogleRetryer() gax.Retryer {
+	return &googleRetryer{backoff: gax.Backoff{
+		Initial:    resilience.DefaultBaseDelay,
+		Max:        resilience.DefaultMaxDelay,
+		Multiplier: 2,
+	}}
+}
+
+func (r *googleRetryer) Retry(err error) (time.Duration, bool) {
+	code := status.Code(err)
+	if r.attempts >= resilience.DefaultMaxRetries ||
+		(code != codes.Unavailable && code != codes.ResourceExhausted) {
+		return 0, false
+	}
+
+	r.attempts++
+	return r.backoff.Pause(), true
+}
+
+// The circuit breaker only knows the HTTP statuses, the gRPC errors of the service are
+// given to it as 5xx.
+func googleCallError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.Unknown, codes.DeadlineExceeded:
+		return &resilience.StatusError{StatusCode: http.StatusServiceUnavailable, Err: err}
+	}
+
+	return err
+}
+
 func (GoogleProvider) Transcribe(
 	ctx context.Context,
 	reader io.Reader,
@@ -33,12 +90,10 @@ func (GoogleProvider) Transcribe(
 		return nil, err
 	}
 
-	bgCtx := context.Background()
-
 	// Instantiates a client
-	client, err := speech.NewClient(bgCtx)
+	client, err := speech.NewClient(ctx)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		return nil, googleError(ctx, "Failed to create client", err)
 	}
 	defer client.Close()
 
@@ -74,13 +129,17 @@ func (GoogleProvider) Transcribe(
 		Audio:  &audio,
 	}
 
-	op, err := client.LongRunningRecognize(bgCtx, &request)
-	if err != nil {
-		log.Fatalf("failed to recognize: %v", err)
-	}
-	resp, err := op.Wait(bgCtx)
+	var resp *speechpb.LongRunningRecognizeResponse
+	err = resilience.Breaker("google").Call(func() error {
+		op, err := client.LongRunningRecognize(ctx, &request, gax.WithRetry(newGoogleRetryer))
+		if err != nil {
+			return googleCallError(ctx, err)
+		}
+		resp, err = op.Wait(ctx)
+		return googleCallError(ctx, err)
+	})
 	if err != nil {
-		log.Fatalf("failed to wait for long-running operation: %v", err)
+		return nil, googleError(ctx, "Failed to recognize", err)
 	}
 
 	text := ""
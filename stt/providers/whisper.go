@@ -6,6 +6,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/polyfire/api/resilience"
 	utils "github.com/polyfire/api/utils"
 	openai "github.com/rakyll/openai-go"
 	audio "github.com/rakyll/openai-go/audio"
@@ -31,6 +32,7 @@ func (WhisperProvider) Transcribe(
 		(*session).OrganizationID = os.Getenv("OPENAI_ORGANIZATION")
 	}
 
+	(*session).HTTPClient = resilience.Client("openai")
 	(*((*session).HTTPClient)).Timeout = 600 * time.Second
 
 	client := audio.NewClient(session, "whisper-1")
@@ -40,9 +42,7 @@ func (WhisperProvider) Transcribe(
 		AudioFormat: opts.Format,
 	}
 
-	transcriptionCtx := context.Background()
-
-	response, err := client.CreateTranscription(transcriptionCtx, &params)
+	response, err := client.CreateTranscription(ctx, &params)
 	if err != nil {
 		return nil, err
 	}
@@ -15,6 +15,7 @@ import (
 	router "github.com/julienschmidt/httprouter"
 
 	database "github.com/polyfire/api/db"
+	"github.com/polyfire/api/resilience"
 	"github.com/polyfire/api/utils"
 )
 
@@ -49,7 +50,28 @@ func TextToSpeech(ctx context.Context, w io.Writer, text string, voiceID string)
 		ModelID: "eleven_multilingual_v2",
 	}
 
-	return client.TextToSpeechStream(w, voiceID, ttsReq)
+	// The audio is streamed to the client as it's generated so a failed request can't be
+	// retried, we only use the circuit breaker. The errors of a custom token are the
+	// user's own, they mustn't open the circuit for everyone.
+	if ok {
+		return client.TextToSpeechStream(w, voiceID, ttsReq)
+	}
+
+	return resilience.Breaker("elevenlabs").Call(func() error {
+		return elevenlabsStatusError(client.TextToSpeechStream(w, voiceID, ttsReq))
+	})
+}
+
+// The SDK only gives the status of the errors other than 400, 401 and 422 in their message
+func elevenlabsStatusError(err error) error {
+	var status int
+	if err != nil {
+		_, scanErr := fmt.Sscanf(err.Error(), "unexpected HTTP status \"%d", &status)
+		if scanErr == nil {
+			return &resilience.StatusError{StatusCode: status, Err: err}
+		}
+	}
+	return err
 }
 
 type RequestBody struct {
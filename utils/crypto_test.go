@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+
+	encrypted, err := Encrypt("sk-test-key")
+	if err != nil {
+		t.Fatalf(`Encrypt("sk-test-key") returned an error: %v`, err)
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf(`Decrypt returned an error: %v`, err)
+	}
+
+	if decrypted != "sk-test-key" {
+		t.Fatalf(`Decrypt(Encrypt("sk-test-key")) should give "sk-test-key". Result = "%s"`, decrypted)
+	}
+}
+
+func TestDecryptWithoutKey(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "")
+
+	_, err := Decrypt("AAAA")
+	if err != ErrInvalidEncryptionKey {
+		t.Fatalf(`Decrypt without ENCRYPTION_KEY should return ErrInvalidEncryptionKey. err = %v`, err)
+	}
+}
@@ -149,11 +149,126 @@ var ErrorMessages = map[string]APIError{
 		Message:    "Json format enforcing needs the word \"json\" to be mentioned in the task",
 		StatusCode: http.StatusBadRequest,
 	},
+	"invalid_batch_size": {
+		Code:       "invalid_batch_size",
+		Message:    "A batch must have between 1 and 1000 items.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"invalid_prompt": {
+		Code:       "invalid_prompt",
+		Message:    "A prompt must have a name and a prompt.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"invalid_slug": {
+		Code:       "invalid_slug",
+		Message:    "The slug must only contain lowercase letters, digits, - and _.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"prompt_already_exists": {
+		Code:       "prompt_already_exists",
+		Message:    "A prompt with this name or slug already exists.",
+		StatusCode: http.StatusConflict,
+	},
+	"invalid_prompt_version": {
+		Code:       "invalid_prompt_version",
+		Message:    "A prompt version must be given as \"id_or_slug@version\" with a version above 0.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"prompt_version_not_found": {
+		Code:       "prompt_version_not_found",
+		Message:    "This version of the prompt doesn't exist.",
+		StatusCode: http.StatusNotFound,
+	},
+	"invalid_experiment": {
+		Code:       "invalid_experiment",
+		Message:    "An experiment needs a name and variants of existing versions whose weights add up to 100.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"invalid_webhook_url": {
+		Code:       "invalid_webhook_url",
+		Message:    "The webhook_url must be a valid https URL.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"job_interrupted": {
+		Code:       "job_interrupted",
+		Message:    "The job was interrupted by a restart and its token has been revoked since.",
+		StatusCode: http.StatusInternalServerError,
+	},
+	"invalid_chat_branch": {
+		Code:       "invalid_chat_branch",
+		Message:    "Only a user message can be edited and only an answer can be regenerated, without a task.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"invalid_export_format": {
+		Code:       "invalid_export_format",
+		Message:    "The format must be json or markdown.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"invalid_chat_import": {
+		Code:       "invalid_chat_import",
+		Message:    "The chat must be a chat export or a list of messages.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"chat_import_too_large": {
+		Code:       "chat_import_too_large",
+		Message:    "The chat to import is too large.",
+		StatusCode: http.StatusRequestEntityTooLarge,
+	},
+	"invalid_share_expiry": {
+		Code:       "invalid_share_expiry",
+		Message:    "The expires_at of a share must be in the future.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"shared_chat_too_long": {
+		Code:       "shared_chat_too_long",
+		Message:    "The shared chat has too many messages to be viewed or forked, it can be exported by its owner.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"invalid_json_schema": {
+		Code:       "invalid_json_schema",
+		Message:    "The json_schema must be a JSON schema object.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"invalid_json_schema_retries": {
+		Code:       "invalid_json_schema_retries",
+		Message:    "The json_schema_retries must be between 0 and 5.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"json_schema_incompatible_options": {
+		Code:       "json_schema_incompatible_options",
+		Message:    "The json_schema can't be used with tools, auto_complete, n, or logprobs.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"json_schema_validation_failed": {
+		Code:       "json_schema_validation_failed",
+		Message:    "The answer still doesn't match the JSON schema after the retries.",
+		StatusCode: http.StatusUnprocessableEntity,
+	},
 	"invalid_model_provider": {
 		Code:       "invalid_model_provider",
 		Message:    "Provided model provider is unknown.",
 		StatusCode: http.StatusBadRequest,
 	},
+	"invalid_model_configuration": {
+		Code:       "invalid_model_configuration",
+		Message:    "The model is misconfigured for this project. Please check its base URL and API key.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"invalid_max_tokens": {
+		Code:       "invalid_max_tokens",
+		Message:    "max_tokens must be a positive number.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"invalid_image": {
+		Code:       "invalid_image",
+		Message:    "The images must be http(s) URLs, data URLs or base64 encoded images.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"vision_not_supported": {
+		Code:       "vision_not_supported",
+		Message:    "This model doesn't support images. Please use a vision model like gpt-4o or claude-3-5-sonnet.",
+		StatusCode: http.StatusBadRequest,
+	},
 	"only_post_method_allowed": {
 		Code:       "only_post_method_allowed",
 		Message:    "Only POST method is allowed for this endpoint.",
@@ -245,6 +360,16 @@ var ErrorMessages = map[string]APIError{
 		Message:    "Failed to process the embedding.",
 		StatusCode: http.StatusInternalServerError,
 	},
+	"invalid_embedding_model": {
+		Code:       "invalid_embedding_model",
+		Message:    "The embedding model is unknown or isn't configured correctly.",
+		StatusCode: http.StatusBadRequest,
+	},
+	"embedding_model_mismatch": {
+		Code:       "embedding_model_mismatch",
+		Message:    "The memories use different embedding models and can't be searched together.",
+		StatusCode: http.StatusBadRequest,
+	},
 
 	// Not Found Errors
 	"data_not_found": {
@@ -385,6 +510,11 @@ var ErrorMessages = map[string]APIError{
 		Message:    "OpenAI replied with \"Invalid API key\". Please check your custom API key is valid.",
 		StatusCode: http.StatusForbidden,
 	},
+	"anthropic_invalid_api_key": {
+		Code:       "anthropic_invalid_api_key",
+		Message:    "Anthropic replied with \"authentication_error\". Please check your custom API key is valid.",
+		StatusCode: http.StatusForbidden,
+	},
 
 	// Fallback error
 
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrInvalidPublicURL = errors.New("The URL must use https")
+	ErrPrivateAddress   = errors.New("The URL must not target a private address")
+)
+
+// The ranges not covered by the net.IP methods that can still reach internal services
+var reservedNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"100.64.0.0/10", // Carrier-grade NAT, also used by some cloud metadata services
+	"192.0.0.0/24",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"64:ff9b::/96", // NAT64, it could be translated to a private IPv4
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// IsPublicIP rejects the loopback, private, link-local (and so the metadata endpoints),
+// multicast and reserved addresses.
+func IsPublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+ * ValidatePublicURL checks that a URL given by a user uses https and that its host only
+ * resolves to public addresses. The host can still be changed to resolve to another address
+ * after that, the requests must also be sent with PublicHTTPTransport.
+ */
+func ValidatePublicURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return ErrInvalidPublicURL
+	}
+
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addresses) == 0 {
+		return ErrPrivateAddress
+	}
+
+	for _, address := range addresses {
+		if !IsPublicIP(address.IP) {
+			return ErrPrivateAddress
+		}
+	}
+
+	return nil
+}
+
+// The address is the resolved one, checking it when dialing protects from DNS rebinding.
+func publicDialControl(_ string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || !IsPublicIP(net.ParseIP(host)) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// PublicHTTPTransport only connects to public addresses. It doesn't use the proxy of the
+// environment since the proxy would be the one dialing the host.
+func PublicHTTPTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   publicDialControl,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return transport
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"context"
+	"testing"
+)
+
+func TestValidatePublicURL(t *testing.T) {
+	tests := map[string]error{
+		"https://8.8.8.8/v1":                  nil,
+		"http://8.8.8.8/v1":                   ErrInvalidPublicURL,
+		"https://127.0.0.1/v1":                ErrPrivateAddress,
+		"https://localhost/v1":                ErrPrivateAddress,
+		"https://10.0.0.1/v1":                 ErrPrivateAddress,
+		"https://169.254.169.254/latest/meta": ErrPrivateAddress,
+		"https://100.100.100.200/":            ErrPrivateAddress,
+		"https://[::1]/v1":                    ErrPrivateAddress,
+		"https://[fd00:ec2::254]/":            ErrPrivateAddress,
+	}
+
+	for url, expected := range tests {
+		if err := ValidatePublicURL(context.Background(), url); err != expected {
+			t.Fatalf(`ValidatePublicURL("%s") should return %v. err = %v`, url, expected, err)
+		}
+	}
+}
+
+func TestPublicDialControl(t *testing.T) {
+	if err := publicDialControl("tcp", "169.254.169.254:80", nil); err != ErrPrivateAddress {
+		t.Fatalf(`Dialing the metadata endpoint should be refused. err = %v`, err)
+	}
+
+	if err := publicDialControl("tcp", "[2606:4700::1111]:443", nil); err != nil {
+		t.Fatalf(`Dialing a public address should be allowed. err = %v`, err)
+	}
+}
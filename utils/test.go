@@ -2,6 +2,7 @@ package utils
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,6 +12,25 @@ import (
 func MockOpenAIServer(ctx context.Context) context.Context {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[INFO] Received request on mock OpenAI server url: %v\n", r.URL.Path)
+		var body struct {
+			N int `json:"n"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		if r.URL.Path == "/chat/completions" && body.N > 1 {
+			fmt.Fprintln(
+				w,
+				`data: {"id":"chatcmpl-mock","object":"chat.completion.chunk","created":1700000000,"model":"gpt-3.5-turbo-0613","system_fingerprint":null,"choices":[{"index":0,"delta":{"content":"Yes"},"logprobs":{"content":[{"token":"Yes","logprob":-0.1,"top_logprobs":[]}]},"finish_reason":null},{"index":1,"delta":{"content":"No"},"logprobs":{"content":[{"token":"No","logprob":-2.3,"top_logprobs":[]}]},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-mock","object":"chat.completion.chunk","created":1700000000,"model":"gpt-3.5-turbo-0613","system_fingerprint":null,"choices":[{"index":0,"delta":{},"logprobs":null,"finish_reason":"stop"},{"index":1,"delta":{},"logprobs":null,"finish_reason":"stop"}]}
+
+data: {"id":"chatcmpl-mock","object":"chat.completion.chunk","created":1700000000,"model":"gpt-3.5-turbo-0613","system_fingerprint":null,"choices":[],"usage":{"prompt_tokens":8,"completion_tokens":2,"total_tokens":10}}
+
+data: [DONE]`,
+			)
+			return
+		}
+
 		if r.URL.Path == "/chat/completions" {
 			fmt.Fprintln(
 				w,
@@ -22,6 +42,8 @@ data: {"id":"chatcmpl-mock","object":"chat.completion.chunk","created":170000000
 
 data: {"id":"chatcmpl-mock","object":"chat.completion.chunk","created":1700000000,"model":"gpt-3.5-turbo-0613","system_fingerprint":null,"choices":[{"index":0,"delta":{},"logprobs":null,"finish_reason":"stop"}]}
 
+data: {"id":"chatcmpl-mock","object":"chat.completion.chunk","created":1700000000,"model":"gpt-3.5-turbo-0613","system_fingerprint":null,"choices":[],"usage":{"prompt_tokens":8,"completion_tokens":2,"total_tokens":10}}
+
 data: [DONE]`,
 			)
 		}
@@ -60,3 +82,76 @@ data: [DONE]`,
 
 	return ctx
 }
+
+func MockAnthropicServer(ctx context.Context) context.Context {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("[INFO] Received request on mock Anthropic server url: %v\n", r.URL.Path)
+		var body struct {
+			Tools []interface{} `json:"tools"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		if r.URL.Path == "/messages" && len(body.Tools) > 0 {
+			fmt.Fprintln(
+				w,
+				`event: message_start
+data: {"type":"message_start","message":{"id":"msg_mock","type":"message","role":"assistant","content":[],"model":"claude-3-haiku-20240307","stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":8,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_mock","name":"get_weather","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":" \"Paris\"}"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":5}}
+
+event: message_stop
+data: {"type":"message_stop"}`,
+			)
+		} else if r.URL.Path == "/messages" {
+			fmt.Fprintln(
+				w,
+				`event: message_start
+data: {"type":"message_start","message":{"id":"msg_mock","type":"message","role":"assistant","content":[],"model":"claude-3-haiku-20240307","stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":8,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: ping
+data: {"type":"ping"}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Test"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" response"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":2}}
+
+event: message_stop
+data: {"type":"message_stop"}`,
+			)
+		}
+	}))
+
+	ctx = context.WithValue(
+		ctx,
+		ContextKeyHTTPClient,
+		server.Client(),
+	)
+
+	ctx = context.WithValue(ctx, ContextKeyAnthropicBaseURL, server.URL)
+
+	return ctx
+}
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"context"
 	"log"
 	"os"
+	"time"
 
 	"github.com/hashicorp/logutils"
 )
@@ -25,6 +27,8 @@ const (
 	ContextKeyUserID                ContextKey = "userId"
 	ContextKeyRateLimitStatus       ContextKey = "rateLimitStatus"
 	ContextKeyCreditsStatus         ContextKey = "creditsStatus"
+	ContextKeyCredits               ContextKey = "credits"
+	ContextKeyTokenVersion          ContextKey = "tokenVersion"
 	ContextKeyRecordEvent           ContextKey = "recordEvent"
 	ContextKeyRecordEventWithUserID ContextKey = "recordEventWithUserID"
 	ContextKeyRecordEventRequest    ContextKey = "recordEventRequest"
@@ -39,6 +43,8 @@ const (
 	ContextKeyProjectUserRateLimit  ContextKey = "projectUserRateLimit"
 	ContextKeyHTTPClient            ContextKey = "httpClient"
 	ContextKeyOpenAIBaseURL         ContextKey = "openAIBaseURL"
+	ContextKeyAnthropicToken        ContextKey = "anthropicToken"
+	ContextKeyAnthropicBaseURL      ContextKey = "anthropicBaseURL"
 )
 
 type EventType string
@@ -57,12 +63,25 @@ const (
 
 	Usage EventType = "auth.user.usage"
 
-	Generate    EventType = "models.completion.generate"
-	ChatHistory EventType = "models.chat.history"
-	ChatCreate  EventType = "models.chat.create"
-	ChatUpdate  EventType = "models.chat.update"
-	ChatDelete  EventType = "models.chat.delete"
-	ChatList    EventType = "models.chat.list"
+	Generate      EventType = "models.completion.generate"
+	GenerateBatch EventType = "models.completion.generate_batch"
+	ChatHistory   EventType = "models.chat.history"
+	ChatCreate    EventType = "models.chat.create"
+	ChatUpdate    EventType = "models.chat.update"
+	ChatDelete    EventType = "models.chat.delete"
+	ChatList      EventType = "models.chat.list"
+	ChatBranch    EventType = "models.chat.branch"
+	ChatExport    EventType = "models.chat.export"
+	ChatImport    EventType = "models.chat.import"
+
+	ChatShareCreate EventType = "models.chat.share.create"
+	ChatShareList   EventType = "models.chat.share.list"
+	ChatShareRevoke EventType = "models.chat.share.revoke"
+	ChatSharedGet   EventType = "models.chat.shared.get"
+	ChatSharedFork  EventType = "models.chat.shared.fork"
+
+	JobCreate EventType = "models.job.create"
+	JobGet    EventType = "models.job.get"
 
 	SpeechToText EventType = "models.stt.transcribe"
 	TextToSpeech EventType = "models.tts.synthesize"
@@ -85,8 +104,31 @@ const (
 	PromptCreate EventType = "data.prompt.create"
 	PromptUpdate EventType = "data.prompt.update"
 	PromptDelete EventType = "data.prompt.delete"
+
+	PromptLibrary EventType = "data.prompt.library"
+	PromptUnlike  EventType = "data.prompt.unlike"
+	PromptFork    EventType = "data.prompt.fork"
+
+	PromptVersionList      EventType = "data.prompt.version.list"
+	PromptExperimentList   EventType = "data.prompt.experiment.list"
+	PromptExperimentCreate EventType = "data.prompt.experiment.create"
+	PromptExperimentStop   EventType = "data.prompt.experiment.stop"
 )
 
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// Detach keeps the values of a context but not its cancellation, for the work that must
+// continue once the request that started it is over.
+func Detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
 func SetLogLevel(lvl string) {
 	filter := &logutils.LevelFilter{
 		Levels:   []logutils.LogLevel{"DEBUG", "INFO", "WARN", "ERROR"},